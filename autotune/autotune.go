@@ -0,0 +1,175 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package autotune sets GOMAXPROCS and the Go memory limit from the cgroup
+// limits of the current process, on cgroup v1 and v2.
+//
+// The settings given with the GOMAXPROCS and GOMEMLIMIT environment
+// variables take precedence and are left untouched, as are the settings of
+// the runtime when the cgroup has no limit. Since Go 1.25, the runtime derives
+// GOMAXPROCS from the CPU limit itself: it is restored to the runtime default
+// with runtime.SetDefaultGOMAXPROCS rather than overridden, which would stop
+// the automatic updates.
+package autotune
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+const (
+	defaultMemoryHeadroom = 0.1
+	defaultInterval       = 30 * time.Second
+)
+
+// Limits are the limits of the cgroup of the process.
+type Limits struct {
+	// CPU is the CPU quota in cores, or 0 when not limited.
+	CPU float64
+	// Memory is the memory limit in bytes, or 0 when not limited. On
+	// cgroup v2, it is the lowest memory.max or memory.high of the cgroup
+	// and its ancestors.
+	Memory uint64
+}
+
+type config struct {
+	memoryHeadroom float64
+	interval       time.Duration
+	// cpuSet and memorySet are set while a limit applied by apply is in
+	// effect, so that the runtime defaults are restored when it is removed.
+	cpuSet    bool
+	memorySet bool
+}
+
+// Opt configures Apply and Watch.
+type Opt func(c *config) error
+
+// WithMemoryHeadroom sets the fraction of the memory limit kept for memory
+// not managed by the Go runtime, such as cgo allocations. It defaults to
+// 0.1, setting the Go memory limit to 90% of the cgroup limit.
+func WithMemoryHeadroom(headroom float64) Opt {
+	return func(c *config) error {
+		if headroom < 0 || headroom >= 1 {
+			return fmt.Errorf("autotune: memory headroom %g is not within [0, 1)", headroom)
+		}
+		c.memoryHeadroom = headroom
+		return nil
+	}
+}
+
+// WithInterval sets how often Watch checks the limits. It defaults to 30s.
+func WithInterval(interval time.Duration) Opt {
+	return func(c *config) error {
+		if interval <= 0 {
+			return fmt.Errorf("autotune: invalid interval %s", interval)
+		}
+		c.interval = interval
+		return nil
+	}
+}
+
+func newConfig(opts []Opt) (*config, error) {
+	c := &config{
+		memoryHeadroom: defaultMemoryHeadroom,
+		interval:       defaultInterval,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Apply sets GOMAXPROCS and the Go memory limit from the current limits of
+// the cgroup of the process, and returns them.
+func Apply(opts ...Opt) (Limits, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return Limits{}, err
+	}
+	l, err := Discover()
+	if err != nil {
+		return Limits{}, err
+	}
+	c.apply(l)
+	return l, nil
+}
+
+// Watch applies the limits like Apply, then checks them every interval and
+// applies them again when they change, until ctx is done. Errors reading the
+// limits after the first time are logged.
+func Watch(ctx context.Context, opts ...Opt) error {
+	c, err := newConfig(opts)
+	if err != nil {
+		return err
+	}
+	current, err := Discover()
+	if err != nil {
+		return err
+	}
+	c.apply(current)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		l, err := Discover()
+		if err != nil {
+			log.G(ctx).WithError(err).Warn("autotune: failed to read the cgroup limits")
+			continue
+		}
+		if l != current {
+			log.G(ctx).Debugf("autotune: cgroup limits changed from %+v to %+v", current, l)
+			current = l
+			c.apply(l)
+		}
+	}
+}
+
+func (c *config) apply(l Limits) {
+	if _, ok := os.LookupEnv("GOMAXPROCS"); !ok && (l.CPU > 0 || c.cpuSet) {
+		setMaxProcs(l.CPU)
+		c.cpuSet = l.CPU > 0
+	}
+	if _, ok := os.LookupEnv("GOMEMLIMIT"); !ok && (l.Memory > 0 || c.memorySet) {
+		debug.SetMemoryLimit(memoryLimit(l.Memory, c.memoryHeadroom))
+		c.memorySet = l.Memory > 0
+	}
+}
+
+// memoryLimit returns the Go memory limit for a cgroup memory limit, no
+// limit being math.MaxInt64 like for the runtime.
+func memoryLimit(limit uint64, headroom float64) int64 {
+	if limit == 0 {
+		return math.MaxInt64
+	}
+	v := float64(limit) * (1 - headroom)
+	if v >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(v)
+}
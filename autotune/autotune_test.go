@@ -0,0 +1,95 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package autotune
+
+import (
+	"context"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	assert.Equal(t, int64(math.MaxInt64), memoryLimit(0, 0.1))
+	assert.Equal(t, int64(900), memoryLimit(1000, 0.1))
+	assert.Equal(t, int64(1000), memoryLimit(1000, 0))
+	assert.Equal(t, int64(math.MaxInt64), memoryLimit(math.MaxUint64, 0))
+}
+
+func TestOpts(t *testing.T) {
+	_, err := newConfig([]Opt{WithMemoryHeadroom(1)})
+	assert.Error(t, err)
+	_, err = newConfig([]Opt{WithInterval(0)})
+	assert.Error(t, err)
+
+	c, err := newConfig([]Opt{WithMemoryHeadroom(0.25)})
+	require.NoError(t, err)
+	assert.Equal(t, 0.25, c.memoryHeadroom)
+	assert.Equal(t, defaultInterval, c.interval)
+}
+
+func TestApply(t *testing.T) {
+	for _, env := range []string{"GOMAXPROCS", "GOMEMLIMIT"} {
+		if _, ok := os.LookupEnv(env); ok {
+			t.Skipf("%s is set", env)
+		}
+	}
+	procs, limit := runtime.GOMAXPROCS(0), debug.SetMemoryLimit(-1)
+	t.Cleanup(func() {
+		runtime.GOMAXPROCS(procs)
+		debug.SetMemoryLimit(limit)
+	})
+
+	// Without cgroup limits, the runtime settings are left alone.
+	runtime.GOMAXPROCS(3)
+	debug.SetMemoryLimit(1 << 40)
+	c, err := newConfig(nil)
+	require.NoError(t, err)
+	c.apply(Limits{})
+	assert.Equal(t, 3, runtime.GOMAXPROCS(0))
+	assert.Equal(t, int64(1<<40), debug.SetMemoryLimit(-1))
+
+	c.apply(Limits{Memory: 1 << 30})
+	assert.Equal(t, 3, runtime.GOMAXPROCS(0))
+	assert.Equal(t, memoryLimit(1<<30, defaultMemoryHeadroom), debug.SetMemoryLimit(-1))
+
+	// The memory limit is reset when the cgroup limit is removed.
+	c.apply(Limits{})
+	assert.Equal(t, int64(math.MaxInt64), debug.SetMemoryLimit(-1))
+}
+
+func TestWatch(t *testing.T) {
+	if _, err := os.Stat("/proc/self/cgroup"); err != nil {
+		t.Skip("no /proc/self/cgroup")
+	}
+	procs, limit := runtime.GOMAXPROCS(0), debug.SetMemoryLimit(-1)
+	t.Cleanup(func() {
+		runtime.GOMAXPROCS(procs)
+		debug.SetMemoryLimit(limit)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, Watch(ctx, WithInterval(10*time.Millisecond)))
+	assert.Error(t, Watch(ctx, WithMemoryHeadroom(-1)))
+}
@@ -0,0 +1,207 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package autotune
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/cgroups/v3/cgroup2"
+	"github.com/containerd/cgroups/v3/internal/proc"
+)
+
+// v1Unlimited is above any v1 memory limit that is set, the kernel reporting
+// no limit as the largest page aligned value.
+const v1Unlimited = 1 << 62
+
+// Discover returns the limits of the cgroup of the current process. The
+// controllers mounted in v1 hierarchies, e.g. on hybrid hosts, are read
+// from there and the others from the unified hierarchy.
+func Discover() (Limits, error) {
+	mounts, err := proc.ParseMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return Limits{}, err
+	}
+	legacy, unified, err := proc.ParseCgroupFile("/proc/self/cgroup")
+	if err != nil {
+		return Limits{}, err
+	}
+	return discover(mounts, legacy, unified)
+}
+
+func discover(mounts []proc.Mount, legacy map[string]string, unified string) (Limits, error) {
+	var l Limits
+	memory, v1Memory := v1Dir(mounts, legacy, "memory")
+	cpu, v1CPU := v1Dir(mounts, legacy, "cpu")
+	if !v1Memory || !v1CPU {
+		for _, m := range mounts {
+			if m.Version != 2 || unified == "" {
+				continue
+			}
+			limits, err := v2Limits(m.Mountpoint, cgroupPath(m, unified))
+			if err != nil {
+				return Limits{}, err
+			}
+			if !v1Memory {
+				l.Memory = limits.Memory
+			}
+			if !v1CPU {
+				l.CPU = limits.CPU
+			}
+			break
+		}
+	}
+	if v1Memory {
+		v, err := v1MemoryLimit(memory)
+		if err != nil {
+			return Limits{}, err
+		}
+		l.Memory = v
+	}
+	if v1CPU {
+		v, err := v1CPULimit(cpu.mountpoint, cpu.path)
+		if err != nil {
+			return Limits{}, err
+		}
+		l.CPU = v
+	}
+	return l, nil
+}
+
+// cgroupPath returns the cgroup path, as read from /proc/self/cgroup,
+// relative to the mount m. Without a cgroup namespace, the mount root of a
+// container is its own cgroup rather than the root of the hierarchy.
+func cgroupPath(m proc.Mount, path string) string {
+	if m.Root == "/" || m.Root == "" {
+		return path
+	}
+	rel, ok := strings.CutPrefix(path, m.Root)
+	if !ok || rel == "" || rel[0] != '/' {
+		return "/"
+	}
+	return rel
+}
+
+type v1Cgroup struct {
+	mountpoint string
+	path       string
+}
+
+// v1Dir returns the cgroup of the process in the v1 hierarchy of
+// controller, if any.
+func v1Dir(mounts []proc.Mount, legacy map[string]string, controller string) (v1Cgroup, bool) {
+	path, ok := legacy[controller]
+	if !ok {
+		return v1Cgroup{}, false
+	}
+	for _, m := range mounts {
+		if m.Version != 1 {
+			continue
+		}
+		for _, c := range m.Controllers {
+			if c == controller {
+				return v1Cgroup{mountpoint: m.Mountpoint, path: filepath.Join(m.Mountpoint, cgroupPath(m, path))}, true
+			}
+		}
+	}
+	return v1Cgroup{}, false
+}
+
+func v2Limits(mountpoint, group string) (Limits, error) {
+	m, err := cgroup2.Load(group, cgroup2.WithMountpoint(mountpoint))
+	if err != nil {
+		return Limits{}, err
+	}
+	limits, err := m.EffectiveLimits()
+	if err != nil {
+		return Limits{}, err
+	}
+	var l Limits
+	for _, e := range []*cgroup2.EffectiveLimit{limits.Memory, limits.MemoryHigh} {
+		if e != nil && (l.Memory == 0 || e.Value < l.Memory) {
+			l.Memory = e.Value
+		}
+	}
+	if limits.CPU != nil {
+		l.CPU = limits.CPU.Cores
+	}
+	return l, nil
+}
+
+// v1MemoryLimit returns the memory limit of the cgroup, including the
+// limits of its ancestors.
+func v1MemoryLimit(c v1Cgroup) (uint64, error) {
+	b, err := os.ReadFile(filepath.Join(c.path, "memory.stat"))
+	if err != nil {
+		return 0, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(s.Text(), "hierarchical_memory_limit "); ok {
+			limit, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			if limit >= v1Unlimited {
+				return 0, nil
+			}
+			return limit, nil
+		}
+	}
+	return 0, s.Err()
+}
+
+// v1CPULimit returns the lowest CFS quota, in cores, of the cgroup at path
+// and its ancestors up to mountpoint.
+func v1CPULimit(mountpoint, path string) (float64, error) {
+	var cores float64
+	for dir := path; ; dir = filepath.Dir(dir) {
+		quota, err := readInt(filepath.Join(dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			return 0, err
+		}
+		period, err := readInt(filepath.Join(dir, "cpu.cfs_period_us"))
+		if err != nil {
+			return 0, err
+		}
+		if quota > 0 && period > 0 {
+			if c := float64(quota) / float64(period); cores == 0 || c < cores {
+				cores = c
+			}
+		}
+		if dir == mountpoint || len(dir) <= len(mountpoint) {
+			break
+		}
+	}
+	return cores, nil
+}
+
+// readInt reads an integer file, returning -1 when it does not exist.
+func readInt(path string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return -1, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
@@ -0,0 +1,95 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package autotune
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/cgroups/v3/internal/proc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content+"\n"), 0o644))
+	}
+}
+
+func TestDiscoverUnified(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"kubepods/memory.max":      "2147483648",
+		"kubepods/cpu.max":         "200000 100000",
+		"kubepods/pod/memory.max":  "max",
+		"kubepods/pod/memory.high": "1073741824",
+		"kubepods/pod/cpu.max":     "50000 100000",
+	})
+	mounts := []proc.Mount{{Mountpoint: root, Root: "/", Version: 2}}
+
+	l, err := discover(mounts, nil, "/kubepods/pod")
+	require.NoError(t, err)
+	assert.Equal(t, Limits{CPU: 0.5, Memory: 1 << 30}, l)
+
+	// Without a cgroup namespace, the container only sees its own cgroup.
+	mounts = []proc.Mount{{Mountpoint: filepath.Join(root, "kubepods"), Root: "/kubepods", Version: 2}}
+	l, err = discover(mounts, nil, "/kubepods/pod")
+	require.NoError(t, err)
+	assert.Equal(t, Limits{CPU: 0.5, Memory: 1 << 30}, l)
+}
+
+func TestDiscoverLegacy(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"memory/docker/abc/memory.stat":            "cache 0\nhierarchical_memory_limit 536870912",
+		"cpu,cpuacct/docker/cpu.cfs_quota_us":      "150000",
+		"cpu,cpuacct/docker/cpu.cfs_period_us":     "100000",
+		"cpu,cpuacct/docker/abc/cpu.cfs_quota_us":  "-1",
+		"cpu,cpuacct/docker/abc/cpu.cfs_period_us": "100000",
+	})
+	mounts := []proc.Mount{
+		{Mountpoint: filepath.Join(root, "memory"), Root: "/", Version: 1, Controllers: []string{"memory"}},
+		{Mountpoint: filepath.Join(root, "cpu,cpuacct"), Root: "/", Version: 1, Controllers: []string{"cpu", "cpuacct"}},
+		// The unified hierarchy of a hybrid host has no controller.
+		{Mountpoint: filepath.Join(root, "unified"), Root: "/", Version: 2},
+	}
+	legacy := map[string]string{"memory": "/docker/abc", "cpu": "/docker/abc", "cpuacct": "/docker/abc"}
+
+	l, err := discover(mounts, legacy, "/docker/abc")
+	require.NoError(t, err)
+	assert.Equal(t, Limits{CPU: 1.5, Memory: 512 << 20}, l)
+
+	writeFiles(t, root, map[string]string{
+		"memory/docker/abc/memory.stat": "hierarchical_memory_limit 9223372036854771712",
+	})
+	l, err = discover(mounts, legacy, "/docker/abc")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), l.Memory)
+}
+
+func TestDiscover(t *testing.T) {
+	if _, err := os.Stat("/proc/self/cgroup"); err != nil {
+		t.Skip("no /proc/self/cgroup")
+	}
+	l, err := Discover()
+	require.NoError(t, err)
+	t.Logf("limits: %+v", l)
+}
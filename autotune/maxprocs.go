@@ -0,0 +1,40 @@
+//go:build !go1.25
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package autotune
+
+import (
+	"math"
+	"runtime"
+)
+
+// setMaxProcs sets GOMAXPROCS for a quota of cpu cores, or to the number of
+// CPUs without a quota.
+func setMaxProcs(cpu float64) {
+	runtime.GOMAXPROCS(maxProcs(cpu, runtime.NumCPU()))
+}
+
+// maxProcs returns the GOMAXPROCS for a quota of cpu cores, rounded up so
+// that the quota can be used entirely.
+func maxProcs(cpu float64, numCPU int) int {
+	if cpu <= 0 {
+		return numCPU
+	}
+	procs := int(math.Ceil(cpu))
+	return max(1, min(procs, numCPU))
+}
@@ -0,0 +1,56 @@
+//go:build !go1.25
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package autotune
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxProcs(t *testing.T) {
+	for _, tc := range []struct {
+		cpu    float64
+		numCPU int
+		want   int
+	}{
+		{0, 8, 8},
+		{0.5, 8, 1},
+		{1.5, 8, 2},
+		{4, 8, 4},
+		{16, 8, 8},
+	} {
+		assert.Equal(t, tc.want, maxProcs(tc.cpu, tc.numCPU), "%g cores", tc.cpu)
+	}
+}
+
+func TestApplyMaxProcs(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	t.Cleanup(func() { runtime.GOMAXPROCS(procs) })
+
+	c, err := newConfig(nil)
+	require.NoError(t, err)
+	c.apply(Limits{CPU: 1})
+	assert.Equal(t, 1, runtime.GOMAXPROCS(0))
+	// GOMAXPROCS is reset when the CPU limit is removed.
+	c.apply(Limits{})
+	assert.Equal(t, runtime.NumCPU(), runtime.GOMAXPROCS(0))
+}
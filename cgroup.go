@@ -0,0 +1,130 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/containerd/cgroups/v3/cgroup1/stats"
+	v2 "github.com/containerd/cgroups/v3/cgroup2/stats"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Cgroup is a cgroup managed with the cgroup1 or cgroup2 package, according
+// to the cgroups mode of the host.
+type Cgroup interface {
+	// Mode returns the cgroups mode the cgroup is managed in
+	Mode() CGMode
+	// Update applies the resources to the cgroup
+	Update(resources *specs.LinuxResources) error
+	// Add adds the process with the given id to the cgroup
+	Add(pid uint64) error
+	// Procs returns the processes of the cgroup, and of its descendants when
+	// recursive is set
+	Procs(recursive bool) ([]uint64, error)
+	// Stat returns the stats of the cgroup
+	Stat() (*Stats, error)
+	// Freeze freezes all the processes of the cgroup
+	Freeze() error
+	// Thaw thaws all the processes of the cgroup
+	Thaw() error
+	// Kill kills all the processes of the cgroup
+	Kill() error
+	// Delete removes the cgroup, which must not contain any process. With
+	// the Systemd driver, the unit is stopped instead, killing the processes
+	// left in it
+	Delete() error
+	// EventChan streams the memory events of the cgroup. Both channels are
+	// closed when the stream ends, after an error or when the cgroup is
+	// removed.
+	EventChan() (<-chan Event, <-chan error)
+}
+
+// Stats are the stats of a cgroup, in the format of the cgroup version in
+// use. Both are set in the Hybrid mode, where V2 only holds what the unified
+// hierarchy provides without controllers, such as the pressure stall
+// information.
+type Stats struct {
+	V1 *v1.Metrics
+	V2 *v2.Metrics
+}
+
+// Event holds the counters of the memory events of a cgroup. Low, High and
+// Max are always zero in the Legacy and Hybrid modes.
+type Event struct {
+	Low     uint64
+	High    uint64
+	Max     uint64
+	OOM     uint64
+	OOMKill uint64
+}
+
+// New creates the cgroup at path, relative to the cgroup mountpoint, with
+// the given resources, using the cgroup1 package in the Legacy mode and the
+// cgroup2 package in the Unified mode. In the Hybrid mode, the cgroup is
+// created in the v1 hierarchies, which hold the controllers, and in the
+// unified hierarchy.
+//
+// With the Systemd driver, path is the slice and the name of the unit, e.g.
+// "system.slice/foo.scope".
+//
+// Resources that cannot be expressed in the cgroups mode of the host are
+// reported with an UnsupportedError.
+func New(path string, resources *specs.LinuxResources, opts ...InitOpts) (Cgroup, error) {
+	c, err := newInitConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if resources == nil {
+		resources = &specs.LinuxResources{}
+	}
+	switch c.mode {
+	case Legacy:
+		return newLegacy(c, path, resources)
+	case Hybrid:
+		return newHybrid(c, path, resources)
+	case Unified:
+		return newUnified(c, path, resources)
+	}
+	return nil, ErrUnavailable
+}
+
+// Load loads the existing cgroup at path, see New.
+func Load(path string, opts ...InitOpts) (Cgroup, error) {
+	c, err := newInitConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	switch c.mode {
+	case Legacy:
+		return loadLegacy(c, path)
+	case Hybrid:
+		return loadHybrid(c, path)
+	case Unified:
+		return loadUnified(c, path)
+	}
+	return nil, ErrUnavailable
+}
+
+// splitUnit splits a path used with the Systemd driver into a slice and a
+// unit name.
+func splitUnit(path string) (slice, unit string) {
+	slice, unit = filepath.Split(path)
+	return strings.Trim(slice, "/"), unit
+}
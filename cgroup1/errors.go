@@ -19,6 +19,8 @@ package cgroup1
 import (
 	"errors"
 	"os"
+
+	"github.com/containerd/cgroups/v3/internal/memcheck"
 )
 
 var (
@@ -31,6 +33,13 @@ var (
 	ErrNoCgroupMountDestination = errors.New("cgroups: cannot find cgroup mount destination")
 )
 
+// MemoryLimitError is returned by Update when the memory usage of the cgroup
+// is above the new memory.limit_in_bytes and the resources set
+// Memory.CheckBeforeUpdate, see MemoryCheckBeforeUpdate. Without the check,
+// the kernel reclaims memory and fails with EBUSY if it cannot free enough.
+// It is the same type as cgroup2.MemoryLimitError.
+type MemoryLimitError = memcheck.LimitError
+
 // ErrorHandler is a function that handles and acts on errors
 type ErrorHandler func(err error) error
 
@@ -18,6 +18,7 @@ package cgroup1
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -26,6 +27,8 @@ import (
 	"strings"
 
 	v1 "github.com/containerd/cgroups/v3/cgroup1/stats"
+	"github.com/containerd/cgroups/v3/internal/memcheck"
+
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"golang.org/x/sys/unix"
 )
@@ -158,6 +161,7 @@ func NewMemory(root string, options ...func(*memoryController)) *memoryControlle
 	mc := &memoryController{
 		root:    filepath.Join(root, string(Memory)),
 		ignored: map[string]struct{}{},
+		check:   MemoryCheckRefuse,
 	}
 	for _, opt := range options {
 		opt(mc)
@@ -186,9 +190,36 @@ func OptionalSwap() func(*memoryController) {
 	}
 }
 
+// MemoryCheck is the check made before lowering memory.limit_in_bytes when
+// the resources set Memory.CheckBeforeUpdate. It is the same type as
+// cgroup2.MemoryCheck.
+type MemoryCheck = memcheck.Mode
+
+const (
+	// MemoryCheckNone writes memory.limit_in_bytes without checking the
+	// usage, as if Memory.CheckBeforeUpdate was not set.
+	MemoryCheckNone = memcheck.None
+	// MemoryCheckRefuse fails with a *MemoryLimitError when the usage is
+	// above the new limit. It is the default.
+	MemoryCheckRefuse = memcheck.Refuse
+	// MemoryCheckReclaim writes the new limit, which makes the kernel reclaim
+	// memory, retrying while it fails with EBUSY, and fails with a
+	// *MemoryLimitError if the usage is still above it.
+	MemoryCheckReclaim = memcheck.Reclaim
+)
+
+// MemoryCheckBeforeUpdate selects the check made by Update when the resources
+// set Memory.CheckBeforeUpdate.
+func MemoryCheckBeforeUpdate(check MemoryCheck) func(*memoryController) {
+	return func(mc *memoryController) {
+		mc.check = check
+	}
+}
+
 type memoryController struct {
 	root    string
 	ignored map[string]struct{}
+	check   MemoryCheck
 }
 
 func (m *memoryController) Name() Name {
@@ -216,6 +247,20 @@ func (m *memoryController) Update(path string, resources *specs.LinuxResources)
 	g := func(v *int64) bool {
 		return v != nil && *v > 0
 	}
+	check := MemoryCheckNone
+	if c := resources.Memory.CheckBeforeUpdate; c != nil && *c && g(resources.Memory.Limit) {
+		check = m.check
+	}
+	var usage uint64
+	if check != MemoryCheckNone {
+		var err error
+		if usage, err = m.usage(path); err != nil {
+			return err
+		}
+		if limit := uint64(*resources.Memory.Limit); check == MemoryCheckRefuse && usage > limit {
+			return &MemoryLimitError{Usage: usage, Limit: limit}
+		}
+	}
 	settings := getMemorySettings(resources)
 	if g(resources.Memory.Limit) && g(resources.Memory.Swap) {
 		// if the updated swap value is larger than the current memory limit set the swap changes first
@@ -228,7 +273,31 @@ func (m *memoryController) Update(path string, resources *specs.LinuxResources)
 			settings[0], settings[1] = settings[1], settings[0]
 		}
 	}
-	return m.set(path, settings)
+	err := m.set(path, settings)
+	if check != MemoryCheckReclaim {
+		return err
+	}
+	// The kernel reclaims memory when lowering the limit, and fails with
+	// EBUSY when it cannot reclaim enough.
+	for i := 1; i < memcheck.ReclaimAttempts && errors.Is(err, unix.EBUSY); i++ {
+		err = m.set(path, settings)
+	}
+	if !errors.Is(err, unix.EBUSY) {
+		return err
+	}
+	current, uerr := m.usage(path)
+	if uerr != nil {
+		return uerr
+	}
+	e := &MemoryLimitError{Usage: current, Limit: uint64(*resources.Memory.Limit)}
+	if usage > current {
+		e.Reclaimed = usage - current
+	}
+	return e
+}
+
+func (m *memoryController) usage(path string) (uint64, error) {
+	return readUint(filepath.Join(m.Path(path), "memory.usage_in_bytes"))
 }
 
 func (m *memoryController) Stat(path string, stats *v1.Metrics) error {
@@ -17,6 +17,7 @@
 package cgroup1
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -343,3 +344,44 @@ func Test_getOomControlValue(t *testing.T) {
 		})
 	}
 }
+
+func TestMemoryController_UpdateCheckBeforeUpdate(t *testing.T) {
+	tmpRoot := buildMemoryMetrics(t, nil, nil)
+	if err := os.WriteFile(path.Join(tmpRoot, "memory", "memory.usage_in_bytes"), []byte("2000\n"), defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	mc := NewMemory(tmpRoot)
+	check := true
+	limit := int64(1000)
+	err := mc.Update("", &specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: &limit, CheckBeforeUpdate: &check}})
+	var e *MemoryLimitError
+	if !errors.As(err, &e) || e.Limit != 1000 || e.Usage != 2000 {
+		t.Fatalf("expected a MemoryLimitError, got %v", err)
+	}
+
+	limit = int64(1 << 40)
+	if err := mc.Update("", &specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: &limit, CheckBeforeUpdate: &check}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path.Join(tmpRoot, "memory", "memory.limit_in_bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "1099511627776" {
+		t.Errorf("expected the limit to be written, got %q", got)
+	}
+
+	// With MemoryCheckReclaim, the kernel is left to reclaim the memory.
+	mc = NewMemory(tmpRoot, MemoryCheckBeforeUpdate(MemoryCheckReclaim))
+	limit = int64(1000)
+	if err := mc.Update("", &specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: &limit, CheckBeforeUpdate: &check}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = os.ReadFile(path.Join(tmpRoot, "memory", "memory.limit_in_bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "1000" {
+		t.Errorf("expected the limit to be written, got %q", got)
+	}
+}
@@ -22,7 +22,7 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/containerd/cgroups/v3"
+	"github.com/containerd/cgroups/v3/internal/proc"
 )
 
 func TestStaticPath(t *testing.T) {
@@ -105,7 +105,7 @@ func TestSystemd240(t *testing.T) {
 	1:name=systemd:/system.slice/docker.service
 	0::/system.slice/docker.service`
 	r := strings.NewReader(data)
-	paths, unified, err := cgroups.ParseCgroupFromReaderUnified(r)
+	paths, unified, err := proc.ParseCgroupFromReader(r)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -22,6 +22,8 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/containerd/cgroups/v3/internal/systemd"
+
 	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
 	"github.com/godbus/dbus/v5"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
@@ -35,6 +37,10 @@ const (
 var (
 	canDelegate bool
 	once        sync.Once
+
+	// systemdConn returns the connection used to talk to systemd; tests
+	// replace it to use a fake systemd.
+	systemdConn = systemd.System
 )
 
 func Systemd() ([]Subsystem, error) {
@@ -79,11 +85,12 @@ func (s *SystemdController) Name() Name {
 
 func (s *SystemdController) Create(path string, _ *specs.LinuxResources) error {
 	ctx := context.TODO()
-	conn, err := systemdDbus.NewWithContext(ctx)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
+	return systemdConn().Do(ctx, func(conn *systemdDbus.Conn) error {
+		return s.create(ctx, conn, path)
+	})
+}
+
+func (s *SystemdController) create(ctx context.Context, conn *systemdDbus.Conn, path string) error {
 	slice, name := splitName(path)
 	// We need to see if systemd can handle the delegate property
 	// Systemd will return an error if it cannot handle delegate regardless
@@ -118,9 +125,8 @@ func (s *SystemdController) Create(path string, _ *specs.LinuxResources) error {
 		properties = append(properties, newProperty("Delegate", true))
 	}
 
-	ch := make(chan string)
-	_, err = conn.StartTransientUnitContext(ctx, name, "replace", properties, ch)
-	if err != nil {
+	ch := make(chan string, 1)
+	if _, err := conn.StartTransientUnitContext(ctx, name, "replace", properties, ch); err != nil {
 		return err
 	}
 	<-ch
@@ -129,19 +135,15 @@ func (s *SystemdController) Create(path string, _ *specs.LinuxResources) error {
 
 func (s *SystemdController) Delete(path string) error {
 	ctx := context.TODO()
-	conn, err := systemdDbus.NewWithContext(ctx)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
 	_, name := splitName(path)
-	ch := make(chan string)
-	_, err = conn.StopUnitContext(ctx, name, "replace", ch)
-	if err != nil {
-		return err
-	}
-	<-ch
-	return nil
+	return systemdConn().Do(ctx, func(conn *systemdDbus.Conn) error {
+		ch := make(chan string, 1)
+		if _, err := conn.StopUnitContext(ctx, name, "replace", ch); err != nil {
+			return err
+		}
+		<-ch
+		return nil
+	})
 }
 
 func newProperty(name string, units interface{}) systemdDbus.Property {
@@ -0,0 +1,54 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup1
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/cgroups/v3/internal/systemd"
+	"github.com/containerd/cgroups/v3/internal/systemd/systemdtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemdControllerFake(t *testing.T) {
+	root := t.TempDir()
+	srv, err := systemdtest.NewServer(t.TempDir(), root)
+	require.NoError(t, err)
+	defer srv.Close()
+	conn := systemd.New(srv.Dial)
+	defer conn.Close()
+	defer func(c func() *systemd.Conn) { systemdConn = c }(systemdConn)
+	systemdConn = func() *systemd.Conn { return conn }
+
+	s, err := NewSystemd(root)
+	require.NoError(t, err)
+	require.NoError(t, s.Create("system.slice/test.scope", nil))
+	u, ok := srv.Unit("test.scope")
+	require.True(t, ok)
+	assert.Equal(t, "system.slice", u.Properties["Wants"].Value().([]string)[0])
+	assert.DirExists(t, filepath.Join(root, "system.slice/test.scope"))
+	// The delegation probe must not have stopped the parent slice.
+	_, ok = srv.Unit("system.slice")
+	assert.True(t, ok)
+
+	require.NoError(t, s.Delete("system.slice/test.scope"))
+	_, ok = srv.Unit("test.scope")
+	assert.False(t, ok)
+}
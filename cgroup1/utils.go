@@ -22,11 +22,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/containerd/cgroups/v3"
+	"github.com/containerd/cgroups/v3/internal/proc"
 	units "github.com/docker/go-units"
 	"github.com/moby/sys/userns"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
@@ -197,7 +198,7 @@ func parseKV(raw string) (string, uint64, error) {
 // The resulting map does not have an element for cgroup v2 unified hierarchy.
 // Use [cgroups.ParseCgroupFileUnified] to get the unified path.
 func ParseCgroupFile(path string) (map[string]string, error) {
-	x, _, err := cgroups.ParseCgroupFileUnified(path)
+	x, _, err := proc.ParseCgroupFile(path)
 	return x, err
 }
 
@@ -206,34 +207,19 @@ func ParseCgroupFile(path string) (map[string]string, error) {
 //
 // Deprecated: use [cgroups.ParseCgroupFileUnified] instead .
 func ParseCgroupFileUnified(path string) (map[string]string, string, error) {
-	return cgroups.ParseCgroupFileUnified(path)
+	return proc.ParseCgroupFile(path)
 }
 
 func getCgroupDestination(subsystem string) (string, error) {
-	f, err := os.Open("/proc/self/mountinfo")
+	mounts, err := proc.ParseMountInfo("/proc/self/mountinfo")
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
-	s := bufio.NewScanner(f)
-	for s.Scan() {
-		fields := strings.Split(s.Text(), " ")
-		if len(fields) < 10 {
-			// broken mountinfo?
-			continue
-		}
-		if fields[len(fields)-3] != "cgroup" {
-			continue
-		}
-		for _, opt := range strings.Split(fields[len(fields)-1], ",") {
-			if opt == subsystem {
-				return fields[3], nil
-			}
+	for _, m := range mounts {
+		if slices.Contains(m.Controllers, subsystem) {
+			return m.Root, nil
 		}
 	}
-	if err := s.Err(); err != nil {
-		return "", err
-	}
 	return "", ErrNoCgroupMountDestination
 }
 
@@ -17,11 +17,10 @@
 package cgroup1
 
 import (
-	"bufio"
-	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+
+	"github.com/containerd/cgroups/v3/internal/proc"
 )
 
 // Default returns all the groups in the default cgroups mountpoint in a single hierarchy
@@ -47,27 +46,14 @@ func Default() ([]Subsystem, error) {
 // v1MountPoint returns the mount point where the cgroup
 // mountpoints are mounted in a single hierarchy
 func v1MountPoint() (string, error) {
-	f, err := os.Open("/proc/self/mountinfo")
+	mounts, err := proc.ParseMountInfo("/proc/self/mountinfo")
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		var (
-			text      = scanner.Text()
-			fields    = strings.Split(text, " ")
-			numFields = len(fields)
-		)
-		if numFields < 10 {
-			return "", fmt.Errorf("mountinfo: bad entry %q", text)
-		}
-		if fields[numFields-3] == "cgroup" {
-			return filepath.Dir(fields[4]), nil
+	for _, m := range mounts {
+		if m.Version == 1 {
+			return filepath.Dir(m.Mountpoint), nil
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return "", err
-	}
 	return "", ErrMountPointNotExist
 }
@@ -0,0 +1,51 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import "github.com/containerd/cgroups/v3/cgroup2/stats"
+
+// Cgroup is a cgroup v2 group. It is implemented by *Manager, and allows
+// callers to substitute fakes or alternate backends.
+type Cgroup interface {
+	// NewChild creates a child cgroup with the given resources
+	NewChild(name string, resources *Resources) (Cgroup, error)
+	// Update applies the resources to the cgroup
+	Update(resources *Resources) error
+	// AddProc adds the process with the given id to the cgroup (cgroup.procs)
+	AddProc(pid uint64) error
+	// Procs returns the processes of the cgroup, and of its descendants when
+	// recursive is set
+	Procs(recursive bool) ([]uint64, error)
+	// MoveTo moves all the processes of the cgroup, and of its descendants,
+	// to destination
+	MoveTo(destination Cgroup) error
+	// Stat returns the stats of the cgroup
+	Stat() (*stats.Metrics, error)
+	// Freeze freezes all the processes of the cgroup
+	Freeze() error
+	// Thaw thaws all the processes of the cgroup
+	Thaw() error
+	// Kill kills all the processes of the cgroup
+	Kill() error
+	// Delete removes the cgroup. Systemd managed cgroups are removed by
+	// stopping their unit, which kills the processes left in it
+	Delete() error
+	// EventChan streams the memory events of the cgroup
+	EventChan() (<-chan Event, <-chan error)
+}
+
+var _ Cgroup = &Manager{}
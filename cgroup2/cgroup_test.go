@@ -0,0 +1,47 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCgroup records the processes added to it.
+type fakeCgroup struct {
+	Cgroup
+	procs []uint64
+}
+
+func (f *fakeCgroup) AddProc(pid uint64) error {
+	f.procs = append(f.procs, pid)
+	return nil
+}
+
+func TestMoveToCgroup(t *testing.T) {
+	path := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(path, cgroupProcs), []byte("1\n2\n"), 0o644))
+	src := &Manager{unifiedMountpoint: filepath.Dir(path), path: path}
+
+	dst := &fakeCgroup{}
+	require.NoError(t, src.MoveTo(dst))
+	assert.Equal(t, []uint64{1, 2}, dst.procs)
+}
@@ -0,0 +1,391 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// ConversionWarning describes an OCI resource that has no cgroup v2
+// equivalent and was left out by ConvertResources.
+type ConversionWarning struct {
+	// Field is the JSON path of the resource in the OCI runtime spec,
+	// e.g. "memory.swappiness".
+	Field  string
+	Reason string
+}
+
+func (w ConversionWarning) String() string {
+	return w.Field + ": " + w.Reason
+}
+
+type resourceConverter struct {
+	warnings []ConversionWarning
+	errs     []error
+}
+
+func (c *resourceConverter) warn(field, format string, args ...interface{}) {
+	c.warnings = append(c.warnings, ConversionWarning{
+		Field:  field,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *resourceConverter) fail(field, format string, args ...interface{}) {
+	c.errs = append(c.errs, fmt.Errorf("%s: "+format, append([]interface{}{field}, args...)...))
+}
+
+// ConvertResources converts the oci LinuxResources struct into v2 Resources,
+// covering every field of the spec.
+//
+// Unlike ToResources, nothing is dropped silently: resources that cannot be
+// expressed on cgroup v2 but are harmless to ignore, such as the memory
+// swappiness, are returned as warnings, and the ones that would change the
+// behavior of the container, such as realtime CPU scheduling, as an error
+// listing all of them.
+func ConvertResources(spec *specs.LinuxResources) (*Resources, []ConversionWarning, error) {
+	var (
+		c         resourceConverter
+		resources = &Resources{Devices: spec.Devices}
+	)
+	resources.CPU = c.cpu(spec.CPU)
+	resources.Memory = c.memory(spec.Memory)
+	if pids := spec.Pids; pids != nil && pids.Limit != nil {
+		// As in runc, a limit of 0 or less means no limit.
+		limit := *pids.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		resources.Pids = &Pids{Max: limit}
+	}
+	resources.IO = c.io(spec.BlockIO)
+	if len(spec.HugepageLimits) > 0 {
+		hugeTlb := make(HugeTlb, 0, len(spec.HugepageLimits))
+		for _, l := range spec.HugepageLimits {
+			hugeTlb = append(hugeTlb, HugeTlbEntry{HugePageSize: l.Pagesize, Limit: l.Limit})
+		}
+		resources.HugeTlb = &hugeTlb
+	}
+	resources.RDMA = c.rdma(spec.Rdma)
+	if net := spec.Network; net != nil && (net.ClassID != nil || len(net.Priorities) > 0) {
+		c.warn("network", "the net_cls and net_prio controllers do not exist on cgroup v2")
+	}
+	if len(spec.Unified) > 0 {
+		resources.Unified = make(map[string]string, len(spec.Unified))
+		for file, value := range spec.Unified {
+			resources.Unified[file] = value
+		}
+	}
+	if err := resources.validateUnified(); err != nil {
+		c.errs = append(c.errs, err)
+	}
+	return resources, c.warnings, errors.Join(c.errs...)
+}
+
+func (c *resourceConverter) cpu(cpu *specs.LinuxCPU) *CPU {
+	if cpu == nil {
+		return nil
+	}
+	r := &CPU{
+		Burst: cpu.Burst,
+		Idle:  cpu.Idle,
+		Cpus:  cpu.Cpus,
+		Mems:  cpu.Mems,
+	}
+	if shares := cpu.Shares; shares != nil {
+		weight := ConvertCPUSharesToCgroupV2Value(*shares)
+		r.Weight = &weight
+	}
+	if cpu.Quota != nil || cpu.Period != nil {
+		quota := cpu.Quota
+		if quota != nil && *quota < 0 {
+			// -1 means no quota, i.e. "max".
+			quota = nil
+		}
+		r.Max = NewCPUMax(quota, cpu.Period)
+	}
+	if cpu.RealtimeRuntime != nil && *cpu.RealtimeRuntime != 0 {
+		c.fail("cpu.realtimeRuntime", "realtime CPU scheduling is not supported on cgroup v2")
+	}
+	if cpu.RealtimePeriod != nil && *cpu.RealtimePeriod != 0 {
+		c.fail("cpu.realtimePeriod", "realtime CPU scheduling is not supported on cgroup v2")
+	}
+	return r
+}
+
+func (c *resourceConverter) memory(mem *specs.LinuxMemory) *Memory {
+	if mem == nil {
+		return nil
+	}
+	r := &Memory{
+		Max: mem.Limit,
+		Low: mem.Reservation,
+	}
+	if swap := mem.Swap; swap != nil {
+		// OCI limits memory+swap while memory.swap.max only limits the swap.
+		switch {
+		case *swap == 0:
+			// No limit set, memory.swap.max is left as is.
+		case *swap == -1:
+			r.Swap = swap
+		case mem.Limit == nil || *mem.Limit <= 0:
+			c.fail("memory.swap", "a swap limit requires a memory limit")
+		case *swap < *mem.Limit:
+			c.fail("memory.swap", "the memory+swap limit %d is lower than the memory limit %d", *swap, *mem.Limit)
+		default:
+			v := *swap - *mem.Limit
+			r.Swap = &v
+		}
+	}
+	if mem.Kernel != nil {
+		c.warn("memory.kernel", "kernel memory is accounted for in memory.max on cgroup v2")
+	}
+	if mem.KernelTCP != nil {
+		c.warn("memory.kernelTCP", "kernel TCP memory is accounted for in memory.max on cgroup v2")
+	}
+	if mem.Swappiness != nil {
+		c.warn("memory.swappiness", "the swappiness cannot be set per cgroup on cgroup v2")
+	}
+	if mem.DisableOOMKiller != nil && *mem.DisableOOMKiller {
+		c.warn("memory.disableOOMKiller", "the OOM killer cannot be disabled on cgroup v2")
+	}
+	if mem.UseHierarchy != nil && !*mem.UseHierarchy {
+		c.warn("memory.useHierarchy", "memory accounting is always hierarchical on cgroup v2")
+	}
+	if mem.CheckBeforeUpdate != nil && *mem.CheckBeforeUpdate {
+		r.CheckBeforeUpdate = MemoryCheckRefuse
+	}
+	return r
+}
+
+func (c *resourceConverter) io(blkio *specs.LinuxBlockIO) *IO {
+	if blkio == nil {
+		return nil
+	}
+	r := &IO{}
+	// io.bfq.weight has the same range as blkio.weight, 1-1000.
+	if blkio.Weight != nil {
+		r.BFQ.Weight = *blkio.Weight
+	}
+	if blkio.LeafWeight != nil {
+		c.warn("blockIO.leafWeight", "leaf weights only exist for the CFQ scheduler of cgroup v1")
+	}
+	for i, d := range blkio.WeightDevice {
+		if d.Weight != nil {
+			r.BFQ.WeightDevices = append(r.BFQ.WeightDevices, BFQWeightDevice{
+				Major:  d.Major,
+				Minor:  d.Minor,
+				Weight: *d.Weight,
+			})
+		}
+		if d.LeafWeight != nil {
+			c.warn(fmt.Sprintf("blockIO.weightDevice[%d].leafWeight", i), "leaf weights only exist for the CFQ scheduler of cgroup v1")
+		}
+	}
+	for _, t := range []struct {
+		typ     IOType
+		devices []specs.LinuxThrottleDevice
+	}{
+		{ReadBPS, blkio.ThrottleReadBpsDevice},
+		{WriteBPS, blkio.ThrottleWriteBpsDevice},
+		{ReadIOPS, blkio.ThrottleReadIOPSDevice},
+		{WriteIOPS, blkio.ThrottleWriteIOPSDevice},
+	} {
+		for _, d := range t.devices {
+			r.Max = append(r.Max, Entry{
+				Type:  t.typ,
+				Major: d.Major,
+				Minor: d.Minor,
+				Rate:  d.Rate,
+			})
+		}
+	}
+	return r
+}
+
+func (c *resourceConverter) rdma(rdma map[string]specs.LinuxRdma) *RDMA {
+	if len(rdma) == 0 {
+		return nil
+	}
+	devices := make([]string, 0, len(rdma))
+	for device := range rdma {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	r := &RDMA{}
+	for _, device := range devices {
+		if device == "" {
+			c.fail("rdma", "empty device name")
+			continue
+		}
+		// An unset limit means no limit.
+		entry := RDMAEntry{Device: device, HcaHandles: RDMAMax, HcaObjects: RDMAMax}
+		if v := rdma[device].HcaHandles; v != nil {
+			entry.HcaHandles = *v
+		}
+		if v := rdma[device].HcaObjects; v != nil {
+			entry.HcaObjects = *v
+		}
+		r.Limit = append(r.Limit, entry)
+	}
+	return r
+}
+
+// ToLinuxResources converts v2 Resources back into the oci LinuxResources
+// struct.
+//
+// The resources that have no OCI field, such as memory.high, are returned in
+// Unified along with Resources.Unified, keyed by their cgroup interface file. The CPU weight is converted
+// into the smallest CPU shares value mapping to it.
+func ToLinuxResources(r *Resources) *specs.LinuxResources {
+	spec := &specs.LinuxResources{Devices: r.Devices}
+	unified := make(map[string]string, len(r.Unified))
+	for file, value := range r.Unified {
+		unified[file] = value
+	}
+	if cpu := r.CPU; cpu != nil {
+		spec.CPU = &specs.LinuxCPU{
+			Burst: cpu.Burst,
+			Idle:  cpu.Idle,
+			Cpus:  cpu.Cpus,
+			Mems:  cpu.Mems,
+		}
+		if cpu.Weight != nil {
+			shares := convertCgroupV2ValueToCPUShares(*cpu.Weight)
+			spec.CPU.Shares = &shares
+		}
+		if cpu.Max != "" {
+			if quota, period, err := cpu.Max.extractQuotaAndPeriod(); err == nil {
+				if quota != math.MaxInt64 {
+					spec.CPU.Quota = &quota
+				}
+				spec.CPU.Period = &period
+			} else {
+				unified["cpu.max"] = string(cpu.Max)
+			}
+		}
+	}
+	if mem := r.Memory; mem != nil {
+		spec.Memory = &specs.LinuxMemory{
+			Limit:       mem.Max,
+			Reservation: mem.Low,
+		}
+		if mem.CheckBeforeUpdate != MemoryCheckNone {
+			check := true
+			spec.Memory.CheckBeforeUpdate = &check
+		}
+		if swap := mem.Swap; swap != nil {
+			v := *swap
+			if v >= 0 && mem.Max != nil && *mem.Max > 0 {
+				v += *mem.Max
+			}
+			spec.Memory.Swap = &v
+		}
+		if mem.Min != nil {
+			unified["memory.min"] = strconv.FormatInt(*mem.Min, 10)
+		}
+		if mem.High != nil {
+			unified["memory.high"] = strconv.FormatInt(*mem.High, 10)
+		}
+		if mem.OOMGroup != nil {
+			unified["memory.oom.group"] = "0"
+			if *mem.OOMGroup {
+				unified["memory.oom.group"] = "1"
+			}
+		}
+	}
+	if r.Pids != nil && r.Pids.Max != 0 {
+		limit := r.Pids.Max
+		spec.Pids = &specs.LinuxPids{Limit: &limit}
+	}
+	if io := r.IO; io != nil {
+		spec.BlockIO = &specs.LinuxBlockIO{}
+		if io.BFQ.Weight != 0 {
+			weight := io.BFQ.Weight
+			spec.BlockIO.Weight = &weight
+		}
+		for _, d := range io.BFQ.WeightDevices {
+			weight := d.Weight
+			spec.BlockIO.WeightDevice = append(spec.BlockIO.WeightDevice, specs.LinuxWeightDevice{
+				LinuxBlockIODevice: specs.LinuxBlockIODevice{Major: d.Major, Minor: d.Minor},
+				Weight:             &weight,
+			})
+		}
+		for _, e := range io.Max {
+			d := specs.LinuxThrottleDevice{
+				LinuxBlockIODevice: specs.LinuxBlockIODevice{Major: e.Major, Minor: e.Minor},
+				Rate:               e.Rate,
+			}
+			switch e.Type {
+			case ReadBPS:
+				spec.BlockIO.ThrottleReadBpsDevice = append(spec.BlockIO.ThrottleReadBpsDevice, d)
+			case WriteBPS:
+				spec.BlockIO.ThrottleWriteBpsDevice = append(spec.BlockIO.ThrottleWriteBpsDevice, d)
+			case ReadIOPS:
+				spec.BlockIO.ThrottleReadIOPSDevice = append(spec.BlockIO.ThrottleReadIOPSDevice, d)
+			case WriteIOPS:
+				spec.BlockIO.ThrottleWriteIOPSDevice = append(spec.BlockIO.ThrottleWriteIOPSDevice, d)
+			}
+		}
+	}
+	if r.HugeTlb != nil {
+		for _, e := range *r.HugeTlb {
+			spec.HugepageLimits = append(spec.HugepageLimits, specs.LinuxHugepageLimit{
+				Pagesize: e.HugePageSize,
+				Limit:    e.Limit,
+			})
+		}
+	}
+	if r.RDMA != nil && len(r.RDMA.Limit) > 0 {
+		spec.Rdma = make(map[string]specs.LinuxRdma, len(r.RDMA.Limit))
+		for _, e := range r.RDMA.Limit {
+			var limit specs.LinuxRdma
+			if e.HcaHandles != RDMAMax {
+				v := e.HcaHandles
+				limit.HcaHandles = &v
+			}
+			if e.HcaObjects != RDMAMax {
+				v := e.HcaObjects
+				limit.HcaObjects = &v
+			}
+			spec.Rdma[e.Device] = limit
+		}
+	}
+	if len(unified) > 0 {
+		spec.Unified = unified
+	}
+	return spec
+}
+
+// convertCgroupV2ValueToCPUShares returns the smallest CPU shares value that
+// ConvertCPUSharesToCgroupV2Value converts into weight.
+func convertCgroupV2ValueToCPUShares(weight uint64) uint64 {
+	if weight == 0 {
+		return 0
+	}
+	// The conversion is monotonic over [2, 262144].
+	return 2 + uint64(sort.Search(262144-2+1, func(i int) bool {
+		return ConvertCPUSharesToCgroupV2Value(uint64(i)+2) >= weight
+	}))
+}
@@ -0,0 +1,199 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func warningFields(warnings []ConversionWarning) []string {
+	var fields []string
+	for _, w := range warnings {
+		fields = append(fields, w.Field)
+	}
+	return fields
+}
+
+func TestConvertResources(t *testing.T) {
+	spec := &specs.LinuxResources{
+		Devices: []specs.LinuxDeviceCgroup{{Allow: false, Type: "a", Access: "rwm"}},
+		CPU: &specs.LinuxCPU{
+			Shares: toPtr(uint64(1024)),
+			Quota:  toPtr(int64(50000)),
+			Period: toPtr(uint64(100000)),
+			Burst:  toPtr(uint64(10000)),
+			Idle:   toPtr(int64(1)),
+			Cpus:   "0-1",
+			Mems:   "0",
+		},
+		Memory: &specs.LinuxMemory{
+			Limit:       toPtr(int64(300)),
+			Reservation: toPtr(int64(200)),
+			Swap:        toPtr(int64(500)),
+		},
+		Pids: &specs.LinuxPids{Limit: toPtr(int64(0))},
+		BlockIO: &specs.LinuxBlockIO{
+			Weight: toPtr(uint16(500)),
+			WeightDevice: []specs.LinuxWeightDevice{
+				{LinuxBlockIODevice: specs.LinuxBlockIODevice{Major: 8, Minor: 0}, Weight: toPtr(uint16(300))},
+			},
+			ThrottleReadBpsDevice:   []specs.LinuxThrottleDevice{{LinuxBlockIODevice: specs.LinuxBlockIODevice{Major: 8, Minor: 0}, Rate: 100}},
+			ThrottleWriteIOPSDevice: []specs.LinuxThrottleDevice{{LinuxBlockIODevice: specs.LinuxBlockIODevice{Major: 8, Minor: 16}, Rate: 10}},
+		},
+		HugepageLimits: []specs.LinuxHugepageLimit{{Pagesize: "2MB", Limit: 1 << 21}},
+		Rdma: map[string]specs.LinuxRdma{
+			"mlx5_1": {HcaObjects: toPtr(uint32(20))},
+			"mlx5_0": {HcaHandles: toPtr(uint32(10)), HcaObjects: toPtr(uint32(20))},
+		},
+	}
+	res, warnings, err := ConvertResources(spec)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	assert.Equal(t, spec.Devices, res.Devices)
+	assert.Equal(t, &CPU{
+		Weight: toPtr(ConvertCPUSharesToCgroupV2Value(1024)),
+		Max:    "50000 100000",
+		Burst:  toPtr(uint64(10000)),
+		Idle:   toPtr(int64(1)),
+		Cpus:   "0-1",
+		Mems:   "0",
+	}, res.CPU)
+	assert.Equal(t, &Memory{Max: toPtr(int64(300)), Low: toPtr(int64(200)), Swap: toPtr(int64(200))}, res.Memory)
+	assert.Equal(t, &Pids{Max: -1}, res.Pids)
+	assert.Equal(t, &IO{
+		BFQ: BFQ{Weight: 500, WeightDevices: []BFQWeightDevice{{Major: 8, Minor: 0, Weight: 300}}},
+		Max: []Entry{
+			{Type: ReadBPS, Major: 8, Minor: 0, Rate: 100},
+			{Type: WriteIOPS, Major: 8, Minor: 16, Rate: 10},
+		},
+	}, res.IO)
+	assert.Equal(t, &HugeTlb{{HugePageSize: "2MB", Limit: 1 << 21}}, res.HugeTlb)
+	assert.Equal(t, &RDMA{Limit: []RDMAEntry{
+		{Device: "mlx5_0", HcaHandles: 10, HcaObjects: 20},
+		{Device: "mlx5_1", HcaHandles: RDMAMax, HcaObjects: 20},
+	}}, res.RDMA)
+
+	// Converting back gives the same resources.
+	back, warnings, err := ConvertResources(ToLinuxResources(res))
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, res, back)
+}
+
+func TestConvertResourcesWarnings(t *testing.T) {
+	res, warnings, err := ConvertResources(&specs.LinuxResources{
+		Memory: &specs.LinuxMemory{
+			Kernel:           toPtr(int64(100)),
+			Swappiness:       toPtr(uint64(10)),
+			DisableOOMKiller: toPtr(true),
+		},
+		BlockIO: &specs.LinuxBlockIO{LeafWeight: toPtr(uint16(10))},
+		Network: &specs.LinuxNetwork{ClassID: toPtr(uint32(1))},
+		Unified: map[string]string{"memory.high": "100"},
+	})
+	require.NoError(t, err)
+	assert.NotNil(t, res.Memory)
+	assert.Equal(t, map[string]string{"memory.high": "100"}, res.Unified)
+	assert.Equal(t, []string{
+		"memory.kernel",
+		"memory.swappiness",
+		"memory.disableOOMKiller",
+		"blockIO.leafWeight",
+		"network",
+	}, warningFields(warnings))
+}
+
+func TestConvertResourcesSwap(t *testing.T) {
+	for _, tc := range []struct {
+		swap int64
+		want *int64
+	}{
+		// 0 means that no swap limit is set, not a limit of 0.
+		{0, nil},
+		{-1, toPtr(int64(-1))},
+		{500, toPtr(int64(0))},
+		{800, toPtr(int64(300))},
+	} {
+		res, _, err := ConvertResources(&specs.LinuxResources{
+			Memory: &specs.LinuxMemory{Limit: toPtr(int64(500)), Swap: toPtr(tc.swap)},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, res.Memory.Swap, "swap %d", tc.swap)
+	}
+}
+
+func TestConvertResourcesErrors(t *testing.T) {
+	_, _, err := ConvertResources(&specs.LinuxResources{
+		CPU:    &specs.LinuxCPU{RealtimeRuntime: toPtr(int64(100))},
+		Memory: &specs.LinuxMemory{Limit: toPtr(int64(500)), Swap: toPtr(int64(300))},
+		Rdma:   map[string]specs.LinuxRdma{"": {}},
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "cpu.realtimeRuntime")
+	assert.ErrorContains(t, err, "memory.swap")
+	assert.ErrorContains(t, err, "rdma")
+
+	_, _, err = ConvertResources(&specs.LinuxResources{Memory: &specs.LinuxMemory{Swap: toPtr(int64(300))}})
+	assert.Error(t, err)
+
+	_, _, err = ConvertResources(&specs.LinuxResources{
+		Memory:  &specs.LinuxMemory{Limit: toPtr(int64(500))},
+		Unified: map[string]string{"memory.max": "600"},
+	})
+	assert.ErrorIs(t, err, ErrInvalidUnified)
+}
+
+func TestToLinuxResources(t *testing.T) {
+	spec := ToLinuxResources(&Resources{
+		CPU:    &CPU{Weight: toPtr(uint64(100)), Max: "max 100000"},
+		Memory: &Memory{Max: toPtr(int64(300)), Swap: toPtr(int64(-1)), High: toPtr(int64(250)), OOMGroup: toPtr(true)},
+	})
+	// 1012 to 1024 shares all map to a weight of 100.
+	assert.Equal(t, &specs.LinuxCPU{Shares: toPtr(uint64(1012)), Period: toPtr(uint64(100000))}, spec.CPU)
+	assert.Equal(t, &specs.LinuxMemory{Limit: toPtr(int64(300)), Swap: toPtr(int64(-1))}, spec.Memory)
+	assert.Equal(t, map[string]string{"memory.high": "250", "memory.oom.group": "1"}, spec.Unified)
+
+	// No swap is a memory+swap limit equal to the memory limit, as an OCI
+	// swap of 0 leaves the swap unset.
+	res := &Resources{Memory: &Memory{Max: toPtr(int64(300)), Swap: toPtr(int64(0))}}
+	spec = ToLinuxResources(res)
+	assert.Equal(t, &specs.LinuxMemory{Limit: toPtr(int64(300)), Swap: toPtr(int64(300))}, spec.Memory)
+	back, _, err := ConvertResources(spec)
+	require.NoError(t, err)
+	assert.Equal(t, res.Memory, back.Memory)
+}
+
+func TestResourceValues(t *testing.T) {
+	values := (&Resources{
+		CPU: &CPU{Burst: toPtr(uint64(1000)), Idle: toPtr(int64(1))},
+		IO:  &IO{BFQ: BFQ{WeightDevices: []BFQWeightDevice{{Major: 8, Minor: 0, Weight: 300}}}},
+		RDMA: &RDMA{Limit: []RDMAEntry{
+			{Device: "mlx5_0", HcaHandles: 10, HcaObjects: RDMAMax},
+		}},
+	}).Values()
+	assert.Equal(t, []Value{
+		{filename: "cpu.max.burst", value: uint64(1000)},
+		{filename: "cpu.idle", value: int64(1)},
+		{filename: "io.bfq.weight", value: "8:0 300"},
+		{filename: "rdma.max", value: "mlx5_0 hca_handle=10 hca_object=max"},
+	}, values)
+}
@@ -49,8 +49,12 @@ func NewCPUMax(quota *int64, period *uint64) CPUMax {
 type CPU struct {
 	Weight *uint64
 	Max    CPUMax
-	Cpus   string
-	Mems   string
+	// Burst is written to cpu.max.burst, in microseconds.
+	Burst *uint64
+	// Idle is written to cpu.idle, 1 making the cgroup SCHED_IDLE.
+	Idle *int64
+	Cpus string
+	Mems string
 }
 
 func (c CPUMax) extractQuotaAndPeriod() (int64, uint64, error) {
@@ -96,6 +100,18 @@ func (r *CPU) Values() (o []Value) {
 			value:    r.Max,
 		})
 	}
+	if r.Burst != nil {
+		o = append(o, Value{
+			filename: "cpu.max.burst",
+			value:    *r.Burst,
+		})
+	}
+	if r.Idle != nil {
+		o = append(o, Value{
+			filename: "cpu.idle",
+			value:    *r.Idle,
+		})
+	}
 	if r.Cpus != "" {
 		o = append(o, Value{
 			filename: "cpuset.cpus",
@@ -0,0 +1,165 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// kernelDelegateFile lists the cgroup files that should be chowned on
+// delegation, in addition to the cgroup directory itself. It is available
+// since Linux 4.15.
+//
+// https://docs.kernel.org/admin-guide/cgroup-v2.html#delegation
+var kernelDelegateFile = "/sys/kernel/cgroup/delegate"
+
+// defaultDelegateFiles is used when the kernel does not expose kernelDelegateFile.
+var defaultDelegateFiles = []string{cgroupProcs, cgroupThreads, subtreeControl}
+
+// delegateFiles returns the list of files that must be chowned to delegate a
+// cgroup, as reported by the kernel.
+func delegateFiles() ([]string, error) {
+	f, err := os.Open(kernelDelegateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return defaultDelegateFiles, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	// Always include the files systemd chowns, even if an older kernel
+	// doesn't list them all.
+	files := append([]string{}, defaultDelegateFiles...)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		name := strings.TrimSpace(s.Text())
+		if name == "" || slices.Contains(files, name) {
+			continue
+		}
+		files = append(files, name)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Delegate hands the cgroup over to the given uid and gid the same way
+// systemd does for units with Delegate=yes: the cgroup directory, along with
+// cgroup.procs, cgroup.threads, cgroup.subtree_control and any file listed in
+// /sys/kernel/cgroup/delegate, is chowned to uid:gid.
+//
+// The parent cgroup must either be owned by root or be fully delegated to the
+// same uid, otherwise ErrImproperDelegation is returned.
+func (c *Manager) Delegate(uid, gid int) error {
+	if c.unifiedMountpoint != "" && filepath.Clean(c.path) == filepath.Clean(c.unifiedMountpoint) {
+		return fmt.Errorf("cgroups: cannot delegate the root cgroup %q", c.path)
+	}
+	if err := checkParentDelegation(filepath.Dir(c.path), uid); err != nil {
+		return err
+	}
+	files, err := delegateFiles()
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(c.path, uid, gid); err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := os.Chown(filepath.Join(c.path, name), uid, gid); err != nil {
+			// Not every file listed by the kernel exists in every cgroup
+			// (e.g. memory.* files when the controller is not enabled).
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParentDelegation verifies that parent is either owned by root or has
+// been completely delegated to uid.
+func checkParentDelegation(parent string, uid int) error {
+	owner, err := fileOwner(parent)
+	if err != nil {
+		return err
+	}
+	if owner == 0 {
+		return nil
+	}
+	if owner != uint32(uid) {
+		return fmt.Errorf("%w: %q is delegated to uid %d, not %d", ErrImproperDelegation, parent, owner, uid)
+	}
+	for _, name := range []string{cgroupProcs, subtreeControl} {
+		fOwner, err := fileOwner(filepath.Join(parent, name))
+		if err != nil {
+			return err
+		}
+		if fOwner != owner {
+			return fmt.Errorf("%w: %q is owned by uid %d but %s is owned by uid %d", ErrImproperDelegation, parent, owner, name, fOwner)
+		}
+	}
+	return nil
+}
+
+func fileOwner(path string) (uint32, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("cannot determine owner of %q", path)
+	}
+	return st.Uid, nil
+}
+
+// CanWrite reports whether the current process is allowed to manage the cgroup,
+// that is to create sub-cgroups, move processes and enable controllers in it.
+// It is mostly useful to check that a cgroup has been properly delegated to
+// an unprivileged user.
+func (c *Manager) CanWrite() (bool, error) {
+	for _, p := range []string{
+		c.path,
+		filepath.Join(c.path, cgroupProcs),
+		filepath.Join(c.path, cgroupThreads),
+		filepath.Join(c.path, subtreeControl),
+	} {
+		err := unix.Faccessat(unix.AT_FDCWD, p, unix.W_OK, unix.AT_EACCESS)
+		switch {
+		case err == nil:
+		case errors.Is(err, unix.ENOENT) && p == filepath.Join(c.path, cgroupThreads):
+			// cgroup.threads is missing on kernels older than 4.14.
+		case errors.Is(err, unix.EACCES), errors.Is(err, unix.EPERM), errors.Is(err, unix.EROFS):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,101 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeDelegationTree(t *testing.T) (root string, m *Manager) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown requires root")
+	}
+	root = t.TempDir()
+	path := filepath.Join(root, "parent", "child")
+	require.NoError(t, os.MkdirAll(path, defaultDirPerm))
+	for _, dir := range []string{root, filepath.Dir(path), path} {
+		for _, name := range []string{cgroupProcs, cgroupThreads, subtreeControl, "memory.reclaim"} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+		}
+	}
+	return root, &Manager{unifiedMountpoint: root, path: path}
+}
+
+func TestDelegate(t *testing.T) {
+	_, m := newFakeDelegationTree(t)
+
+	delegate := filepath.Join(t.TempDir(), "delegate")
+	require.NoError(t, os.WriteFile(delegate, []byte("cgroup.procs\ncgroup.threads\ncgroup.subtree_control\nmemory.reclaim\nmemory.oom.group\n"), 0o644))
+	defer func(old string) { kernelDelegateFile = old }(kernelDelegateFile)
+	kernelDelegateFile = delegate
+
+	require.NoError(t, m.Delegate(1000, 1001))
+
+	for _, name := range []string{"", cgroupProcs, cgroupThreads, subtreeControl, "memory.reclaim"} {
+		uid, err := fileOwner(filepath.Join(m.path, name))
+		require.NoError(t, err)
+		assert.Equalf(t, uint32(1000), uid, "owner of %q", name)
+	}
+	// memory.oom.group does not exist in the cgroup and must be skipped.
+	_, err := os.Stat(filepath.Join(m.path, "memory.oom.group"))
+	assert.True(t, os.IsNotExist(err))
+
+	// The parent must be left untouched.
+	uid, err := fileOwner(filepath.Dir(m.path))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), uid)
+}
+
+func TestDelegateImproperParent(t *testing.T) {
+	_, m := newFakeDelegationTree(t)
+	parent := filepath.Dir(m.path)
+
+	// Parent delegated to another user.
+	require.NoError(t, os.Chown(parent, 2000, 2000))
+	require.NoError(t, os.Chown(filepath.Join(parent, cgroupProcs), 2000, 2000))
+	require.NoError(t, os.Chown(filepath.Join(parent, subtreeControl), 2000, 2000))
+	assert.ErrorIs(t, m.Delegate(1000, 1000), ErrImproperDelegation)
+
+	// Re-delegating to the same user is allowed.
+	assert.NoError(t, m.Delegate(2000, 2000))
+
+	// Parent only partially delegated.
+	require.NoError(t, os.Chown(filepath.Join(parent, subtreeControl), 0, 0))
+	assert.ErrorIs(t, m.Delegate(2000, 2000), ErrImproperDelegation)
+}
+
+func TestDelegateRoot(t *testing.T) {
+	root, _ := newFakeDelegationTree(t)
+	m := &Manager{unifiedMountpoint: root, path: root}
+	assert.Error(t, m.Delegate(1000, 1000))
+}
+
+func TestCanWrite(t *testing.T) {
+	_, m := newFakeDelegationTree(t)
+	ok, err := m.CanWrite()
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	missing := &Manager{path: filepath.Join(m.path, "missing")}
+	_, err = missing.CanWrite()
+	assert.Error(t, err)
+}
@@ -198,3 +198,114 @@ func acceptBlock(accept bool) asm.Instructions {
 		asm.Return(),
 	}
 }
+
+// DecodeDeviceFilter decodes a program generated by DeviceFilter, e.g. read
+// back from the kernel, into device rules. The rules are equivalent to the
+// ones the program was generated from but not always identical: rules
+// shadowed by a wildcard are dropped and the implicit default, denying all
+// devices, is returned as the first rule.
+//
+// ErrUnknownDeviceFilter is returned for other programs.
+func DecodeDeviceFilter(insts asm.Instructions) ([]specs.LinuxDeviceCgroup, error) {
+	prologue := &program{}
+	prologue.init()
+	if len(insts) < len(prologue.insts) {
+		return nil, ErrUnknownDeviceFilter
+	}
+	for i, ins := range prologue.insts {
+		if !sameInstruction(ins, insts[i]) {
+			return nil, ErrUnknownDeviceFilter
+		}
+	}
+	insts = insts[len(prologue.insts):]
+
+	// The blocks are in reverse order of the rules: the first matching block
+	// returns.
+	var devices []specs.LinuxDeviceCgroup
+	for len(insts) > 0 {
+		dev, n, err := decodeBlock(insts)
+		if err != nil {
+			return nil, err
+		}
+		devices = append([]specs.LinuxDeviceCgroup{dev}, devices...)
+		insts = insts[n:]
+		if dev.Type == "a" && *dev.Major == -1 && *dev.Minor == -1 && dev.Access == "rwm" {
+			// The following instructions, if any, are unreachable.
+			return devices, nil
+		}
+	}
+	return nil, ErrUnknownDeviceFilter
+}
+
+// decodeBlock decodes the block of instructions generated by appendDevice, or
+// by finalize, at the head of insts. It returns the rule and the number of
+// instructions of the block.
+func decodeBlock(insts asm.Instructions) (specs.LinuxDeviceCgroup, int, error) {
+	var (
+		major, minor = int64(-1), int64(-1)
+		dev          = specs.LinuxDeviceCgroup{Type: "a", Major: &major, Minor: &minor, Access: "rwm"}
+		isJNE        = func(ins asm.Instruction, dst asm.Register) bool {
+			return ins.OpCode.JumpOp() == asm.JNE && ins.OpCode.Source() == asm.ImmSource && ins.Dst == dst
+		}
+	)
+	for i := 0; i < len(insts); i++ {
+		ins := insts[i]
+		switch {
+		case isJNE(ins, asm.R2):
+			switch ins.Constant {
+			case unix.BPF_DEVCG_DEV_CHAR:
+				dev.Type = "c"
+			case unix.BPF_DEVCG_DEV_BLOCK:
+				dev.Type = "b"
+			default:
+				return dev, 0, ErrUnknownDeviceFilter
+			}
+		case isJNE(ins, asm.R4):
+			major = int64(uint32(ins.Constant))
+		case isJNE(ins, asm.R5):
+			minor = int64(uint32(ins.Constant))
+		case sameInstruction(ins, asm.Mov.Reg32(asm.R1, asm.R3)):
+			// if (R3 & access == 0) goto next
+			if i+2 >= len(insts) ||
+				insts[i+1].OpCode != asm.And.Op32(asm.ImmSource) || insts[i+1].Dst != asm.R1 ||
+				insts[i+2].OpCode.JumpOp() != asm.JEq || insts[i+2].Dst != asm.R1 || insts[i+2].Constant != 0 {
+				return dev, 0, ErrUnknownDeviceFilter
+			}
+			dev.Access = deviceAccess(insts[i+1].Constant)
+			i += 2
+		case ins.OpCode == asm.Mov.Op32(asm.ImmSource) && ins.Dst == asm.R0:
+			// R0 <- allow; return
+			if i+1 >= len(insts) || insts[i+1].OpCode.JumpOp() != asm.Exit {
+				return dev, 0, ErrUnknownDeviceFilter
+			}
+			dev.Allow = ins.Constant == 1
+			return dev, i + 2, nil
+		default:
+			return dev, 0, ErrUnknownDeviceFilter
+		}
+	}
+	return dev, 0, ErrUnknownDeviceFilter
+}
+
+func deviceAccess(bpfAccess int64) string {
+	var access []byte
+	for _, a := range []struct {
+		bit int64
+		c   byte
+	}{
+		{unix.BPF_DEVCG_ACC_READ, 'r'},
+		{unix.BPF_DEVCG_ACC_WRITE, 'w'},
+		{unix.BPF_DEVCG_ACC_MKNOD, 'm'},
+	} {
+		if bpfAccess&a.bit != 0 {
+			access = append(access, a.c)
+		}
+	}
+	return string(access)
+}
+
+// sameInstruction compares instructions ignoring their metadata, such as
+// jump symbols.
+func sameInstruction(a, b asm.Instruction) bool {
+	return a.OpCode == b.OpCode && a.Dst == b.Dst && a.Src == b.Src && a.Offset == b.Offset && a.Constant == b.Constant
+}
@@ -20,6 +20,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/cilium/ebpf/asm"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/require"
 )
@@ -177,6 +178,62 @@ block-1:
 	testDeviceFilter(t, devices, expected)
 }
 
+func TestDecodeDeviceFilter(t *testing.T) {
+	denyAll := specs.LinuxDeviceCgroup{Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"}
+	for _, tc := range []struct {
+		name     string
+		devices  []specs.LinuxDeviceCgroup
+		expected []specs.LinuxDeviceCgroup
+	}{
+		{
+			name: "allow some",
+			devices: []specs.LinuxDeviceCgroup{
+				denyAll,
+				{Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(3), Access: "rwm"},
+				{Allow: true, Type: "c", Major: pointerInt64(136), Minor: pointerInt64(-1), Access: "rw"},
+				{Allow: true, Type: "b", Major: pointerInt64(8), Minor: pointerInt64(0), Access: "m"},
+				{Allow: false, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(4000000000), Access: "w"},
+			},
+		},
+		{
+			name:     "implicit deny",
+			devices:  []specs.LinuxDeviceCgroup{{Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(5), Access: "r"}},
+			expected: []specs.LinuxDeviceCgroup{denyAll, {Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(5), Access: "r"}},
+		},
+		{
+			name: "shadowed",
+			devices: []specs.LinuxDeviceCgroup{
+				{Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(3), Access: "rwm"},
+				{Allow: true, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"},
+			},
+			expected: []specs.LinuxDeviceCgroup{{Allow: true, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			insts, _, err := DeviceFilter(tc.devices)
+			require.NoError(t, err)
+			devices, err := DecodeDeviceFilter(insts)
+			require.NoError(t, err)
+			expected := tc.expected
+			if expected == nil {
+				expected = tc.devices
+			}
+			require.Equal(t, expected, devices)
+		})
+	}
+}
+
+func TestDecodeDeviceFilterUnknown(t *testing.T) {
+	insts, _, err := DeviceFilter([]specs.LinuxDeviceCgroup{{Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(3), Access: "rwm"}})
+	require.NoError(t, err)
+	_, err = DecodeDeviceFilter(insts[:len(insts)-1])
+	require.ErrorIs(t, err, ErrUnknownDeviceFilter)
+	_, err = DecodeDeviceFilter(insts[1:])
+	require.ErrorIs(t, err, ErrUnknownDeviceFilter)
+	_, err = DecodeDeviceFilter(append(insts[:5:5], asm.Mov.Imm(asm.R1, 0), asm.Mov.Imm32(asm.R0, 0), asm.Return()))
+	require.ErrorIs(t, err, ErrUnknownDeviceFilter)
+}
+
 func pointerInt64(int int64) *int64 {
 	return &int
 }
@@ -0,0 +1,217 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// DeviceRequest is an access to a device, as checked by a device filter.
+type DeviceRequest struct {
+	// Type is "c" or "b".
+	Type  string
+	Major uint32
+	Minor uint32
+	// Access is a combination of "r", "w" and "m".
+	Access string
+}
+
+// DeviceAllowed reports whether the program generated by DeviceFilter from
+// devices allows req, without loading it into the kernel.
+//
+// Like the program, the last matching rule wins and a rule matches req when
+// they share at least one access type; nothing is allowed by default.
+func DeviceAllowed(devices []specs.LinuxDeviceCgroup, req DeviceRequest) (bool, error) {
+	if req.Type != "c" && req.Type != "b" {
+		return false, fmt.Errorf("invalid device type %q", req.Type)
+	}
+	reqAccess, err := parseDeviceAccess(req.Access)
+	if err != nil {
+		return false, err
+	}
+	return allowed(devices, req.Type, int64(req.Major), int64(req.Minor), reqAccess)
+}
+
+// DeviceFilterAllowed is like DeviceAllowed for a program generated by
+// DeviceFilter, such as one read back from the kernel.
+func DeviceFilterAllowed(insts asm.Instructions, req DeviceRequest) (bool, error) {
+	devices, err := DecodeDeviceFilter(insts)
+	if err != nil {
+		return false, err
+	}
+	return DeviceAllowed(devices, req)
+}
+
+func matchDevice(dev specs.LinuxDeviceCgroup, typ string, major, minor, access int64) (bool, error) {
+	switch dev.Type {
+	case "c", "b":
+		if dev.Type != typ {
+			return false, nil
+		}
+	case "a":
+	default:
+		return false, fmt.Errorf("invalid DeviceType %q", dev.Type)
+	}
+	if dev.Major != nil && *dev.Major > math.MaxUint32 {
+		return false, fmt.Errorf("invalid major %d", *dev.Major)
+	}
+	if dev.Minor != nil && *dev.Minor > math.MaxUint32 {
+		return false, fmt.Errorf("invalid minor %d", *dev.Minor)
+	}
+	devAccess, err := parseDeviceAccess(dev.Access)
+	if err != nil {
+		return false, err
+	}
+	if devAccess != allDeviceAccess && devAccess&access == 0 {
+		return false, nil
+	}
+	if dev.Major != nil && *dev.Major >= 0 && *dev.Major != major {
+		return false, nil
+	}
+	if dev.Minor != nil && *dev.Minor >= 0 && *dev.Minor != minor {
+		return false, nil
+	}
+	return true, nil
+}
+
+const allDeviceAccess = unix.BPF_DEVCG_ACC_READ | unix.BPF_DEVCG_ACC_WRITE | unix.BPF_DEVCG_ACC_MKNOD
+
+func parseDeviceAccess(access string) (int64, error) {
+	var bits int64
+	for _, r := range access {
+		switch r {
+		case 'r':
+			bits |= unix.BPF_DEVCG_ACC_READ
+		case 'w':
+			bits |= unix.BPF_DEVCG_ACC_WRITE
+		case 'm':
+			bits |= unix.BPF_DEVCG_ACC_MKNOD
+		default:
+			return 0, fmt.Errorf("unknown device access %v", r)
+		}
+	}
+	return bits, nil
+}
+
+// DeviceAccessChange is a change of the access to devices between two sets
+// of device rules.
+type DeviceAccessChange struct {
+	// Type is "c" or "b".
+	Type string
+	// Major and Minor are -1 for any number not named by a rule of either
+	// set.
+	Major int64
+	Minor int64
+	// Gained and Lost are the access types allowed only by the new and only
+	// by the old set, respectively.
+	Gained string
+	Lost   string
+}
+
+// DiffDevices returns the devices whose access differs between the old and
+// the new device rules, e.g. to show the effect of an update. Each access
+// type is checked on its own.
+func DiffDevices(old, new []specs.LinuxDeviceCgroup) ([]DeviceAccessChange, error) {
+	var (
+		majors = map[int64]struct{}{}
+		minors = map[int64]struct{}{}
+	)
+	for _, dev := range append(append([]specs.LinuxDeviceCgroup{}, old...), new...) {
+		if dev.Major != nil && *dev.Major >= 0 {
+			majors[*dev.Major] = struct{}{}
+		}
+		if dev.Minor != nil && *dev.Minor >= 0 {
+			minors[*dev.Minor] = struct{}{}
+		}
+	}
+	// A number not named by any rule stands for all of them.
+	otherMajor, otherMinor := unusedNumber(majors), unusedNumber(minors)
+
+	var changes []DeviceAccessChange
+	for _, typ := range []string{"c", "b"} {
+		for _, major := range append(sortedNumbers(majors), otherMajor) {
+			for _, minor := range append(sortedNumbers(minors), otherMinor) {
+				change := DeviceAccessChange{Type: typ, Major: major, Minor: minor}
+				for _, bit := range []int64{unix.BPF_DEVCG_ACC_READ, unix.BPF_DEVCG_ACC_WRITE, unix.BPF_DEVCG_ACC_MKNOD} {
+					was, err := allowed(old, typ, major, minor, bit)
+					if err != nil {
+						return nil, err
+					}
+					is, err := allowed(new, typ, major, minor, bit)
+					if err != nil {
+						return nil, err
+					}
+					switch {
+					case is && !was:
+						change.Gained += deviceAccess(bit)
+					case was && !is:
+						change.Lost += deviceAccess(bit)
+					}
+				}
+				if change.Gained == "" && change.Lost == "" {
+					continue
+				}
+				if major == otherMajor {
+					change.Major = -1
+				}
+				if minor == otherMinor {
+					change.Minor = -1
+				}
+				changes = append(changes, change)
+			}
+		}
+	}
+	return changes, nil
+}
+
+func allowed(devices []specs.LinuxDeviceCgroup, typ string, major, minor, access int64) (bool, error) {
+	for i := len(devices) - 1; i >= 0; i-- {
+		ok, err := matchDevice(devices[i], typ, major, minor, access)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return devices[i].Allow, nil
+		}
+	}
+	return false, nil
+}
+
+func sortedNumbers(set map[int64]struct{}) []int64 {
+	numbers := make([]int64, 0, len(set))
+	for n := range set {
+		numbers = append(numbers, n)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers
+}
+
+func unusedNumber(set map[int64]struct{}) int64 {
+	n := int64(math.MaxUint32)
+	for {
+		if _, ok := set[n]; !ok {
+			return n
+		}
+		n--
+	}
+}
@@ -0,0 +1,95 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceAllowed(t *testing.T) {
+	devices := []specs.LinuxDeviceCgroup{
+		{Allow: false, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"},
+		{Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(-1), Access: "rw"},
+		{Allow: false, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(1), Access: "rwm"},
+		{Allow: true, Type: "b", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "m"},
+	}
+	insts, _, err := DeviceFilter(devices)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		req     DeviceRequest
+		allowed bool
+	}{
+		{DeviceRequest{Type: "c", Major: 1, Minor: 3, Access: "r"}, true},
+		{DeviceRequest{Type: "c", Major: 1, Minor: 3, Access: "m"}, false},
+		// A rule matches when it shares any access type with the request.
+		{DeviceRequest{Type: "c", Major: 1, Minor: 3, Access: "rm"}, true},
+		{DeviceRequest{Type: "c", Major: 1, Minor: 1, Access: "r"}, false},
+		{DeviceRequest{Type: "c", Major: 5, Minor: 1, Access: "r"}, false},
+		{DeviceRequest{Type: "b", Major: 8, Minor: 0, Access: "m"}, true},
+		{DeviceRequest{Type: "b", Major: 8, Minor: 0, Access: "r"}, false},
+	} {
+		allowed, err := DeviceAllowed(devices, tc.req)
+		require.NoError(t, err)
+		assert.Equalf(t, tc.allowed, allowed, "%+v", tc.req)
+
+		allowed, err = DeviceFilterAllowed(insts, tc.req)
+		require.NoError(t, err)
+		assert.Equalf(t, tc.allowed, allowed, "%+v", tc.req)
+	}
+
+	allowed, err := DeviceAllowed(nil, DeviceRequest{Type: "c", Major: 1, Minor: 3, Access: "r"})
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	_, err = DeviceAllowed(devices, DeviceRequest{Type: "a", Access: "r"})
+	assert.Error(t, err)
+	_, err = DeviceAllowed(devices, DeviceRequest{Type: "c", Access: "x"})
+	assert.Error(t, err)
+}
+
+func TestDiffDevices(t *testing.T) {
+	denyAll := specs.LinuxDeviceCgroup{Allow: false, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"}
+	old := []specs.LinuxDeviceCgroup{
+		denyAll,
+		{Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(3), Access: "rwm"},
+		{Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(5), Access: "rw"},
+	}
+	new := []specs.LinuxDeviceCgroup{
+		denyAll,
+		{Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(5), Access: "r"},
+		{Allow: true, Type: "c", Major: pointerInt64(10), Minor: pointerInt64(-1), Access: "rwm"},
+	}
+
+	changes, err := DiffDevices(old, new)
+	require.NoError(t, err)
+	assert.Equal(t, []DeviceAccessChange{
+		{Type: "c", Major: 1, Minor: 3, Lost: "rwm"},
+		{Type: "c", Major: 1, Minor: 5, Lost: "w"},
+		{Type: "c", Major: 10, Minor: 3, Gained: "rwm"},
+		{Type: "c", Major: 10, Minor: 5, Gained: "rwm"},
+		{Type: "c", Major: 10, Minor: -1, Gained: "rwm"},
+	}, changes)
+
+	changes, err = DiffDevices(old, old)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
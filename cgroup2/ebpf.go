@@ -17,7 +17,11 @@
 package cgroup2
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"runtime"
+	"unsafe"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/asm"
@@ -26,8 +30,23 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+// deviceFilterName is the name of the device filter programs loaded by this
+// package, which tells them apart from those attached by other managers,
+// e.g. systemd.
+const deviceFilterName = "cgroups_devices"
+
 // LoadAttachCgroupDeviceFilter installs eBPF device filter program to /sys/fs/cgroup/<foo> directory.
 //
+// The device filter previously attached by this package, possibly by another
+// process, is atomically replaced by the new one when the kernel supports
+// BPF_F_REPLACE (Linux 5.6). Otherwise the new filter is attached first and the
+// previous ones are detached. Programs attached by others are left in place.
+// Unnamed programs generated by DeviceFilter, which older versions of this
+// package attached, are replaced as well.
+//
+// If a previous filter cannot be detached, the error is returned along with
+// the closer of the new filter, which stays attached.
+//
 // Requires the system to be running in cgroup2 unified-mode with kernel >= 4.15 .
 //
 // https://github.com/torvalds/linux/commit/ebc614f687369f9df99828572b1d85a7c2de3d92
@@ -36,6 +55,7 @@ func LoadAttachCgroupDeviceFilter(insts asm.Instructions, license string, dirFD
 		return nil
 	}
 	spec := &ebpf.ProgramSpec{
+		Name:         deviceFilterName,
 		Type:         ebpf.CGroupDevice,
 		Instructions: insts,
 		License:      license,
@@ -44,29 +64,195 @@ func LoadAttachCgroupDeviceFilter(insts asm.Instructions, license string, dirFD
 	if err != nil {
 		return nilCloser, err
 	}
-	err = link.RawAttachProgram(link.RawAttachProgramOptions{
-		Target:  dirFD,
-		Program: prog,
-		Attach:  ebpf.AttachCGroupDevice,
-		Flags:   unix.BPF_F_ALLOW_MULTI,
-	})
+	old, err := attachedDeviceFilters(dirFD)
 	if err != nil {
-		return nilCloser, fmt.Errorf("failed to call BPF_PROG_ATTACH (BPF_CGROUP_DEVICE, BPF_F_ALLOW_MULTI): %w", err)
+		prog.Close()
+		return nilCloser, err
 	}
-	closer := func() error {
-		err = link.RawDetachProgram(link.RawDetachProgramOptions{
+	defer closeAll(old)
+
+	replaced := false
+	if len(old) > 0 {
+		err = attachReplace(dirFD, prog, old[0])
+		// BPF_F_REPLACE fails with EINVAL before Linux 5.6.
+		replaced = err == nil
+		if err != nil && !errors.Is(err, unix.EINVAL) {
+			prog.Close()
+			return nilCloser, fmt.Errorf("failed to call BPF_PROG_ATTACH (BPF_CGROUP_DEVICE, BPF_F_ALLOW_MULTI|BPF_F_REPLACE): %w", err)
+		}
+	}
+	if !replaced {
+		err = link.RawAttachProgram(link.RawAttachProgramOptions{
 			Target:  dirFD,
 			Program: prog,
 			Attach:  ebpf.AttachCGroupDevice,
+			Flags:   unix.BPF_F_ALLOW_MULTI,
 		})
 		if err != nil {
-			return fmt.Errorf("failed to call BPF_PROG_DETACH (BPF_CGROUP_DEVICE): %w", err)
+			prog.Close()
+			return nilCloser, fmt.Errorf("failed to call BPF_PROG_ATTACH (BPF_CGROUP_DEVICE, BPF_F_ALLOW_MULTI): %w", err)
+		}
+	}
+	closer := func() error {
+		defer prog.Close()
+		return detachDeviceFilter(dirFD, prog)
+	}
+	for i, p := range old {
+		if replaced && i == 0 {
+			continue
+		}
+		if err := detachDeviceFilter(dirFD, p); err != nil {
+			return closer, err
 		}
-		return nil
 	}
 	return closer, nil
 }
 
+// attachReplace atomically replaces the old program attached to the cgroup
+// by prog. It calls bpf(2) directly because link.RawAttachProgram does not set
+// BPF_F_REPLACE along with the program to replace.
+func attachReplace(dirFD int, prog, old *ebpf.Program) error {
+	attr := struct {
+		targetFD     uint32
+		attachBpfFD  uint32
+		attachType   uint32
+		attachFlags  uint32
+		replaceBpfFD uint32
+	}{
+		targetFD:     uint32(dirFD),
+		attachBpfFD:  uint32(prog.FD()),
+		attachType:   uint32(ebpf.AttachCGroupDevice),
+		attachFlags:  unix.BPF_F_ALLOW_MULTI | unix.BPF_F_REPLACE,
+		replaceBpfFD: uint32(old.FD()),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	runtime.KeepAlive(prog)
+	runtime.KeepAlive(old)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func detachDeviceFilter(dirFD int, prog *ebpf.Program) error {
+	err := link.RawDetachProgram(link.RawDetachProgramOptions{
+		Target:  dirFD,
+		Program: prog,
+		Attach:  ebpf.AttachCGroupDevice,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to call BPF_PROG_DETACH (BPF_CGROUP_DEVICE): %w", err)
+	}
+	return nil
+}
+
+// attachedDeviceFilters returns the device filters loaded by this package and
+// attached to the cgroup. The caller must close them.
+func attachedDeviceFilters(dirFD int) ([]*ebpf.Program, error) {
+	res, err := link.QueryPrograms(link.QueryOptions{
+		Target: dirFD,
+		Attach: ebpf.AttachCGroupDevice,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to call BPF_PROG_QUERY (BPF_CGROUP_DEVICE): %w", err)
+	}
+	var progs []*ebpf.Program
+	for _, ap := range res.Programs {
+		p, err := ebpf.NewProgramFromID(ap.ID)
+		if err != nil {
+			// The program was detached since the query.
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			closeAll(progs)
+			return nil, err
+		}
+		info, err := p.Info()
+		if err != nil {
+			p.Close()
+			closeAll(progs)
+			return nil, err
+		}
+		if !ownDeviceFilter(info) {
+			p.Close()
+			continue
+		}
+		progs = append(progs, p)
+	}
+	return progs, nil
+}
+
+// ownDeviceFilter reports whether the program was loaded by this package.
+// Older versions did not name their programs, so unnamed programs are
+// recognized by their instructions.
+func ownDeviceFilter(info *ebpf.ProgramInfo) bool {
+	if info.Name == deviceFilterName {
+		return true
+	}
+	if info.Name != "" {
+		return false
+	}
+	insts, err := info.Instructions()
+	if err != nil {
+		return false
+	}
+	_, err = DecodeDeviceFilter(insts)
+	return err == nil
+}
+
+// AttachedDeviceFilter is a BPF_CGROUP_DEVICE program attached to a cgroup.
+type AttachedDeviceFilter struct {
+	ID   ebpf.ProgramID
+	Name string
+	// Devices are the rules of the program, decoded with DecodeDeviceFilter.
+	// It is nil for programs not generated by DeviceFilter.
+	Devices []specs.LinuxDeviceCgroup
+}
+
+// queryDeviceFilters returns the device filters attached to the cgroup, and
+// also to its ancestors when effective is set.
+func queryDeviceFilters(dirFD int, effective bool) ([]AttachedDeviceFilter, error) {
+	var flags uint32
+	if effective {
+		flags = unix.BPF_F_QUERY_EFFECTIVE
+	}
+	res, err := link.QueryPrograms(link.QueryOptions{
+		Target:     dirFD,
+		Attach:     ebpf.AttachCGroupDevice,
+		QueryFlags: flags,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to call BPF_PROG_QUERY (BPF_CGROUP_DEVICE): %w", err)
+	}
+	var filters []AttachedDeviceFilter
+	for _, ap := range res.Programs {
+		p, err := ebpf.NewProgramFromID(ap.ID)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		info, err := p.Info()
+		p.Close()
+		if err != nil {
+			return nil, err
+		}
+		f := AttachedDeviceFilter{ID: ap.ID, Name: info.Name}
+		if insts, err := info.Instructions(); err == nil {
+			f.Devices, _ = DecodeDeviceFilter(insts)
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func closeAll(progs []*ebpf.Program) {
+	for _, p := range progs {
+		p.Close()
+	}
+}
+
 func isRWM(cgroupPermissions string) bool {
 	r := false
 	w := false
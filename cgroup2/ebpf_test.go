@@ -0,0 +1,198 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// newDeviceFilterCgroup creates a cgroup in the cgroup v2 hierarchy of the
+// host, which may be mounted in /sys/fs/cgroup/unified in hybrid mode.
+func newDeviceFilterCgroup(t *testing.T) *Manager {
+	if os.Getuid() != 0 {
+		t.Skip("requires root")
+	}
+	if !haveDeviceFilter() {
+		t.Skip("eBPF device filters are not supported")
+	}
+	mountpoint := ""
+	for _, p := range []string{defaultCgroup2Path, filepath.Join(defaultCgroup2Path, "unified")} {
+		var st unix.Statfs_t
+		if err := unix.Statfs(p, &st); err == nil && st.Type == unix.CGROUP2_SUPER_MAGIC {
+			mountpoint = p
+			break
+		}
+	}
+	if mountpoint == "" {
+		t.Skip("no cgroup v2 hierarchy")
+	}
+	m, err := NewManager(mountpoint, "/"+t.Name(), &Resources{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = m.Delete() })
+	return m
+}
+
+func attachedPrograms(t *testing.T, m *Manager) []ebpf.ProgramID {
+	dirFD, err := unix.Open(m.path, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	require.NoError(t, err)
+	defer unix.Close(dirFD)
+	res, err := link.QueryPrograms(link.QueryOptions{Target: dirFD, Attach: ebpf.AttachCGroupDevice})
+	require.NoError(t, err)
+	var ids []ebpf.ProgramID
+	for _, p := range res.Programs {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func TestDeviceFilterReplace(t *testing.T) {
+	m := newDeviceFilterCgroup(t)
+
+	prog, err := m.DeviceFilterProgram()
+	require.NoError(t, err)
+	assert.Nil(t, prog)
+
+	major, minor := int64(1), int64(3)
+	devices := []specs.LinuxDeviceCgroup{
+		{Allow: false, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"},
+		{Allow: true, Type: "c", Major: &major, Minor: &minor, Access: "rwm"},
+	}
+	require.NoError(t, m.Update(&Resources{Devices: devices}))
+	first := attachedPrograms(t, m)
+	require.Len(t, first, 1)
+
+	// Revoking /dev/null replaces the filter instead of adding one.
+	require.NoError(t, m.Update(&Resources{Devices: devices[:1]}))
+	second := attachedPrograms(t, m)
+	require.Len(t, second, 1)
+	assert.NotEqual(t, first, second)
+
+	prog, err = m.DeviceFilterProgram()
+	require.NoError(t, err)
+	require.NotNil(t, prog)
+	defer prog.Close()
+	info, err := prog.Info()
+	require.NoError(t, err)
+	id, _ := info.ID()
+	assert.Equal(t, second[0], id)
+	assert.Equal(t, deviceFilterName, info.Name)
+}
+
+func TestDeviceFilterKeepsForeignPrograms(t *testing.T) {
+	m := newDeviceFilterCgroup(t)
+	dirFD, err := unix.Open(m.path, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	require.NoError(t, err)
+	defer unix.Close(dirFD)
+
+	insts, license, err := DeviceFilter([]specs.LinuxDeviceCgroup{{Allow: true, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"}})
+	require.NoError(t, err)
+	foreign, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:         "sd_devices",
+		Type:         ebpf.CGroupDevice,
+		Instructions: insts,
+		License:      license,
+	})
+	require.NoError(t, err)
+	defer foreign.Close()
+	require.NoError(t, link.RawAttachProgram(link.RawAttachProgramOptions{
+		Target:  dirFD,
+		Program: foreign,
+		Attach:  ebpf.AttachCGroupDevice,
+		Flags:   unix.BPF_F_ALLOW_MULTI,
+	}))
+
+	devices := []specs.LinuxDeviceCgroup{{Allow: false, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"}}
+	require.NoError(t, m.Update(&Resources{Devices: devices}))
+	require.NoError(t, m.Update(&Resources{Devices: devices}))
+	assert.Len(t, attachedPrograms(t, m), 2)
+
+	closer, err := LoadAttachCgroupDeviceFilter(insts, license, dirFD)
+	require.NoError(t, err)
+	assert.Len(t, attachedPrograms(t, m), 2)
+	require.NoError(t, closer())
+	assert.Len(t, attachedPrograms(t, m), 1)
+}
+
+func TestDeviceFilterReplacesUnnamedPrograms(t *testing.T) {
+	m := newDeviceFilterCgroup(t)
+	dirFD, err := unix.Open(m.path, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	require.NoError(t, err)
+	defer unix.Close(dirFD)
+
+	// Older versions of this package did not name their programs.
+	insts, license, err := DeviceFilter([]specs.LinuxDeviceCgroup{{Allow: true, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"}})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+			Type:         ebpf.CGroupDevice,
+			Instructions: insts,
+			License:      license,
+		})
+		require.NoError(t, err)
+		defer prog.Close()
+		require.NoError(t, link.RawAttachProgram(link.RawAttachProgramOptions{
+			Target:  dirFD,
+			Program: prog,
+			Attach:  ebpf.AttachCGroupDevice,
+			Flags:   unix.BPF_F_ALLOW_MULTI,
+		}))
+	}
+	require.Len(t, attachedPrograms(t, m), 2)
+
+	devices := []specs.LinuxDeviceCgroup{{Allow: false, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"}}
+	require.NoError(t, m.Update(&Resources{Devices: devices}))
+	filters, err := m.DeviceFilters(false)
+	require.NoError(t, err)
+	require.Len(t, filters, 1)
+	assert.Equal(t, deviceFilterName, filters[0].Name)
+	assert.Equal(t, devices, filters[0].Devices)
+}
+
+func TestDeviceFilters(t *testing.T) {
+	m := newDeviceFilterCgroup(t)
+	devices := []specs.LinuxDeviceCgroup{
+		{Allow: false, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"},
+		{Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(3), Access: "rw"},
+	}
+	require.NoError(t, m.Update(&Resources{Devices: devices}))
+	child, err := m.NewChild("child", &Resources{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = child.Delete() })
+
+	filters, err := m.DeviceFilters(false)
+	require.NoError(t, err)
+	require.Len(t, filters, 1)
+	assert.Equal(t, deviceFilterName, filters[0].Name)
+	assert.Equal(t, devices, filters[0].Devices)
+
+	filters, err = child.(*Manager).DeviceFilters(false)
+	require.NoError(t, err)
+	assert.Empty(t, filters)
+	filters, err = child.(*Manager).DeviceFilters(true)
+	require.NoError(t, err)
+	require.Len(t, filters, 1)
+	assert.Equal(t, devices, filters[0].Devices)
+}
@@ -0,0 +1,196 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// deviceFilterLinkName is the name of the device filter programs attached by
+// DeviceFilterLink. It differs from deviceFilterName so that
+// LoadAttachCgroupDeviceFilter does not try to replace them.
+const deviceFilterLinkName = "cgroups_devlink"
+
+// DeviceFilterLink is an eBPF device filter attached to a cgroup with a
+// bpf_link (Linux 5.7). Unlike LoadAttachCgroupDeviceFilter, the filter is
+// detached when the link is removed, and the link can be pinned in a bpffs
+// directory to outlive the process and be loaded again after a restart.
+type DeviceFilterLink struct {
+	link link.Link
+	prog *ebpf.Program
+	// dir is the directory where the link is pinned, empty if it is not
+	// pinned. The program is not pinned: the link holds it, and it is found
+	// from the link info when loading the pin.
+	dir string
+}
+
+// CgroupID returns the ID of the cgroup at path, which is the inode number of
+// its directory.
+func CgroupID(path string) (uint64, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	return st.Ino, nil
+}
+
+// deviceFilterLinkDir returns the directory holding the pins of the device
+// filter of the cgroup at path, keyed by its ID.
+func deviceFilterLinkDir(pinRoot, path string) (string, error) {
+	id, err := CgroupID(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(pinRoot, strconv.FormatUint(id, 10)), nil
+}
+
+func loadDeviceFilter(devices []specs.LinuxDeviceCgroup) (*ebpf.Program, error) {
+	insts, license, err := DeviceFilter(devices)
+	if err != nil {
+		return nil, err
+	}
+	return ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:         deviceFilterLinkName,
+		Type:         ebpf.CGroupDevice,
+		Instructions: insts,
+		License:      license,
+	})
+}
+
+// AttachDeviceFilterLink attaches a device filter for the devices to the
+// cgroup at path, a cgroup v2 directory. When pinRoot is set, the link is
+// pinned in pinRoot/<cgroup ID>, pinRoot being in a bpffs mount, e.g.
+// /sys/fs/bpf/cgroups.
+func AttachDeviceFilterLink(path string, devices []specs.LinuxDeviceCgroup, pinRoot string) (*DeviceFilterLink, error) {
+	prog, err := loadDeviceFilter(devices)
+	if err != nil {
+		return nil, err
+	}
+	l, err := link.AttachCgroup(link.CgroupOptions{
+		Path:    path,
+		Attach:  ebpf.AttachCGroupDevice,
+		Program: prog,
+	})
+	if err != nil {
+		prog.Close()
+		return nil, err
+	}
+	f := &DeviceFilterLink{link: l, prog: prog}
+	if pinRoot == "" {
+		return f, nil
+	}
+	dir, err := deviceFilterLinkDir(pinRoot, path)
+	if err == nil {
+		err = f.pin(dir)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// LoadDeviceFilterLink loads the device filter link pinned by
+// AttachDeviceFilterLink for the cgroup at path.
+func LoadDeviceFilterLink(path, pinRoot string) (*DeviceFilterLink, error) {
+	dir, err := deviceFilterLinkDir(pinRoot, path)
+	if err != nil {
+		return nil, err
+	}
+	l, err := link.LoadPinnedLink(filepath.Join(dir, "link"), nil)
+	if err != nil {
+		return nil, err
+	}
+	info, err := l.Info()
+	if err == nil {
+		var prog *ebpf.Program
+		if prog, err = ebpf.NewProgramFromID(info.Program); err == nil {
+			return &DeviceFilterLink{link: l, prog: prog, dir: dir}, nil
+		}
+	}
+	l.Close()
+	return nil, fmt.Errorf("cannot get the program of the device filter link: %w", err)
+}
+
+func (f *DeviceFilterLink) pin(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	if err := f.link.Pin(filepath.Join(dir, "link")); err != nil {
+		return fmt.Errorf("cannot pin device filter link: %w", err)
+	}
+	f.dir = dir
+	return nil
+}
+
+// Program returns the program currently attached by the link.
+func (f *DeviceFilterLink) Program() *ebpf.Program {
+	return f.prog
+}
+
+// Devices returns the rules of the attached filter.
+func (f *DeviceFilterLink) Devices() ([]specs.LinuxDeviceCgroup, error) {
+	info, err := f.prog.Info()
+	if err != nil {
+		return nil, err
+	}
+	insts, err := info.Instructions()
+	if err != nil {
+		return nil, err
+	}
+	return DecodeDeviceFilter(insts)
+}
+
+// Update atomically replaces the filter by one for the devices. The pinned
+// link then holds the new program, nothing else needs to be pinned again.
+func (f *DeviceFilterLink) Update(devices []specs.LinuxDeviceCgroup) error {
+	prog, err := loadDeviceFilter(devices)
+	if err != nil {
+		return err
+	}
+	if err := f.link.Update(prog); err != nil {
+		prog.Close()
+		return err
+	}
+	old := f.prog
+	f.prog = prog
+	return old.Close()
+}
+
+// Close releases the link and the program. The filter stays attached if they
+// are pinned, and is detached otherwise.
+func (f *DeviceFilterLink) Close() error {
+	return errors.Join(f.link.Close(), f.prog.Close())
+}
+
+// Remove detaches the filter and removes its pins.
+func (f *DeviceFilterLink) Remove() error {
+	var errs []error
+	if f.dir != "" {
+		errs = append(errs, f.link.Unpin(), os.Remove(f.dir))
+	}
+	return errors.Join(append(errs, f.Close())...)
+}
@@ -0,0 +1,100 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func mountBPFFS(t *testing.T) string {
+	dir := t.TempDir()
+	if err := unix.Mount("bpf", dir, "bpf", 0, ""); err != nil {
+		t.Skipf("cannot mount bpffs: %v", err)
+	}
+	t.Cleanup(func() { _ = unix.Unmount(dir, 0) })
+	return dir
+}
+
+func TestDeviceFilterLinkPinned(t *testing.T) {
+	m := newDeviceFilterCgroup(t)
+	pinRoot := filepath.Join(mountBPFFS(t), "cgroups")
+	denyAll := specs.LinuxDeviceCgroup{Allow: false, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"}
+	null := specs.LinuxDeviceCgroup{Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(3), Access: "rwm"}
+
+	f, err := AttachDeviceFilterLink(m.path, []specs.LinuxDeviceCgroup{denyAll, null}, pinRoot)
+	require.NoError(t, err)
+	id, err := CgroupID(m.path)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(pinRoot, strconv.FormatUint(id, 10), "link"))
+	// The program is held by the link, it is not pinned separately.
+	assert.NoFileExists(t, filepath.Join(pinRoot, strconv.FormatUint(id, 10), "prog"))
+	// The pinned filter outlives the link.
+	require.NoError(t, f.Close())
+
+	filters, err := m.DeviceFilters(false)
+	require.NoError(t, err)
+	require.Len(t, filters, 1)
+	assert.Equal(t, deviceFilterLinkName, filters[0].Name)
+	assert.Equal(t, []specs.LinuxDeviceCgroup{denyAll, null}, filters[0].Devices)
+
+	f, err = LoadDeviceFilterLink(m.path, pinRoot)
+	require.NoError(t, err)
+	require.NoError(t, f.Update([]specs.LinuxDeviceCgroup{denyAll}))
+	devices, err := f.Devices()
+	require.NoError(t, err)
+	assert.Equal(t, []specs.LinuxDeviceCgroup{denyAll}, devices)
+	require.NoError(t, f.Close())
+
+	f, err = LoadDeviceFilterLink(m.path, pinRoot)
+	require.NoError(t, err)
+	filters, err = m.DeviceFilters(false)
+	require.NoError(t, err)
+	require.Len(t, filters, 1)
+	assert.Equal(t, []specs.LinuxDeviceCgroup{denyAll}, filters[0].Devices)
+
+	require.NoError(t, f.Remove())
+	filters, err = m.DeviceFilters(false)
+	require.NoError(t, err)
+	assert.Empty(t, filters)
+	assert.NoDirExists(t, filepath.Join(pinRoot, strconv.FormatUint(id, 10)))
+}
+
+func TestDeviceFilterLinkUnpinned(t *testing.T) {
+	m := newDeviceFilterCgroup(t)
+	devices := []specs.LinuxDeviceCgroup{{Allow: false, Type: "a", Major: pointerInt64(-1), Minor: pointerInt64(-1), Access: "rwm"}}
+	f, err := AttachDeviceFilterLink(m.path, devices, "")
+	require.NoError(t, err)
+
+	// LoadAttachCgroupDeviceFilter leaves the link alone.
+	require.NoError(t, m.Update(&Resources{Devices: devices}))
+	filters, err := m.DeviceFilters(false)
+	require.NoError(t, err)
+	assert.Len(t, filters, 2)
+
+	require.NoError(t, f.Close())
+	filters, err = m.DeviceFilters(false)
+	require.NoError(t, err)
+	require.Len(t, filters, 1)
+	assert.Equal(t, deviceFilterName, filters[0].Name)
+}
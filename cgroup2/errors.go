@@ -18,9 +18,70 @@ package cgroup2
 
 import (
 	"errors"
+	"fmt"
+
+	"github.com/containerd/cgroups/v3/internal/memcheck"
 )
 
 var (
 	ErrInvalidFormat    = errors.New("cgroups: parsing file with invalid format failed")
 	ErrInvalidGroupPath = errors.New("cgroups: invalid group path")
+	// ErrImproperDelegation is returned when a cgroup cannot be delegated
+	// because its parent is delegated to another user or only partially delegated.
+	ErrImproperDelegation = errors.New("cgroups: parent cgroup is improperly delegated")
+	// ErrUnknownDeviceFilter is returned when decoding a device filter program
+	// that was not generated by DeviceFilter.
+	ErrUnknownDeviceFilter = errors.New("cgroups: device filter was not generated by DeviceFilter")
+	// ErrInvalidUnified is returned when an entry of Resources.Unified cannot
+	// be written, see Resources.Unified.
+	ErrInvalidUnified = errors.New("cgroups: invalid unified resource")
+	// ErrNotSupported is returned when a resource requires a feature that
+	// the kernel does not provide, see Features.
+	ErrNotSupported = errors.New("cgroups: not supported by the kernel")
+
+	// Errors matching the result of a failed systemd job, see JobError.
+	ErrJobCanceled   = errors.New("cgroups: systemd job canceled")
+	ErrJobTimeout    = errors.New("cgroups: systemd job timed out")
+	ErrJobFailed     = errors.New("cgroups: systemd job failed")
+	ErrJobDependency = errors.New("cgroups: systemd job dependency failed")
+	ErrJobSkipped    = errors.New("cgroups: systemd job skipped")
 )
+
+// MemoryLimitError is returned by Update when the memory usage of the cgroup
+// is above the new memory.max, see Memory.CheckBeforeUpdate.
+// It is the same type as cgroup1.MemoryLimitError.
+type MemoryLimitError = memcheck.LimitError
+
+// JobError is returned when a systemd job, e.g. starting or stopping a unit,
+// does not complete successfully. It matches ErrJobCanceled, ErrJobTimeout,
+// ErrJobFailed, ErrJobDependency or ErrJobSkipped with errors.Is, according
+// to its Result.
+type JobError struct {
+	// Unit is the name of the unit the job was for.
+	Unit string
+	// Op is the operation of the job, e.g. "start" or "stop".
+	Op string
+	// Result is the job result reported by systemd: "canceled", "timeout",
+	// "failed", "dependency" or "skipped".
+	Result string
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("cgroups: systemd %s job of unit %s: got %s", e.Op, e.Unit, e.Result)
+}
+
+func (e *JobError) Is(target error) bool {
+	switch e.Result {
+	case "canceled":
+		return target == ErrJobCanceled
+	case "timeout":
+		return target == ErrJobTimeout
+	case "failed":
+		return target == ErrJobFailed
+	case "dependency":
+		return target == ErrJobDependency
+	case "skipped":
+		return target == ErrJobSkipped
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+	"golang.org/x/sys/unix"
+)
+
+// kernelFeaturesFile lists the optional cgroup v2 features of the kernel,
+// such as the mount options it supports. It is available since Linux 4.15.
+var kernelFeaturesFile = "/sys/kernel/cgroup/features"
+
+// psiFile exists when the kernel provides the pressure stall information,
+// i.e. it is built with CONFIG_PSI and not booted with psi=0.
+var psiFile = "/proc/pressure/cpu"
+
+// kernelRelease returns the release of the running kernel, e.g. "6.1.0-13".
+// It is replaced in tests.
+var kernelRelease = func() string {
+	var u unix.Utsname
+	if err := unix.Uname(&u); err != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(u.Release[:])
+}
+
+// haveDeviceFilter reports whether eBPF device filters can be loaded. It is
+// replaced in tests.
+var haveDeviceFilter = func() bool {
+	return features.HaveProgramType(ebpf.CGroupDevice) == nil
+}
+
+var (
+	featuresMu    sync.Mutex
+	featuresCache = make(map[string]*FeatureSet)
+)
+
+// FeatureSet describes the cgroup v2 features available in a hierarchy.
+//
+// The files that only exist in non-root cgroups, or only when a controller is
+// enabled, are looked up in the root cgroup and its children. When none of
+// them has the file, the feature is derived from the kernel version and the
+// controllers available.
+type FeatureSet struct {
+	// Controllers are the controllers available in the root cgroup
+	Controllers []string
+	// KernelFeatures are the flags listed in /sys/kernel/cgroup/features,
+	// e.g. nsdelegate, favordynmods, memory_localevents or
+	// memory_recursiveprot
+	KernelFeatures []string
+	// DelegateFiles are the files listed in /sys/kernel/cgroup/delegate, see
+	// Manager.Delegate
+	DelegateFiles []string
+	// Kill is set when cgroup.kill is available (Linux 5.14)
+	Kill bool
+	// Freeze is set when cgroup.freeze is available (Linux 5.2)
+	Freeze bool
+	// MemoryPeak is set when memory.peak is available (Linux 5.19)
+	MemoryPeak bool
+	// MemoryReclaim is set when memory.reclaim is available (Linux 5.19)
+	MemoryReclaim bool
+	// CPUIdle is set when cpu.idle is available (Linux 5.15)
+	CPUIdle bool
+	// CPUMaxBurst is set when cpu.max.burst is available (Linux 5.14)
+	CPUMaxBurst bool
+	// PSI is set when the pressure stall information files, such as
+	// cpu.pressure, are available (Linux 4.20 built with CONFIG_PSI)
+	PSI bool
+	// DeviceFilter is set when eBPF device filters can be loaded and
+	// attached, which requires the privileges to load eBPF programs
+	DeviceFilter bool
+}
+
+// HasController returns whether the controller is available.
+func (f *FeatureSet) HasController(controller string) bool {
+	return slices.Contains(f.Controllers, controller)
+}
+
+// HasKernelFeature returns whether the kernel lists the feature in
+// /sys/kernel/cgroup/features.
+func (f *FeatureSet) HasKernelFeature(feature string) bool {
+	return slices.Contains(f.KernelFeatures, feature)
+}
+
+// Features probes the features of the cgroup v2 hierarchy mounted at
+// mountpoint. The result is cached per mountpoint and must not be modified.
+func Features(mountpoint string) (*FeatureSet, error) {
+	mountpoint = filepath.Clean(mountpoint)
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	if f, ok := featuresCache[mountpoint]; ok {
+		return f, nil
+	}
+	f, err := probeFeatures(mountpoint)
+	if err != nil {
+		return nil, err
+	}
+	featuresCache[mountpoint] = f
+	return f, nil
+}
+
+func probeFeatures(mountpoint string) (*FeatureSet, error) {
+	b, err := os.ReadFile(filepath.Join(mountpoint, controllersFile))
+	if err != nil {
+		return nil, err
+	}
+	f := &FeatureSet{Controllers: strings.Fields(string(b))}
+	if f.KernelFeatures, err = readKernelList(kernelFeaturesFile); err != nil {
+		return nil, err
+	}
+	if f.DelegateFiles, err = readKernelList(kernelDelegateFile); err != nil {
+		return nil, err
+	}
+
+	// Most files only exist in non-root cgroups or when a controller is
+	// enabled: they are looked up in the hierarchy, and the kernel version
+	// tells whether they would exist otherwise.
+	major, minor := kernelVersion(kernelRelease())
+	since := func(maj, min int, controller string) bool {
+		if controller != "" && !f.HasController(controller) {
+			return false
+		}
+		return major > maj || major == maj && minor >= min
+	}
+
+	dirs := []string{mountpoint}
+	entries, err := os.ReadDir(mountpoint)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			dirs = append(dirs, filepath.Join(mountpoint, e.Name()))
+		}
+	}
+	exists := func(name string) bool {
+		return slices.ContainsFunc(dirs, func(dir string) bool {
+			_, err := os.Stat(filepath.Join(dir, name))
+			return err == nil
+		})
+	}
+	f.Kill = exists(killFile) || since(5, 14, "")
+	f.Freeze = exists("cgroup.freeze") || since(5, 2, "")
+	f.MemoryPeak = exists("memory.peak") || since(5, 19, "memory")
+	f.MemoryReclaim = exists("memory.reclaim") || since(5, 19, "memory")
+	f.CPUIdle = exists("cpu.idle") || since(5, 15, "cpu")
+	f.CPUMaxBurst = exists("cpu.max.burst") || since(5, 14, "cpu")
+	_, err = os.Stat(psiFile)
+	f.PSI = err == nil || exists("cpu.pressure")
+	f.DeviceFilter = haveDeviceFilter()
+	return f, nil
+}
+
+// kernelVersion parses the major and minor versions of a kernel release,
+// 0, 0 if it cannot be parsed.
+func kernelVersion(release string) (int, int) {
+	var major, minor int
+	if _, err := fmt.Sscanf(release, "%d.%d", &major, &minor); err != nil {
+		return 0, 0
+	}
+	return major, minor
+}
+
+// checkFeatures fails with ErrNotSupported if resources use a feature that
+// the hierarchy mounted at mountpoint does not provide. Nothing is checked
+// when the features cannot be probed.
+func checkFeatures(mountpoint string, resources *Resources) error {
+	if resources == nil {
+		return nil
+	}
+	f, err := Features(mountpoint)
+	if err != nil {
+		return nil
+	}
+	if cpu := resources.CPU; cpu != nil {
+		if cpu.Idle != nil && !f.CPUIdle {
+			return fmt.Errorf("cpu.idle: %w", ErrNotSupported)
+		}
+		if cpu.Burst != nil && !f.CPUMaxBurst {
+			return fmt.Errorf("cpu.max.burst: %w", ErrNotSupported)
+		}
+	}
+	if mem := resources.Memory; mem != nil && mem.CheckBeforeUpdate == MemoryCheckReclaim && !f.MemoryReclaim {
+		return fmt.Errorf("memory.reclaim: %w", ErrNotSupported)
+	}
+	return nil
+}
+
+// readKernelList reads a list of names, one per line, from sysfs. It returns
+// nil if the kernel does not provide the file.
+func readKernelList(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return strings.Fields(string(b)), nil
+}
@@ -0,0 +1,121 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatures(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, controllersFile), []byte("cpu memory pids\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "memory.reclaim"), nil, 0o644))
+	child := filepath.Join(root, "system.slice")
+	require.NoError(t, os.Mkdir(child, 0o755))
+	for _, name := range []string{killFile, "cgroup.freeze", "cpu.idle", "cpu.pressure"} {
+		require.NoError(t, os.WriteFile(filepath.Join(child, name), nil, 0o644))
+	}
+
+	kernel := filepath.Join(t.TempDir(), "features")
+	require.NoError(t, os.WriteFile(kernel, []byte("nsdelegate\nfavordynmods\nmemory_localevents\n"), 0o644))
+	defer func(old string) { kernelFeaturesFile = old }(kernelFeaturesFile)
+	kernelFeaturesFile = kernel
+	defer func(old string) { kernelDelegateFile = old }(kernelDelegateFile)
+	kernelDelegateFile = filepath.Join(t.TempDir(), "missing")
+	defer func(old func() bool) { haveDeviceFilter = old }(haveDeviceFilter)
+	haveDeviceFilter = func() bool { return true }
+	defer func(old func() string) { kernelRelease = old }(kernelRelease)
+	kernelRelease = func() string { return "5.10.0-26-amd64" }
+	defer func(old string) { psiFile = old }(psiFile)
+	psiFile = filepath.Join(t.TempDir(), "missing")
+
+	f, err := Features(root)
+	require.NoError(t, err)
+	assert.Equal(t, &FeatureSet{
+		Controllers:    []string{"cpu", "memory", "pids"},
+		KernelFeatures: []string{"nsdelegate", "favordynmods", "memory_localevents"},
+		Kill:           true,
+		Freeze:         true,
+		MemoryReclaim:  true,
+		CPUIdle:        true,
+		PSI:            true,
+		DeviceFilter:   true,
+	}, f)
+	assert.True(t, f.HasController("memory"))
+	assert.False(t, f.HasController("io"))
+	assert.True(t, f.HasKernelFeature("favordynmods"))
+	assert.False(t, f.HasKernelFeature("memory_recursiveprot"))
+
+	// The result is cached per mountpoint.
+	require.NoError(t, os.WriteFile(filepath.Join(child, "memory.peak"), nil, 0o644))
+	cached, err := Features(root + "/")
+	require.NoError(t, err)
+	assert.Same(t, f, cached)
+}
+
+func TestFeaturesKernelVersion(t *testing.T) {
+	defer func(old func() string) { kernelRelease = old }(kernelRelease)
+	defer func(old string) { psiFile = old }(psiFile)
+	psiFile = filepath.Join(t.TempDir(), "missing")
+
+	for _, tc := range []struct {
+		release string
+		want    FeatureSet
+	}{
+		{"5.4.0", FeatureSet{Freeze: true}},
+		{"5.15.0", FeatureSet{Freeze: true, Kill: true, CPUMaxBurst: true, CPUIdle: true}},
+		{"6.1.0", FeatureSet{Freeze: true, Kill: true, CPUMaxBurst: true, CPUIdle: true, MemoryPeak: true, MemoryReclaim: true}},
+	} {
+		// The hierarchy has no child cgroup, the kernel version is used.
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, controllersFile), []byte("cpu memory\n"), 0o644))
+		kernelRelease = func() string { return tc.release }
+		f, err := Features(root)
+		require.NoError(t, err)
+		tc.want.Controllers = []string{"cpu", "memory"}
+		tc.want.DeviceFilter = f.DeviceFilter
+		tc.want.KernelFeatures = f.KernelFeatures
+		tc.want.DelegateFiles = f.DelegateFiles
+		assert.Equal(t, &tc.want, f, tc.release)
+	}
+}
+
+func TestCheckFeatures(t *testing.T) {
+	defer func(old func() string) { kernelRelease = old }(kernelRelease)
+	kernelRelease = func() string { return "5.4.0" }
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, controllersFile), []byte("cpu memory\n"), 0o644))
+
+	idle := int64(1)
+	err := checkFeatures(root, &Resources{CPU: &CPU{Idle: &idle}})
+	assert.ErrorIs(t, err, ErrNotSupported)
+	err = checkFeatures(root, &Resources{Memory: &Memory{CheckBeforeUpdate: MemoryCheckReclaim}})
+	assert.ErrorIs(t, err, ErrNotSupported)
+	assert.NoError(t, checkFeatures(root, &Resources{Memory: &Memory{CheckBeforeUpdate: MemoryCheckRefuse}}))
+	// Nothing is checked without a hierarchy.
+	assert.NoError(t, checkFeatures(t.TempDir(), &Resources{CPU: &CPU{Idle: &idle}}))
+}
+
+func TestFeaturesNoHierarchy(t *testing.T) {
+	_, err := Features(t.TempDir())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -29,6 +29,19 @@ const (
 
 type BFQ struct {
 	Weight uint16
+	// WeightDevices override Weight for specific devices.
+	WeightDevices []BFQWeightDevice
+}
+
+// BFQWeightDevice is the io.bfq.weight of a device.
+type BFQWeightDevice struct {
+	Major  int64
+	Minor  int64
+	Weight uint16
+}
+
+func (d BFQWeightDevice) String() string {
+	return fmt.Sprintf("%d:%d %d", d.Major, d.Minor, d.Weight)
 }
 
 type Entry struct {
@@ -54,6 +67,12 @@ func (i *IO) Values() (o []Value) {
 			value:    i.BFQ.Weight,
 		})
 	}
+	for _, d := range i.BFQ.WeightDevices {
+		o = append(o, Value{
+			filename: "io.bfq.weight",
+			value:    d.String(),
+		})
+	}
 	for _, e := range i.Max {
 		o = append(o, Value{
 			filename: "io.max",
@@ -0,0 +1,162 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EffectiveLimit is a limit and the cgroup imposing it.
+type EffectiveLimit struct {
+	// Value is the limit, in bytes for memory or a number of processes.
+	Value uint64
+	// Path is the cgroup imposing the limit, relative to the mountpoint,
+	// e.g. "/system.slice".
+	Path string
+}
+
+// EffectiveCPULimit is a CPU quota and the cgroup imposing it.
+type EffectiveCPULimit struct {
+	// Cores is the quota divided by the period, e.g. 1.5 for 150ms every
+	// 100ms.
+	Cores float64
+	// Path is the cgroup imposing the limit, relative to the mountpoint.
+	Path string
+}
+
+// EffectiveLimits are the tightest limits applying to a cgroup, its own or
+// the ones of its ancestors. A nil limit means no limit.
+type EffectiveLimits struct {
+	Memory *EffectiveLimit
+	// MemoryHigh is the tightest memory.high, above which the cgroup is
+	// throttled rather than OOM-killed.
+	MemoryHigh *EffectiveLimit
+	Swap       *EffectiveLimit
+	CPU        *EffectiveCPULimit
+	Pids       *EffectiveLimit
+	// HugeTlb maps the huge page sizes, e.g. "2MB", to their limits.
+	HugeTlb map[string]EffectiveLimit
+}
+
+// EffectiveLimits returns the limits constraining the cgroup, walking its
+// ancestors up to the mountpoint, which is the root of the cgroup namespace
+// inside a container. Controllers that are not enabled in a cgroup are
+// skipped for that cgroup.
+func (c *Manager) EffectiveLimits() (*EffectiveLimits, error) {
+	var (
+		limits = &EffectiveLimits{}
+		root   = filepath.Clean(c.unifiedMountpoint)
+		sizes  = hugePageSizes()
+	)
+	for path := filepath.Clean(c.path); ; path = filepath.Dir(path) {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			break
+		}
+		group := "/"
+		if rel != "." {
+			group += rel
+		}
+		for _, l := range []struct {
+			file  string
+			limit **EffectiveLimit
+		}{
+			{"memory.max", &limits.Memory},
+			{"memory.high", &limits.MemoryHigh},
+			{"memory.swap.max", &limits.Swap},
+			{"pids.max", &limits.Pids},
+		} {
+			v, err := readLimit(filepath.Join(path, l.file))
+			if err != nil {
+				return nil, err
+			}
+			if v != nil && (*l.limit == nil || *v < (*l.limit).Value) {
+				*l.limit = &EffectiveLimit{Value: *v, Path: group}
+			}
+		}
+		for _, size := range sizes {
+			v, err := readLimit(filepath.Join(path, "hugetlb."+size+".max"))
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				continue
+			}
+			if l, ok := limits.HugeTlb[size]; !ok || *v < l.Value {
+				if limits.HugeTlb == nil {
+					limits.HugeTlb = make(map[string]EffectiveLimit)
+				}
+				limits.HugeTlb[size] = EffectiveLimit{Value: *v, Path: group}
+			}
+		}
+		cores, err := readCPULimit(path)
+		if err != nil {
+			return nil, err
+		}
+		if cores != 0 && (limits.CPU == nil || cores < limits.CPU.Cores) {
+			limits.CPU = &EffectiveCPULimit{Cores: cores, Path: group}
+		}
+		if path == root {
+			break
+		}
+	}
+	return limits, nil
+}
+
+// readLimit reads a limit file, returning nil for "max" or when the file
+// does not exist.
+func readLimit(path string) (*uint64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "max" {
+		return nil, nil
+	}
+	v, err := parseUint(s, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// readCPULimit returns the cpu.max quota of the cgroup at path in cores, or
+// 0 for no quota.
+func readCPULimit(path string) (float64, error) {
+	b, err := os.ReadFile(filepath.Join(path, "cpu.max"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	quota, period, err := CPUMax(strings.TrimSpace(string(b))).extractQuotaAndPeriod()
+	if err != nil {
+		return 0, err
+	}
+	if quota == math.MaxInt64 || quota <= 0 || period == 0 {
+		return 0, nil
+	}
+	return float64(quota) / float64(period), nil
+}
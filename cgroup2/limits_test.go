@@ -0,0 +1,78 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffectiveLimits(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "parent", "child")
+	require.NoError(t, os.MkdirAll(path, 0o755))
+
+	files := map[string]map[string]string{
+		// The root cgroup has no limit files.
+		"parent": {
+			"memory.max":      "2147483648",
+			"memory.high":     "max",
+			"memory.swap.max": "max",
+			"cpu.max":         "150000 100000",
+			"pids.max":        "100",
+		},
+		"parent/child": {
+			"memory.max":      "max",
+			"memory.high":     "1073741824",
+			"memory.swap.max": "1048576",
+			"cpu.max":         "max 100000",
+			"pids.max":        "200",
+		},
+	}
+	sizes := hugePageSizes()
+	if len(sizes) > 0 {
+		files["parent"]["hugetlb."+sizes[0]+".max"] = "4096"
+		files["parent/child"]["hugetlb."+sizes[0]+".max"] = "8192"
+	}
+	for dir, content := range files {
+		for name, value := range content {
+			require.NoError(t, os.WriteFile(filepath.Join(root, dir, name), []byte(value+"\n"), 0o644))
+		}
+	}
+
+	m := &Manager{unifiedMountpoint: root, path: path}
+	limits, err := m.EffectiveLimits()
+	require.NoError(t, err)
+	assert.Equal(t, &EffectiveLimit{Value: 2 << 30, Path: "/parent"}, limits.Memory)
+	assert.Equal(t, &EffectiveLimit{Value: 1 << 30, Path: "/parent/child"}, limits.MemoryHigh)
+	assert.Equal(t, &EffectiveLimit{Value: 1 << 20, Path: "/parent/child"}, limits.Swap)
+	assert.Equal(t, &EffectiveCPULimit{Cores: 1.5, Path: "/parent"}, limits.CPU)
+	assert.Equal(t, &EffectiveLimit{Value: 100, Path: "/parent"}, limits.Pids)
+	if len(sizes) > 0 {
+		assert.Equal(t, map[string]EffectiveLimit{sizes[0]: {Value: 4096, Path: "/parent"}}, limits.HugeTlb)
+	}
+
+	// The root cgroup is not limited.
+	m = &Manager{unifiedMountpoint: root, path: root}
+	limits, err = m.EffectiveLimits()
+	require.NoError(t, err)
+	assert.Equal(t, &EffectiveLimits{}, limits)
+}
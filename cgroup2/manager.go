@@ -22,16 +22,17 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
-	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/containerd/cgroups/v3/cgroup2/stats"
+	"github.com/containerd/cgroups/v3/internal/systemd"
 
+	"github.com/cilium/ebpf"
 	"github.com/containerd/log"
 	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
 	"github.com/godbus/dbus/v5"
@@ -46,6 +47,7 @@ const (
 	typeFile           = "cgroup.type"
 	defaultCgroup2Path = "/sys/fs/cgroup"
 	defaultSlice       = "system.slice"
+	defaultUserSlice   = "user.slice"
 
 	// systemd only supports CPUQuotaPeriodUSec since v2.42.0
 	cpuQuotaPeriodUSecSupportedVersion = 242
@@ -69,9 +71,6 @@ const (
 
 var (
 	canDelegate bool
-
-	versionOnce sync.Once
-	version     int
 )
 
 type Event struct {
@@ -93,6 +92,11 @@ type Resources struct {
 	HugeTlb *HugeTlb
 	// When len(Devices) is zero, devices are not controlled
 	Devices []specs.LinuxDeviceCgroup
+	// Unified maps cgroup interface files, e.g. "memory.oom.group", to the
+	// values written to them after the other resources. The files must
+	// belong to a controller available to the cgroup, or be one of the
+	// cgroup.max.* files, and must not be set by another field.
+	Unified map[string]string
 }
 
 // Values returns the raw filenames and values that
@@ -116,6 +120,12 @@ func (r *Resources) Values() (o []Value) {
 	if r.HugeTlb != nil {
 		o = append(o, r.HugeTlb.Values()...)
 	}
+	for _, file := range sortedKeys(r.Unified) {
+		o = append(o, Value{
+			filename: file,
+			value:    r.Unified[file],
+		})
+	}
 	return o
 }
 
@@ -142,6 +152,11 @@ func (r *Resources) EnabledControllers() (c []string) {
 	if r.HugeTlb != nil {
 		c = append(c, "hugetlb")
 	}
+	for _, file := range sortedKeys(r.Unified) {
+		if controller := unifiedController(file); controller != "cgroup" && !slices.Contains(c, controller) {
+			c = append(c, controller)
+		}
+	}
 	return
 }
 
@@ -194,6 +209,9 @@ func NewManager(mountpoint string, group string, resources *Resources) (*Manager
 	if err := VerifyGroupPath(group); err != nil {
 		return nil, err
 	}
+	if err := checkFeatures(mountpoint, resources); err != nil {
+		return nil, err
+	}
 	path := filepath.Join(mountpoint, group)
 	if err := os.MkdirAll(path, defaultDirPerm); err != nil {
 		return nil, err
@@ -215,7 +233,18 @@ func NewManager(mountpoint string, group string, resources *Resources) (*Manager
 }
 
 type InitConfig struct {
-	mountpoint string
+	mountpoint  string
+	systemdUser bool
+	systemd     *systemd.Conn
+}
+
+// systemdConn returns the connection to the systemd instance selected by the
+// options, shared by the process unless WithSystemdDialer was used.
+func (c *InitConfig) systemdConn() *systemd.Conn {
+	if c.systemd != nil {
+		return c.systemd
+	}
+	return systemd.Default(c.systemdUser)
 }
 
 type InitOpts func(c *InitConfig) error
@@ -228,6 +257,28 @@ func WithMountpoint(path string) InitOpts {
 	}
 }
 
+// WithSystemdUser makes NewSystemd, LoadSystemd and DeleteSystemd talk to the
+// systemd user instance of the calling user instead of the system instance.
+// This is required to create transient units for rootless containers, whose
+// cgroups live under user@<uid>.service.
+func WithSystemdUser() InitOpts {
+	return func(c *InitConfig) error {
+		c.systemdUser = true
+		return nil
+	}
+}
+
+// WithSystemdDialer makes NewSystemd and LoadSystemd connect to systemd with
+// dial instead of the connection shared by the process, e.g. to talk to a
+// fake systemd in tests. The connection is reopened with dial whenever it is
+// closed.
+func WithSystemdDialer(dial func(ctx context.Context) (*systemdDbus.Conn, error)) InitOpts {
+	return func(c *InitConfig) error {
+		c.systemd = systemd.New(dial)
+		return nil
+	}
+}
+
 // Load a cgroup.
 func Load(group string, opts ...InitOpts) (*Manager, error) {
 	c := InitConfig{mountpoint: defaultCgroup2Path}
@@ -250,10 +301,34 @@ func Load(group string, opts ...InitOpts) (*Manager, error) {
 type Manager struct {
 	unifiedMountpoint string
 	path              string
+	// systemdUser is set when the cgroup is a unit of the systemd user instance.
+	systemdUser bool
+	// systemdUnit is the name of the systemd unit owning the cgroup, if the
+	// manager was created by NewSystemd or LoadSystemd. Changes to the cgroup
+	// then go through systemd rather than cgroupfs.
+	systemdUnit string
+	// systemd is the connection to the systemd instance owning systemdUnit.
+	systemd *systemd.Conn
+}
+
+func (c *Manager) systemdConn() *systemd.Conn {
+	if c.systemd != nil {
+		return c.systemd
+	}
+	return systemd.Default(c.systemdUser)
 }
 
 func setResources(path string, resources *Resources) error {
 	if resources != nil {
+		if err := resources.validateUnified(); err != nil {
+			return err
+		}
+		if err := checkMemoryLimit(path, resources.Memory); err != nil {
+			return err
+		}
+		if err := checkUnifiedControllers(path, resources.Unified); err != nil {
+			return err
+		}
 		if err := writeValues(path, resources.Values()); err != nil {
 			return err
 		}
@@ -311,7 +386,17 @@ func (c *Manager) Controllers() ([]string, error) {
 	return strings.Fields(string(b)), nil
 }
 
+// Update applies resources to the cgroup. For systemd managed cgroups, the
+// resources are set as unit properties and only those systemd cannot express
+// are written to cgroupfs. Resources requiring a feature the kernel does not
+// provide fail with ErrNotSupported.
 func (c *Manager) Update(resources *Resources) error {
+	if err := checkFeatures(c.unifiedMountpoint, resources); err != nil {
+		return err
+	}
+	if c.systemdUnit != "" {
+		return c.updateSystemd(resources)
+	}
 	return setResources(c.path, resources)
 }
 
@@ -372,10 +457,14 @@ func (c *Manager) writeSubtreeControl(filePath string, controllers []string, t C
 	return err
 }
 
-func (c *Manager) NewChild(name string, resources *Resources) (*Manager, error) {
+// NewChild creates the cgroup name, relative to c, with the given resources.
+func (c *Manager) NewChild(name string, resources *Resources) (Cgroup, error) {
 	if strings.HasPrefix(name, "/") {
 		return nil, errors.New("name must be relative")
 	}
+	if err := checkFeatures(c.unifiedMountpoint, resources); err != nil {
+		return nil, err
+	}
 	path := filepath.Join(c.path, name)
 	if err := os.MkdirAll(path, defaultDirPerm); err != nil {
 		return nil, err
@@ -420,7 +509,19 @@ func (c *Manager) AddThread(tid uint64) error {
 // this will use the cgroup.kill file, on anything that doesn't have the cgroup.kill
 // file, a manual process of freezing -> sending a SIGKILL to every process -> thawing
 // will be used.
+//
+// For systemd managed cgroups, the unit's processes are killed by systemd.
 func (c *Manager) Kill() error {
+	if c.systemdUnit != "" {
+		err := c.killSystemd()
+		if err == nil {
+			return nil
+		}
+		log.L.Warnf("falling back to killing through cgroupfs: %s", err)
+	}
+	if f, err := Features(c.unifiedMountpoint); err == nil && !f.Kill {
+		return c.fallbackKill()
+	}
 	v := Value{
 		filename: killFile,
 		value:    "1",
@@ -497,7 +598,13 @@ func (c *Manager) fallbackKill() error {
 	return nil
 }
 
+// Delete removes the cgroup, which must not contain any process. For systemd
+// managed cgroups, the unit is stopped instead, which kills all the processes
+// left in it.
 func (c *Manager) Delete() error {
+	if c.systemdUnit != "" {
+		return c.DeleteSystemd()
+	}
 	// Kernel prevents cgroups with running process from being removed,
 	// check the tree is empty.
 	//
@@ -559,7 +666,9 @@ func (c *Manager) Threads(recursive bool) ([]uint64, error) {
 	return c.getTasks(recursive, cgroupThreads)
 }
 
-func (c *Manager) MoveTo(destination *Manager) error {
+// MoveTo moves all the processes of the cgroup and its descendants to
+// destination, which may be any implementation of Cgroup.
+func (c *Manager) MoveTo(destination Cgroup) error {
 	processes, err := c.Procs(true)
 	if err != nil {
 		return err
@@ -749,10 +858,16 @@ func readKVStatsFile(path string, file string, out map[string]uint64) error {
 }
 
 func (c *Manager) Freeze() error {
+	if c.systemdUnit != "" {
+		return c.freezeSystemd(Frozen)
+	}
 	return c.freeze(c.path, Frozen)
 }
 
 func (c *Manager) Thaw() error {
+	if c.systemdUnit != "" {
+		return c.freezeSystemd(Thawed)
+	}
 	return c.freeze(c.path, Thawed)
 }
 
@@ -917,45 +1032,125 @@ func setDevices(path string, devices []specs.LinuxDeviceCgroup) error {
 	return nil
 }
 
+// DeviceFilterProgram returns the device filter attached to the cgroup by
+// this package, possibly from another process, or nil if there is none. It
+// lets a restarted process find the filter it attached before; the next
+// Update replaces it. The caller must close the program.
+func (c *Manager) DeviceFilterProgram() (*ebpf.Program, error) {
+	dirFD, err := unix.Open(c.path, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get dir FD for %s: %w", c.path, err)
+	}
+	defer unix.Close(dirFD)
+	progs, err := attachedDeviceFilters(dirFD)
+	if err != nil || len(progs) == 0 {
+		return nil, err
+	}
+	// Only one filter is left attached once LoadAttachCgroupDeviceFilter
+	// returns.
+	closeAll(progs[1:])
+	return progs[0], nil
+}
+
+// DeviceFilters returns the eBPF device filters attached to the cgroup, and
+// also those inherited from its ancestors when effective is set. An access to
+// a device is allowed when all the effective filters allow it.
+func (c *Manager) DeviceFilters(effective bool) ([]AttachedDeviceFilter, error) {
+	dirFD, err := unix.Open(c.path, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get dir FD for %s: %w", c.path, err)
+	}
+	defer unix.Close(dirFD)
+	return queryDeviceFilters(dirFD, effective)
+}
+
 // getSystemdFullPath returns the full systemd path when creating a systemd slice group.
 // the reason this is necessary is because the "-" character has a special meaning in
 // systemd slice. For example, when creating a slice called "my-group-112233.slice",
 // systemd will create a hierarchy like this:
 //
 //	/sys/fs/cgroup/my.slice/my-group.slice/my-group-112233.slice
-func getSystemdFullPath(slice, group string) string {
-	return filepath.Join(defaultCgroup2Path, dashesToPath(slice), dashesToPath(group))
-}
-
-// dashesToPath converts a slice name with dashes to it's corresponding systemd filesystem path.
-func dashesToPath(in string) string {
-	path := ""
-	if strings.HasSuffix(in, ".slice") && strings.Contains(in, "-") {
-		parts := strings.Split(in, "-")
-		for i := range parts {
-			s := strings.Join(parts[0:i+1], "-")
-			if !strings.HasSuffix(s, ".slice") {
-				s += ".slice"
-			}
-			path = filepath.Join(path, s)
+//
+// root is the cgroup of the systemd instance managing the unit, relative to
+// the mountpoint: "/" for the system instance, or the user@<uid>.service cgroup
+// for a user instance.
+func getSystemdFullPath(mountpoint, root, slice, group string) (string, error) {
+	slicePath, err := expandSlice(slice)
+	if err != nil {
+		return "", err
+	}
+	groupPath, err := expandSlice(group)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(mountpoint, root, slicePath, groupPath), nil
+}
+
+// systemdRoot returns the cgroup managed by the systemd instance, relative to
+// the cgroup2 mountpoint. For the system instance this is always "/". For a
+// user instance, the user manager is asked for its ControlGroup when conn is
+// not nil, falling back to the default location of user@<uid>.service.
+func systemdRoot(conn *systemdDbus.Conn, user bool) string {
+	if !user {
+		return "/"
+	}
+	if conn != nil {
+		cg, err := conn.GetManagerProperty("ControlGroup")
+		if err != nil {
+			log.G(context.TODO()).WithError(err).Debug("Unable to get the cgroup of the systemd user instance")
+		} else if cg = strings.Trim(cg, `"`); cg != "" {
+			return cg
+		} else {
+			log.G(context.TODO()).Debug("The systemd user instance has no cgroup, using the default one")
 		}
-	} else {
-		path = filepath.Join(path, in)
 	}
-	return path
+	uid := os.Getuid()
+	return fmt.Sprintf("/user.slice/user-%d.slice/user@%d.service", uid, uid)
 }
 
-func NewSystemd(slice, group string, pid int, resources *Resources) (*Manager, error) {
-	if slice == "" {
-		slice = defaultSlice
+func defaultSystemdSlice(user bool) string {
+	if user {
+		return defaultUserSlice
 	}
-	ctx := context.TODO()
-	path := getSystemdFullPath(slice, group)
-	conn, err := systemdDbus.NewWithContext(ctx)
-	if err != nil {
+	return defaultSlice
+}
+
+// NewSystemd creates a transient systemd unit named group, whose cgroup is
+// placed under slice, and moves pid into it. A pid of -1 only creates the unit.
+//
+// slice may be nested, e.g. "my-app.slice" is placed under "my.slice"; missing
+// parent slices are created as transient units. "/" or "-.slice" designates
+// the root slice. Unit names containing characters special to systemd should
+// be escaped with EscapeUnitName.
+//
+// By default the unit is created by the systemd system instance, use
+// WithSystemdUser to create it with the user instance instead.
+func NewSystemd(slice, group string, pid int, resources *Resources, opts ...InitOpts) (*Manager, error) {
+	return NewSystemdContext(context.Background(), slice, group, pid, resources, opts...)
+}
+
+// NewSystemdContext is like NewSystemd, ctx bounds the time spent waiting for
+// systemd to start the unit. Without a deadline, it gives up after 30 seconds.
+// A failed job is reported as a *JobError.
+//
+// Resources that cannot be expressed as unit properties are written to the
+// cgroup once the unit has started. If that fails, the returned Manager can be
+// used to delete the unit.
+func NewSystemdContext(ctx context.Context, slice, group string, pid int, resources *Resources, opts ...InitOpts) (*Manager, error) {
+	c := InitConfig{mountpoint: defaultCgroup2Path}
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
+	}
+	parent := sliceUnitName(slice, c.systemdUser)
+	if _, err := expandSlice(parent); err != nil {
 		return &Manager{}, err
 	}
-	defer conn.Close()
+	if _, err := expandSlice(group); err != nil {
+		return &Manager{}, err
+	}
+	sd := c.systemdConn()
 
 	properties := []systemdDbus.Property{
 		systemdDbus.PropDescription("cgroup " + group),
@@ -967,10 +1162,10 @@ func NewSystemd(slice, group string, pid int, resources *Resources) (*Manager, e
 
 	// if we create a slice, the parent is defined via a Wants=
 	if strings.HasSuffix(group, ".slice") {
-		properties = append(properties, systemdDbus.PropWants(defaultSlice))
+		properties = append(properties, systemdDbus.PropWants(parent))
 	} else {
 		// otherwise, we use Slice=
-		properties = append(properties, systemdDbus.PropSlice(defaultSlice))
+		properties = append(properties, systemdDbus.PropSlice(parent))
 	}
 
 	// only add pid if its valid, -1 is used w/ general slice creation.
@@ -978,114 +1173,62 @@ func NewSystemd(slice, group string, pid int, resources *Resources) (*Manager, e
 		properties = append(properties, newSystemdProperty("PIDs", []uint32{uint32(pid)}))
 	}
 
-	if resources.Memory != nil && resources.Memory.Min != nil && *resources.Memory.Min != 0 {
-		properties = append(properties,
-			newSystemdProperty("MemoryMin", uint64(*resources.Memory.Min)))
-	}
-
-	if resources.Memory != nil && resources.Memory.Max != nil && *resources.Memory.Max != 0 {
-		properties = append(properties,
-			newSystemdProperty("MemoryMax", uint64(*resources.Memory.Max)))
-	}
-
-	if resources.CPU != nil && resources.CPU.Weight != nil && *resources.CPU.Weight != 0 {
-		properties = append(properties,
-			newSystemdProperty("CPUWeight", *resources.CPU.Weight))
+	if err := checkFeatures(c.mountpoint, resources); err != nil {
+		return &Manager{}, err
 	}
-
-	if resources.CPU != nil && resources.CPU.Max != "" {
-		quota, period, err := resources.CPU.Max.extractQuotaAndPeriod()
-		if err != nil {
-			return &Manager{}, err
-		}
-
-		if period != 0 {
-			if sdVer := systemdVersion(conn); sdVer >= cpuQuotaPeriodUSecSupportedVersion {
-				properties = append(properties, newSystemdProperty("CPUQuotaPeriodUSec", period))
-			} else {
-				log.G(context.TODO()).WithField("version", sdVer).Debug("Systemd version is too old to support CPUQuotaPeriodUSec")
-			}
-		}
-
-		// cpu.cfs_quota_us and cpu.cfs_period_us are controlled by systemd.
-		// corresponds to USEC_INFINITY in systemd
-		// if USEC_INFINITY is provided, CPUQuota is left unbound by systemd
-		// always setting a property value ensures we can apply a quota and remove it later
-		cpuQuotaPerSecUSec := uint64(math.MaxUint64)
-		if quota > 0 {
-			// systemd converts CPUQuotaPerSecUSec (microseconds per CPU second) to CPUQuota
-			// (integer percentage of CPU) internally.  This means that if a fractional percent of
-			// CPU is indicated by Resources.CpuQuota, we need to round up to the nearest
-			// 10ms (1% of a second) such that child cgroups can set the cpu.cfs_quota_us they expect.
-			cpuQuotaPerSecUSec = uint64(quota*1000000) / period
-			if cpuQuotaPerSecUSec%10000 != 0 {
-				cpuQuotaPerSecUSec = ((cpuQuotaPerSecUSec / 10000) + 1) * 10000
-			}
-		}
-		properties = append(properties,
-			newSystemdProperty("CPUQuotaPerSecUSec", cpuQuotaPerSecUSec))
+	sdVer := sd.Version(ctx)
+	resourceProperties, unmapped, err := SystemdProperties(resources, sdVer)
+	if err != nil {
+		return &Manager{}, err
 	}
+	properties = append(properties, resourceProperties...)
 
 	// If we can delegate, we add the property back in
 	if canDelegate {
 		properties = append(properties, newSystemdProperty("Delegate", true))
 	}
 
-	if resources.Pids != nil && resources.Pids.Max > 0 {
-		properties = append(properties,
-			newSystemdProperty("TasksAccounting", true),
-			newSystemdProperty("TasksMax", uint64(resources.Pids.Max)))
-	}
-
-	if err := startUnit(conn, group, properties, pid == -1); err != nil {
-		return &Manager{}, err
+	ancestors := append(sliceAncestors(parent), parent)
+	if strings.HasSuffix(group, ".slice") {
+		ancestors = append(ancestors, sliceAncestors(group)...)
 	}
-
-	return &Manager{
-		path: path,
-	}, nil
-}
-
-// Adapted from https://github.com/opencontainers/cgroups/blob/9657f5a18b8d60a0f39fbb34d0cb7771e28e6278/systemd/common.go#L245-L281
-func systemdVersion(conn *systemdDbus.Conn) int {
-	versionOnce.Do(func() {
-		version = -1
-		verStr, err := conn.GetManagerProperty("Version")
-		if err == nil {
-			version, err = systemdVersionAtoi(verStr)
+	var path string
+	err = sd.Do(ctx, func(conn *systemdDbus.Conn) error {
+		if err := ensureSlices(ctx, conn, ancestors); err != nil {
+			return err
 		}
-
-		if err != nil {
-			log.G(context.TODO()).WithError(err).Error("Unable to get systemd version")
+		if err := startUnit(ctx, conn, group, properties, pid == -1); err != nil {
+			return err
 		}
+		path, err = unitCgroupPath(ctx, conn, c.mountpoint, parent, group, c.systemdUser)
+		return err
 	})
-
-	return version
-}
-
-func systemdVersionAtoi(str string) (int, error) {
-	// Unconditionally remove the leading prefix ("v).
-	str = strings.TrimLeft(str, `"v`)
-	// Match on the first integer we can grab.
-	for i := range len(str) {
-		if str[i] < '0' || str[i] > '9' {
-			// First non-digit: cut the tail.
-			str = str[:i]
-			break
-		}
-	}
-	ver, err := strconv.Atoi(str)
 	if err != nil {
-		return -1, fmt.Errorf("can't parse version: %w", err)
+		return &Manager{}, err
+	}
+	m := &Manager{
+		unifiedMountpoint: c.mountpoint,
+		path:              path,
+		systemdUser:       c.systemdUser,
+		systemdUnit:       group,
+		systemd:           sd,
 	}
-	return ver, nil
+	// Resources systemd cannot express are written to cgroupfs once the unit
+	// has started and its cgroup exists.
+	if err := setUnmappedResources(path, resources, unmapped); err != nil {
+		return m, fmt.Errorf("failed to set resources not supported by systemd %d: %w", sdVer, err)
+	}
+	return m, nil
 }
 
-func startUnit(conn *systemdDbus.Conn, group string, properties []systemdDbus.Property, ignoreExists bool) error {
-	ctx := context.TODO()
+// defaultSystemdJobTimeout bounds the time spent waiting for a systemd job
+// when the context has no deadline.
+const defaultSystemdJobTimeout = 30 * time.Second
 
+func startUnit(ctx context.Context, conn *systemdDbus.Conn, group string, properties []systemdDbus.Property, ignoreExists bool) error {
+	// The channel is never closed: go-systemd may still send the job result
+	// after we stopped waiting for it.
 	statusChan := make(chan string, 1)
-	defer close(statusChan)
 
 	retry := true
 	started := false
@@ -1104,7 +1247,7 @@ func startUnit(conn *systemdDbus.Conn, group string, properties []systemdDbus.Pr
 				retry = false
 				// When a unit of the same name already exists, it may be a leftover failed unit.
 				// If we reset it once, systemd can try to remove it.
-				attemptFailedUnitReset(conn, group)
+				attemptFailedUnitReset(ctx, conn, group)
 				continue
 			}
 
@@ -1114,23 +1257,33 @@ func startUnit(conn *systemdDbus.Conn, group string, properties []systemdDbus.Pr
 		}
 	}
 
-	systemdStartUnitTimeout := 30 * time.Second
+	if err := waitJob(ctx, statusChan, "start", group); err != nil {
+		attemptFailedUnitReset(context.WithoutCancel(ctx), conn, group)
+		return err
+	}
+	return nil
+}
+
+// waitJob waits for the result of a systemd job, reporting results other
+// than "done" as a *JobError.
+func waitJob(ctx context.Context, statusChan <-chan string, op, unit string) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSystemdJobTimeout)
+		defer cancel()
+	}
 	select {
 	case s := <-statusChan:
 		if s != "done" {
-			attemptFailedUnitReset(conn, group)
-			return fmt.Errorf("error creating systemd unit `%s`: got `%s`", group, s)
+			return &JobError{Unit: unit, Op: op, Result: s}
 		}
-	case <-time.After(systemdStartUnitTimeout):
-		attemptFailedUnitReset(conn, group)
-		return fmt.Errorf("timed out while waiting for StartTransientUnit(%s) completion signal from dbus after %v", group, systemdStartUnitTimeout)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out while waiting for the %s job of unit %s: %w", op, unit, ctx.Err())
 	}
-
-	return nil
 }
 
-func attemptFailedUnitReset(conn *systemdDbus.Conn, group string) {
-	ctx := context.TODO()
+func attemptFailedUnitReset(ctx context.Context, conn *systemdDbus.Conn, group string) {
 	err := conn.ResetFailedUnitContext(ctx, group)
 
 	if err != nil {
@@ -1138,31 +1291,69 @@ func attemptFailedUnitReset(conn *systemdDbus.Conn, group string) {
 	}
 }
 
-func LoadSystemd(slice, group string) (*Manager, error) {
-	if slice == "" {
-		slice = defaultSlice
-	}
-	path := getSystemdFullPath(slice, group)
-	return &Manager{
-		path: path,
-	}, nil
+// LoadSystemd loads the cgroup of an existing systemd unit. WithSystemdUser
+// must be passed for units of the systemd user instance.
+//
+// The cgroup path is the ControlGroup reported by systemd for the unit. If
+// systemd cannot be reached or the unit is not active, the path is derived
+// from slice and group instead.
+func LoadSystemd(slice, group string, opts ...InitOpts) (*Manager, error) {
+	return LoadSystemdContext(context.Background(), slice, group, opts...)
 }
 
-func (c *Manager) DeleteSystemd() error {
-	ctx := context.TODO()
-	conn, err := systemdDbus.NewWithContext(ctx)
-	if err != nil {
-		return err
+// LoadSystemdContext is like LoadSystemd, ctx is used for the D-Bus calls.
+func LoadSystemdContext(ctx context.Context, slice, group string, opts ...InitOpts) (*Manager, error) {
+	c := InitConfig{mountpoint: defaultCgroup2Path}
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
 	}
-	defer conn.Close()
-	group := systemdUnitFromPath(c.path)
-	ch := make(chan string)
-	_, err = conn.StopUnitContext(ctx, group, "replace", ch)
+	sd := c.systemdConn()
+	parent := sliceUnitName(slice, c.systemdUser)
+	path, err := getSystemdFullPath(c.mountpoint, systemdRoot(nil, c.systemdUser), parent, group)
 	if err != nil {
+		return nil, err
+	}
+	err = sd.Do(ctx, func(conn *systemdDbus.Conn) error {
+		p, err := unitCgroupPath(ctx, conn, c.mountpoint, parent, group, c.systemdUser)
+		if err == nil {
+			path = p
+		}
 		return err
+	})
+	if err != nil {
+		log.G(ctx).WithError(err).Debug("Unable to get the unit cgroup from systemd, guessing it")
 	}
-	<-ch
-	return nil
+	return &Manager{
+		unifiedMountpoint: c.mountpoint,
+		path:              path,
+		systemdUser:       c.systemdUser,
+		systemdUnit:       group,
+		systemd:           sd,
+	}, nil
+}
+
+// DeleteSystemd stops the systemd unit of the cgroup.
+func (c *Manager) DeleteSystemd() error {
+	return c.DeleteSystemdContext(context.Background())
+}
+
+// DeleteSystemdContext stops the systemd unit of the cgroup, ctx bounds the
+// time spent waiting for systemd to stop it. Without a deadline, it gives up
+// after 30 seconds. A failed job is reported as a *JobError.
+func (c *Manager) DeleteSystemdContext(ctx context.Context) error {
+	group := c.systemdUnit
+	if group == "" {
+		group = systemdUnitFromPath(c.path)
+	}
+	return c.systemdConn().Do(ctx, func(conn *systemdDbus.Conn) error {
+		statusChan := make(chan string, 1)
+		if _, err := conn.StopUnitContext(ctx, group, "replace", statusChan); err != nil {
+			return err
+		}
+		return waitJob(ctx, statusChan, "stop", group)
+	})
 }
 
 func newSystemdProperty(name string, units interface{}) systemdDbus.Property {
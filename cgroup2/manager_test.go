@@ -230,14 +230,36 @@ func TestSystemdFullPath(t *testing.T) {
 			inputGroup:  "my.service",
 			expectedOut: "/sys/fs/cgroup/test.slice/test-waldo.slice/my.service",
 		},
+		{
+			inputSlice:  "system.slice",
+			inputGroup:  "foo@bar.service",
+			expectedOut: "/sys/fs/cgroup/system.slice/foo@bar.service",
+		},
 	}
 
 	for _, test := range tests {
-		actual := getSystemdFullPath(test.inputSlice, test.inputGroup)
+		actual, err := getSystemdFullPath(defaultCgroup2Path, "/", test.inputSlice, test.inputGroup)
+		require.NoError(t, err)
 		assert.Equal(t, test.expectedOut, actual)
 	}
 }
 
+func TestSystemdUserFullPath(t *testing.T) {
+	root := systemdRoot(nil, true)
+	uid := os.Getuid()
+	assert.Equal(t, fmt.Sprintf("/user.slice/user-%d.slice/user@%d.service", uid, uid), root)
+	assert.Equal(t, "/", systemdRoot(nil, false))
+
+	actual, err := getSystemdFullPath(defaultCgroup2Path, root, defaultUserSlice, "my-container.scope")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("/sys/fs/cgroup/user.slice/user-%d.slice/user@%d.service/user.slice/my-container.scope", uid, uid), actual)
+
+	m, err := LoadSystemd("", "my-container.scope", WithSystemdUser())
+	require.NoError(t, err)
+	assert.Equal(t, actual, m.path)
+	assert.True(t, m.systemdUser)
+}
+
 func TestKill(t *testing.T) {
 	checkCgroupMode(t)
 	manager, err := NewManager(defaultCgroup2Path, "/test1", ToResources(&specs.LinuxResources{}))
@@ -16,6 +16,18 @@
 
 package cgroup2
 
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/cgroups/v3/internal/memcheck"
+
+	"golang.org/x/sys/unix"
+)
+
 type Memory struct {
 	Swap     *int64
 	Min      *int64
@@ -23,6 +35,71 @@ type Memory struct {
 	Low      *int64
 	High     *int64
 	OOMGroup *bool
+	// CheckBeforeUpdate selects what Update does when Max is lower than the
+	// current memory usage. It is not written to the cgroup.
+	CheckBeforeUpdate MemoryCheck
+}
+
+// MemoryCheck is the check made before lowering memory.max, which makes the
+// kernel OOM-kill processes of the cgroup when it cannot reclaim enough
+// memory. It is the same type as cgroup1.MemoryCheck.
+type MemoryCheck = memcheck.Mode
+
+const (
+	// MemoryCheckNone writes memory.max without checking the usage.
+	MemoryCheckNone = memcheck.None
+	// MemoryCheckRefuse fails with a *MemoryLimitError when the usage is
+	// above the new limit.
+	MemoryCheckRefuse = memcheck.Refuse
+	// MemoryCheckReclaim reclaims the memory above the new limit with
+	// memory.reclaim first, and fails with a *MemoryLimitError if the usage
+	// is still above it.
+	MemoryCheckReclaim = memcheck.Reclaim
+)
+
+// checkMemoryLimit checks the usage of the cgroup at path against the new
+// memory.max, as selected by mem.CheckBeforeUpdate.
+func checkMemoryLimit(path string, mem *Memory) error {
+	if mem == nil || mem.CheckBeforeUpdate == MemoryCheckNone || mem.Max == nil || *mem.Max < 0 {
+		return nil
+	}
+	limit := uint64(*mem.Max)
+	usage, err := readMemoryCurrent(path)
+	if err != nil {
+		return err
+	}
+	if usage <= limit {
+		return nil
+	}
+	initial := usage
+	if mem.CheckBeforeUpdate == MemoryCheckReclaim {
+		for i := 0; i < memcheck.ReclaimAttempts && usage > limit; i++ {
+			err := os.WriteFile(filepath.Join(path, "memory.reclaim"), []byte(strconv.FormatUint(usage-limit, 10)), defaultFilePerm)
+			// EAGAIN is returned when less memory than requested was reclaimed.
+			if err != nil && !errors.Is(err, unix.EAGAIN) {
+				return err
+			}
+			if usage, err = readMemoryCurrent(path); err != nil {
+				return err
+			}
+		}
+		if usage <= limit {
+			return nil
+		}
+	}
+	e := &MemoryLimitError{Usage: usage, Limit: limit}
+	if initial > usage {
+		e.Reclaimed = initial - usage
+	}
+	return e
+}
+
+func readMemoryCurrent(path string) (uint64, error) {
+	b, err := os.ReadFile(filepath.Join(path, "memory.current"))
+	if err != nil {
+		return 0, err
+	}
+	return parseUint(strings.TrimSpace(string(b)), 10, 64)
 }
 
 func (r *Memory) Values() (o []Value) {
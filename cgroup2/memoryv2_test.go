@@ -19,6 +19,7 @@ package cgroup2
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -84,3 +85,30 @@ func TestSystemdCgroupMemoryController(t *testing.T) {
 	checkFileContent(t, c.path, "memory.min", "16384")
 	checkFileContent(t, c.path, "memory.max", "629145600")
 }
+
+func TestCheckMemoryLimit(t *testing.T) {
+	path := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(path, "memory.current"), []byte("2000\n"), 0o644))
+
+	mem := &Memory{Max: pointerInt64(1000), CheckBeforeUpdate: MemoryCheckRefuse}
+	err := setResources(path, &Resources{Memory: mem})
+	var e *MemoryLimitError
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, &MemoryLimitError{Usage: 2000, Limit: 1000}, e)
+	assert.NoFileExists(t, filepath.Join(path, "memory.max"))
+
+	// memory.current does not go down when writing to a regular file.
+	mem.CheckBeforeUpdate = MemoryCheckReclaim
+	err = setResources(path, &Resources{Memory: mem})
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, uint64(0), e.Reclaimed)
+	checkFileContent(t, path, "memory.reclaim", "1000")
+
+	mem.Max = pointerInt64(4000)
+	require.NoError(t, setResources(path, &Resources{Memory: mem}))
+	checkFileContent(t, path, "memory.max", "4000")
+
+	// Without the check, the limit is written as is.
+	require.NoError(t, setResources(path, &Resources{Memory: &Memory{Max: pointerInt64(1000)}}))
+	checkFileContent(t, path, "memory.max", "1000")
+}
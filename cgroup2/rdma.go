@@ -18,12 +18,17 @@ package cgroup2
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 )
 
 type RDMA struct {
 	Limit []RDMAEntry
 }
 
+// RDMAMax is the RDMAEntry limit written as "max".
+const RDMAMax = math.MaxUint32
+
 type RDMAEntry struct {
 	Device     string
 	HcaHandles uint32
@@ -31,7 +36,14 @@ type RDMAEntry struct {
 }
 
 func (r RDMAEntry) String() string {
-	return fmt.Sprintf("%s hca_handle=%d hca_object=%d", r.Device, r.HcaHandles, r.HcaObjects)
+	return fmt.Sprintf("%s hca_handle=%s hca_object=%s", r.Device, rdmaLimit(r.HcaHandles), rdmaLimit(r.HcaObjects))
+}
+
+func rdmaLimit(v uint32) string {
+	if v == RDMAMax {
+		return "max"
+	}
+	return strconv.FormatUint(uint64(v), 10)
 }
 
 func (r *RDMA) Values() (o []Value) {
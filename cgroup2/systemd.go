@@ -0,0 +1,842 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/log"
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// Minimum systemd versions supporting the resource control properties below.
+// MemoryMin, MemoryMax, CPUWeight, CPUQuotaPerSecUSec and TasksMax have always
+// been set unconditionally and are not gated.
+// https://www.freedesktop.org/software/systemd/man/latest/systemd.resource-control.html
+const (
+	memoryHighSupportedVersion    = 231
+	memorySwapMaxSupportedVersion = 232
+	memoryLowSupportedVersion     = 233
+	allowedCPUsSupportedVersion   = 244
+	freezeUnitSupportedVersion    = 246
+)
+
+// devicesResource is the name used in UnmappedResource for device rules,
+// which are not backed by a cgroup interface file.
+const devicesResource = "devices"
+
+// rootSlice is the unit name of the root slice.
+const rootSlice = "-.slice"
+
+// procDevices is parsed to resolve the names used by DeviceAllow=char-<name>.
+var procDevices = "/proc/devices"
+
+// UnmappedResource describes a resource that could not be expressed as a
+// systemd unit property.
+type UnmappedResource struct {
+	// Name is the cgroup interface file the resource is written to,
+	// e.g. "memory.oom.group", or "devices" for device rules.
+	Name string
+	// Reason explains why the resource could not be mapped.
+	Reason string
+}
+
+func (u UnmappedResource) String() string {
+	return u.Name + ": " + u.Reason
+}
+
+type systemdPropertyBuilder struct {
+	version    int
+	properties []systemdDbus.Property
+	unmapped   []UnmappedResource
+}
+
+func (b *systemdPropertyBuilder) add(name string, value interface{}) {
+	b.properties = append(b.properties, newSystemdProperty(name, value))
+}
+
+func (b *systemdPropertyBuilder) unmap(name, format string, args ...interface{}) {
+	b.unmapped = append(b.unmapped, UnmappedResource{
+		Name:   name,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+// addVersioned adds the property if the systemd version supports it, and
+// reports file as unmapped otherwise.
+func (b *systemdPropertyBuilder) addVersioned(minVersion int, file, name string, value interface{}) {
+	if b.version < minVersion {
+		b.unmap(file, "%s requires systemd >= %d, got %d", name, minVersion, b.version)
+		return
+	}
+	b.add(name, value)
+}
+
+// SystemdProperties translates resources into the equivalent systemd unit
+// properties, e.g. Memory.High into MemoryHigh or IO.Max into
+// IOReadBandwidthMax. Properties not supported by systemdVersion are left out.
+//
+// Resources that systemd cannot express (hugetlb and rdma limits, memory.oom.group,
+// some device rules, ...) or that require a newer systemd are returned as
+// unmapped, so that callers can apply them directly to the cgroup filesystem.
+func SystemdProperties(resources *Resources, systemdVersion int) ([]systemdDbus.Property, []UnmappedResource, error) {
+	if resources == nil {
+		return nil, nil, nil
+	}
+	b := &systemdPropertyBuilder{version: systemdVersion}
+	b.memory(resources.Memory)
+	if err := b.cpu(resources.CPU); err != nil {
+		return nil, nil, err
+	}
+	b.pids(resources.Pids)
+	b.io(resources.IO)
+	if err := b.devices(resources.Devices); err != nil {
+		return nil, nil, err
+	}
+	if resources.HugeTlb != nil {
+		for _, v := range resources.HugeTlb.Values() {
+			b.unmap(v.filename, "hugetlb limits are not supported by systemd")
+		}
+	}
+	if resources.RDMA != nil && len(resources.RDMA.Limit) > 0 {
+		b.unmap("rdma.max", "rdma limits are not supported by systemd")
+	}
+	if err := resources.validateUnified(); err != nil {
+		return nil, nil, err
+	}
+	for _, file := range sortedKeys(resources.Unified) {
+		b.unmap(file, "unified resources are not supported by systemd")
+	}
+	return b.properties, b.unmapped, nil
+}
+
+// memoryLimit converts a cgroup memory value into a systemd one, negative
+// values meaning "max" (infinity).
+func memoryLimit(v int64) uint64 {
+	if v < 0 {
+		return math.MaxUint64
+	}
+	return uint64(v)
+}
+
+func (b *systemdPropertyBuilder) memory(mem *Memory) {
+	if mem == nil {
+		return
+	}
+	if mem.Min != nil && *mem.Min != 0 {
+		b.add("MemoryMin", memoryLimit(*mem.Min))
+	}
+	if mem.Low != nil {
+		b.addVersioned(memoryLowSupportedVersion, "memory.low", "MemoryLow", memoryLimit(*mem.Low))
+	}
+	if mem.High != nil {
+		b.addVersioned(memoryHighSupportedVersion, "memory.high", "MemoryHigh", memoryLimit(*mem.High))
+	}
+	if mem.Max != nil && *mem.Max != 0 {
+		b.add("MemoryMax", memoryLimit(*mem.Max))
+	}
+	if mem.Swap != nil {
+		b.addVersioned(memorySwapMaxSupportedVersion, "memory.swap.max", "MemorySwapMax", memoryLimit(*mem.Swap))
+	}
+	if mem.OOMGroup != nil {
+		b.unmap("memory.oom.group", "memory.oom.group is not supported by systemd for transient units")
+	}
+}
+
+func (b *systemdPropertyBuilder) cpu(cpu *CPU) error {
+	if cpu == nil {
+		return nil
+	}
+	if cpu.Weight != nil && *cpu.Weight != 0 {
+		b.add("CPUWeight", *cpu.Weight)
+	}
+	if cpu.Max != "" {
+		quota, period, err := cpu.Max.extractQuotaAndPeriod()
+		if err != nil {
+			return err
+		}
+
+		if period != 0 {
+			b.addVersioned(cpuQuotaPeriodUSecSupportedVersion, "cpu.max", "CPUQuotaPeriodUSec", period)
+		}
+
+		// cpu.cfs_quota_us and cpu.cfs_period_us are controlled by systemd.
+		// corresponds to USEC_INFINITY in systemd
+		// if USEC_INFINITY is provided, CPUQuota is left unbound by systemd
+		// always setting a property value ensures we can apply a quota and remove it later
+		cpuQuotaPerSecUSec := uint64(math.MaxUint64)
+		if quota > 0 && quota != math.MaxInt64 {
+			// systemd converts CPUQuotaPerSecUSec (microseconds per CPU second) to CPUQuota
+			// (integer percentage of CPU) internally.  This means that if a fractional percent of
+			// CPU is indicated by Resources.CpuQuota, we need to round up to the nearest
+			// 10ms (1% of a second) such that child cgroups can set the cpu.cfs_quota_us they expect.
+			cpuQuotaPerSecUSec = uint64(quota*1000000) / period
+			if cpuQuotaPerSecUSec%10000 != 0 {
+				cpuQuotaPerSecUSec = ((cpuQuotaPerSecUSec / 10000) + 1) * 10000
+			}
+		}
+		b.add("CPUQuotaPerSecUSec", cpuQuotaPerSecUSec)
+	}
+	if cpu.Burst != nil {
+		b.unmap("cpu.max.burst", "cpu.max.burst is not supported by systemd")
+	}
+	if cpu.Idle != nil {
+		b.unmap("cpu.idle", "cpu.idle is not supported by systemd")
+	}
+	if cpu.Cpus != "" {
+		bits, err := cpusetToBits(cpu.Cpus)
+		if err != nil {
+			return fmt.Errorf("invalid cpuset.cpus %q: %w", cpu.Cpus, err)
+		}
+		b.addVersioned(allowedCPUsSupportedVersion, "cpuset.cpus", "AllowedCPUs", bits)
+	}
+	if cpu.Mems != "" {
+		bits, err := cpusetToBits(cpu.Mems)
+		if err != nil {
+			return fmt.Errorf("invalid cpuset.mems %q: %w", cpu.Mems, err)
+		}
+		b.addVersioned(allowedCPUsSupportedVersion, "cpuset.mems", "AllowedMemoryNodes", bits)
+	}
+	return nil
+}
+
+func (b *systemdPropertyBuilder) pids(pids *Pids) {
+	if pids == nil || pids.Max == 0 {
+		return
+	}
+	limit := uint64(math.MaxUint64)
+	if pids.Max > 0 {
+		limit = uint64(pids.Max)
+	}
+	b.add("TasksAccounting", true)
+	b.add("TasksMax", limit)
+}
+
+// bfqToIOWeight converts an io.bfq.weight value (1-1000, default 100) into
+// the IOWeight (1-10000, default 100) systemd scales back to it.
+func bfqToIOWeight(weight uint16) uint64 {
+	if weight <= 100 {
+		return uint64(weight)
+	}
+	return 100 + (uint64(weight)-100)*(10000-100)/(1000-100)
+}
+
+// ioDeviceLimit is the D-Bus representation, a(st), of the IO*Max properties.
+type ioDeviceLimit struct {
+	Path  string
+	Limit uint64
+}
+
+func (b *systemdPropertyBuilder) io(io *IO) {
+	if io == nil {
+		return
+	}
+	if io.BFQ.Weight != 0 {
+		b.add("IOWeight", bfqToIOWeight(io.BFQ.Weight))
+	}
+	if len(io.BFQ.WeightDevices) > 0 {
+		b.unmap("io.bfq.weight", "per device bfq weights are not supported by systemd")
+	}
+	limits := map[IOType][]ioDeviceLimit{}
+	for _, e := range io.Max {
+		limits[e.Type] = append(limits[e.Type], ioDeviceLimit{
+			// systemd resolves the device node to its major:minor.
+			Path:  fmt.Sprintf("/dev/block/%d:%d", e.Major, e.Minor),
+			Limit: e.Rate,
+		})
+	}
+	for _, t := range []struct {
+		typ  IOType
+		name string
+	}{
+		{ReadBPS, "IOReadBandwidthMax"},
+		{WriteBPS, "IOWriteBandwidthMax"},
+		{ReadIOPS, "IOReadIOPSMax"},
+		{WriteIOPS, "IOWriteIOPSMax"},
+	} {
+		if l, ok := limits[t.typ]; ok {
+			b.add(t.name, l)
+		}
+	}
+}
+
+// deviceAllow is the D-Bus representation, a(ss), of the DeviceAllow property.
+type deviceAllow struct {
+	Path   string
+	Access string
+}
+
+// devices translates OCI device rules into DevicePolicy=strict and a list of
+// DeviceAllow entries. systemd only supports allow-lists, so rule sets that do
+// not start with a "deny all" rule, or that deny devices after having allowed
+// others, cannot be expressed and are reported as unmapped.
+func (b *systemdPropertyBuilder) devices(devices []specs.LinuxDeviceCgroup) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	if !isDenyAll(devices[0]) {
+		b.unmap(devicesResource, "the device rules must start with a rule denying all devices")
+		return nil
+	}
+	var (
+		allow []deviceAllow
+		names map[string]map[int64]string
+	)
+	for _, d := range devices[1:] {
+		if !d.Allow {
+			b.unmap(devicesResource, "deny rules following allow rules are not supported by systemd")
+			return nil
+		}
+		if d.Type != "c" && d.Type != "b" {
+			if isAllowAll(d) {
+				// Everything is allowed, there is nothing to restrict.
+				b.add("DevicePolicy", "auto")
+				b.add("DeviceAllow", []deviceAllow{})
+				return nil
+			}
+			b.unmap(devicesResource, "device rules of type %q are not supported by systemd", d.Type)
+			return nil
+		}
+		prefix := "char"
+		if d.Type == "b" {
+			prefix = "block"
+		}
+		access := d.Access
+		if access == "" {
+			access = "rwm"
+		}
+		switch {
+		case d.Major == nil || *d.Major < 0:
+			// char-* and block-* match every device of the given type.
+			allow = append(allow, deviceAllow{Path: prefix + "-*", Access: access})
+		case d.Minor == nil || *d.Minor < 0:
+			if names == nil {
+				var err error
+				if names, err = readProcDevices(); err != nil {
+					return err
+				}
+			}
+			name, ok := names[prefix][*d.Major]
+			if !ok {
+				b.unmap(devicesResource, "no %s device with major %d found in %s", prefix, *d.Major, procDevices)
+				return nil
+			}
+			allow = append(allow, deviceAllow{Path: prefix + "-" + name, Access: access})
+		default:
+			allow = append(allow, deviceAllow{
+				Path:   fmt.Sprintf("/dev/%s/%d:%d", prefix, *d.Major, *d.Minor),
+				Access: access,
+			})
+		}
+	}
+	b.add("DevicePolicy", "strict")
+	// An empty list resets the devices previously allowed on the unit.
+	b.add("DeviceAllow", []deviceAllow{})
+	b.add("DeviceAllow", allow)
+	return nil
+}
+
+func isWildcardDevice(d specs.LinuxDeviceCgroup) bool {
+	return (d.Type == "" || d.Type == "a") &&
+		(d.Major == nil || *d.Major < 0) &&
+		(d.Minor == nil || *d.Minor < 0) &&
+		(d.Access == "" || isRWM(d.Access))
+}
+
+func isDenyAll(d specs.LinuxDeviceCgroup) bool {
+	return !d.Allow && isWildcardDevice(d)
+}
+
+func isAllowAll(d specs.LinuxDeviceCgroup) bool {
+	return d.Allow && isWildcardDevice(d)
+}
+
+// readProcDevices parses /proc/devices into a map of device type ("char" or
+// "block") to major numbers and driver names.
+func readProcDevices() (map[string]map[int64]string, error) {
+	f, err := os.Open(procDevices)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		out     = map[string]map[int64]string{"char": {}, "block": {}}
+		current map[int64]string
+		s       = bufio.NewScanner(f)
+	)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch line {
+		case "":
+			continue
+		case "Character devices:":
+			current = out["char"]
+			continue
+		case "Block devices:":
+			current = out["block"]
+			continue
+		}
+		fields := strings.Fields(line)
+		if current == nil || len(fields) != 2 {
+			continue
+		}
+		major, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		current[major] = fields[1]
+	}
+	return out, s.Err()
+}
+
+// cpusetToBits converts a cpuset list such as "0-3,7" into the little-endian
+// bitmask used by the AllowedCPUs and AllowedMemoryNodes properties.
+func cpusetToBits(list string) ([]byte, error) {
+	var bits []byte
+	for _, r := range strings.Split(list, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		start, end, isRange := strings.Cut(r, "-")
+		first, err := strconv.ParseUint(start, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.ParseUint(end, 10, 16); err != nil {
+				return nil, err
+			}
+		}
+		if last < first {
+			return nil, fmt.Errorf("invalid range %q", r)
+		}
+		for i := first; i <= last; i++ {
+			for uint64(len(bits)) <= i/8 {
+				bits = append(bits, 0)
+			}
+			bits[i/8] |= 1 << (i % 8)
+		}
+	}
+	if len(bits) == 0 {
+		return nil, ErrInvalidFormat
+	}
+	return bits, nil
+}
+
+// updateSystemd sets resources as properties of the systemd unit, so that
+// they survive a daemon-reload, and writes the ones systemd cannot express
+// to cgroupfs.
+func (c *Manager) updateSystemd(resources *Resources) error {
+	if resources == nil {
+		return nil
+	}
+	if err := checkMemoryLimit(c.path, resources.Memory); err != nil {
+		return err
+	}
+	ctx := context.TODO()
+	sd := c.systemdConn()
+	properties, unmapped, err := SystemdProperties(resources, sd.Version(ctx))
+	if err != nil {
+		return err
+	}
+	if len(properties) > 0 {
+		err := sd.Do(ctx, func(conn *systemdDbus.Conn) error {
+			return conn.SetUnitPropertiesContext(ctx, c.systemdUnit, true, properties...)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return setUnmappedResources(c.path, resources, unmapped)
+}
+
+// setUnmappedResources writes the resources listed in unmapped to cgroupfs.
+func setUnmappedResources(path string, resources *Resources, unmapped []UnmappedResource) error {
+	if len(unmapped) == 0 {
+		return nil
+	}
+	files := make(map[string]bool, len(unmapped))
+	for _, u := range unmapped {
+		files[u.Name] = true
+	}
+	if err := checkUnifiedControllers(path, resources.Unified); err != nil {
+		return err
+	}
+	var values []Value
+	for _, v := range resources.Values() {
+		if files[v.filename] {
+			values = append(values, v)
+		}
+	}
+	if err := writeValues(path, values); err != nil {
+		return err
+	}
+	if files[devicesResource] {
+		return setDevices(path, resources.Devices)
+	}
+	return nil
+}
+
+// freezeSystemd freezes or thaws the unit with FreezeUnit and ThawUnit,
+// falling back to cgroupfs for systemd versions that do not support them.
+func (c *Manager) freezeSystemd(state State) error {
+	ctx := context.TODO()
+	sd := c.systemdConn()
+	if sdVer := sd.Version(ctx); sdVer >= 0 && sdVer < freezeUnitSupportedVersion {
+		return c.freeze(c.path, state)
+	}
+	err := sd.Do(ctx, func(conn *systemdDbus.Conn) error {
+		if state == Frozen {
+			return conn.FreezeUnit(ctx, c.systemdUnit)
+		}
+		return conn.ThawUnit(ctx, c.systemdUnit)
+	})
+	if isUnknownMethod(err) {
+		return c.freeze(c.path, state)
+	}
+	return err
+}
+
+// killSystemd sends SIGKILL to all the processes of the unit.
+func (c *Manager) killSystemd() error {
+	ctx := context.TODO()
+	return c.systemdConn().Do(ctx, func(conn *systemdDbus.Conn) error {
+		return conn.KillUnitWithTarget(ctx, c.systemdUnit, systemdDbus.All, int32(unix.SIGKILL))
+	})
+}
+
+// EscapeUnitName escapes s so that it can be used as (part of) a systemd unit
+// name, the same way `systemd-escape` does: "/" is replaced with "-", and "-"
+// as well as any character other than ASCII letters, digits, ":", "_" and "."
+// are replaced with their C-style "\xNN" escape. A leading "." is escaped too.
+func EscapeUnitName(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '/':
+			b.WriteByte('-')
+		case c == '.' && i == 0,
+			!(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == ':' || c == '_' || c == '.'):
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// validUnitName reports whether name only contains characters allowed in
+// systemd unit names.
+func validUnitName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(":-_.\\@", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// sliceUnitName returns the unit name of the slice argument of NewSystemd and
+// LoadSystemd, which defaults to system.slice (user.slice for the systemd
+// user instance). "/" designates the root slice.
+func sliceUnitName(slice string, user bool) string {
+	switch slice {
+	case "":
+		return defaultSystemdSlice(user)
+	case "/":
+		return rootSlice
+	}
+	return slice
+}
+
+// expandSlice converts a unit name into its cgroup path relative to the
+// cgroup of the systemd instance. Slices are nested according to the dashes
+// in their name, e.g. "a-b-c.slice" expands to "a.slice/a-b.slice/a-b-c.slice",
+// other units are returned as is.
+//
+// Adapted from ExpandSlice in https://github.com/opencontainers/cgroups/blob/main/systemd/common.go
+func expandSlice(name string) (string, error) {
+	if name == rootSlice || name == "/" {
+		return "", nil
+	}
+	if !validUnitName(name) {
+		return "", fmt.Errorf("invalid systemd unit name %q", name)
+	}
+	sliceName, ok := strings.CutSuffix(name, ".slice")
+	if !ok {
+		return name, nil
+	}
+	if sliceName == "" || strings.HasPrefix(sliceName, "-") || strings.HasSuffix(sliceName, "-") || strings.Contains(sliceName, "--") {
+		return "", fmt.Errorf("invalid slice name %q", name)
+	}
+	var path, prefix string
+	for _, component := range strings.Split(sliceName, "-") {
+		path = filepath.Join(path, prefix+component+".slice")
+		prefix += component + "-"
+	}
+	return path, nil
+}
+
+// sliceAncestors returns the slices name is nested in, from the outermost to
+// the innermost one, excluding the root slice. It returns nothing for units
+// other than slices.
+func sliceAncestors(name string) []string {
+	sliceName, ok := strings.CutSuffix(name, ".slice")
+	if !ok || name == rootSlice {
+		return nil
+	}
+	var ancestors []string
+	for i := 0; i < len(sliceName); i++ {
+		if sliceName[i] == '-' {
+			ancestors = append(ancestors, sliceName[:i]+".slice")
+		}
+	}
+	return ancestors
+}
+
+// parentSlice returns the slice the slice name is nested in.
+func parentSlice(name string) string {
+	if ancestors := sliceAncestors(name); len(ancestors) > 0 {
+		return ancestors[len(ancestors)-1]
+	}
+	return rootSlice
+}
+
+// ensureSlices creates the given slices as transient units, in order, unless
+// systemd already has them loaded.
+func ensureSlices(ctx context.Context, conn *systemdDbus.Conn, slices []string) error {
+	for _, slice := range slices {
+		if slice == rootSlice {
+			continue
+		}
+		// Loaded slices, including those defined by unit files, are
+		// started by systemd as a dependency of the unit.
+		prop, err := conn.GetUnitPropertyContext(ctx, slice, "LoadState")
+		if err == nil && prop.Value.Value() == "loaded" {
+			continue
+		}
+		properties := []systemdDbus.Property{
+			systemdDbus.PropDescription("slice " + slice),
+			newSystemdProperty("DefaultDependencies", false),
+			systemdDbus.PropWants(parentSlice(slice)),
+		}
+		if err := startUnit(ctx, conn, slice, properties, true); err != nil {
+			return fmt.Errorf("creating slice %s: %w", slice, err)
+		}
+	}
+	return nil
+}
+
+// unitCgroupPath returns the cgroup of the unit group as reported by systemd,
+// falling back to the path derived from slice and group when the unit has no
+// cgroup, e.g. because it is not active.
+func unitCgroupPath(ctx context.Context, conn *systemdDbus.Conn, mountpoint, slice, group string, user bool) (string, error) {
+	// ControlGroup is a property of the unit type, e.g. Scope, not of Unit.
+	prop, err := conn.GetUnitTypePropertyContext(ctx, group, unitType(group), "ControlGroup")
+	if err == nil {
+		if cg, ok := prop.Value.Value().(string); ok && cg != "" {
+			return filepath.Join(mountpoint, cg), nil
+		}
+	} else {
+		log.G(ctx).WithError(err).WithField("unit", group).Debug("Unable to get the cgroup of the unit")
+	}
+	return getSystemdFullPath(mountpoint, systemdRoot(conn, user), slice, group)
+}
+
+// unitType returns the D-Bus interface name of the type of unit, e.g. "Scope"
+// for "foo.scope".
+func unitType(unit string) string {
+	i := strings.LastIndexByte(unit, '.')
+	if i < 0 || i == len(unit)-1 {
+		return ""
+	}
+	return strings.ToUpper(unit[i+1:i+2]) + unit[i+2:]
+}
+
+// UnitEvent is a state change of the systemd unit of a cgroup.
+type UnitEvent struct {
+	// Unit is the name of the unit.
+	Unit string
+	// ActiveState is the high-level state of the unit, e.g. "active",
+	// "deactivating", "inactive" or "failed".
+	ActiveState string
+	// SubState is the unit type specific state, e.g. "running", "exited" or
+	// "abandoned".
+	SubState string
+	// Result is the result of the last run of a service or scope, e.g.
+	// "success", "exit-code" or "oom-kill". It is empty for other units.
+	Result string
+	// ExecMainStatus is the exit status of the main process of a service.
+	ExecMainStatus int32
+}
+
+// OOMKilled reports whether the unit was stopped by the OOM killer.
+func (e UnitEvent) OOMKilled() bool {
+	return e.Result == "oom-kill"
+}
+
+// SubscribeUnit streams the state changes of the systemd unit of the cgroup,
+// starting with its current state, until ctx is canceled. It relies on the
+// PropertiesChanged D-Bus signals emitted by systemd.
+//
+// Both channels are closed when the subscription ends, after an error has been
+// sent on the error channel if it ended for another reason than ctx.
+func (c *Manager) SubscribeUnit(ctx context.Context) (<-chan UnitEvent, <-chan error) {
+	ec := make(chan UnitEvent)
+	errCh := make(chan error, 1)
+	fail := func(err error) (<-chan UnitEvent, <-chan error) {
+		errCh <- err
+		close(ec)
+		close(errCh)
+		return ec, errCh
+	}
+	if c.systemdUnit == "" {
+		return fail(fmt.Errorf("cgroups: %q is not managed by systemd", c.path))
+	}
+	// Subscriptions are per connection, use a dedicated one.
+	conn, err := c.systemdConn().Dial(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	// Set the subscriber before subscribing so that no update sent right
+	// after the subscription is dropped.
+	updates := make(chan *systemdDbus.PropertiesUpdate, 64)
+	subErrs := make(chan error, 1)
+	conn.SetPropertiesSubscriber(updates, subErrs)
+	if err := conn.Subscribe(); err != nil {
+		conn.Close()
+		return fail(err)
+	}
+
+	go func() {
+		defer func() {
+			conn.Close()
+			close(ec)
+			close(errCh)
+		}()
+		send := func(changed map[string]interface{}) bool {
+			ev, err := c.unitEvent(ctx, conn, changed)
+			if err != nil {
+				if ctx.Err() == nil {
+					errCh <- err
+				}
+				return false
+			}
+			select {
+			case ec <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		if !send(nil) {
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case u := <-updates:
+				if u.UnitName != c.systemdUnit {
+					continue
+				}
+				_, active := u.Changed["ActiveState"]
+				_, sub := u.Changed["SubState"]
+				if !active && !sub {
+					continue
+				}
+				changed := make(map[string]interface{}, len(u.Changed))
+				for k, v := range u.Changed {
+					changed[k] = v.Value()
+				}
+				if !send(changed) {
+					return
+				}
+			case err := <-subErrs:
+				// Updates were dropped because the channel was full, keep going.
+				log.G(ctx).WithError(err).WithField("unit", c.systemdUnit).Warn("Missed systemd unit updates")
+			}
+		}
+	}()
+	return ec, errCh
+}
+
+// unitEvent builds the current UnitEvent of the unit, querying systemd for
+// the properties missing from changed.
+func (c *Manager) unitEvent(ctx context.Context, conn *systemdDbus.Conn, changed map[string]interface{}) (UnitEvent, error) {
+	props := make(map[string]interface{}, 4)
+	for k, v := range changed {
+		props[k] = v
+	}
+	for _, name := range []string{"ActiveState", "SubState"} {
+		if _, ok := props[name]; ok {
+			continue
+		}
+		p, err := conn.GetUnitPropertyContext(ctx, c.systemdUnit, name)
+		if err != nil {
+			return UnitEvent{}, err
+		}
+		props[name] = p.Value.Value()
+	}
+	unitType, typeProps := unitTypeProperties(c.systemdUnit)
+	for _, name := range typeProps {
+		p, err := conn.GetUnitTypePropertyContext(ctx, c.systemdUnit, unitType, name)
+		if err != nil {
+			return UnitEvent{}, err
+		}
+		props[name] = p.Value.Value()
+	}
+	return newUnitEvent(c.systemdUnit, props), nil
+}
+
+// unitTypeProperties returns the D-Bus interface name of the type of unit and
+// the properties of that interface reported in UnitEvent.
+func unitTypeProperties(unit string) (string, []string) {
+	switch {
+	case strings.HasSuffix(unit, ".service"):
+		return "Service", []string{"Result", "ExecMainStatus"}
+	case strings.HasSuffix(unit, ".scope"):
+		return "Scope", []string{"Result"}
+	}
+	return "", nil
+}
+
+func newUnitEvent(unit string, props map[string]interface{}) UnitEvent {
+	ev := UnitEvent{Unit: unit}
+	ev.ActiveState, _ = props["ActiveState"].(string)
+	ev.SubState, _ = props["SubState"].(string)
+	ev.Result, _ = props["Result"].(string)
+	ev.ExecMainStatus, _ = props["ExecMainStatus"].(int32)
+	return ev
+}
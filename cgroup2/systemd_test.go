@@ -0,0 +1,479 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/cgroups/v3/internal/systemd/systemdtest"
+
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func propertyMap(props []systemdDbus.Property) map[string]interface{} {
+	m := make(map[string]interface{}, len(props))
+	for _, p := range props {
+		m[p.Name] = p.Value.Value()
+	}
+	return m
+}
+
+func unmappedNames(unmapped []UnmappedResource) []string {
+	var names []string
+	for _, u := range unmapped {
+		names = append(names, u.Name)
+	}
+	return names
+}
+
+func TestSystemdProperties(t *testing.T) {
+	props, unmapped, err := SystemdProperties(&Resources{
+		Memory: &Memory{
+			Min:      pointerInt64(1024),
+			Low:      pointerInt64(2048),
+			High:     pointerInt64(4096),
+			Max:      pointerInt64(-1),
+			Swap:     pointerInt64(0),
+			OOMGroup: toPtr(true),
+		},
+		CPU: &CPU{
+			Weight: toPtr(uint64(200)),
+			Max:    NewCPUMax(pointerInt64(50000), toPtr(uint64(100000))),
+			Cpus:   "0-2,9",
+			Mems:   "0",
+		},
+		Pids: &Pids{Max: -1},
+		IO: &IO{
+			BFQ: BFQ{Weight: 500},
+			Max: []Entry{
+				{Type: ReadBPS, Major: 8, Minor: 0, Rate: 1000},
+				{Type: WriteIOPS, Major: 8, Minor: 16, Rate: 10},
+			},
+		},
+		HugeTlb: &HugeTlb{{HugePageSize: "2MB", Limit: 1 << 21}},
+	}, 250)
+	require.NoError(t, err)
+
+	m := propertyMap(props)
+	assert.Equal(t, uint64(1024), m["MemoryMin"])
+	assert.Equal(t, uint64(2048), m["MemoryLow"])
+	assert.Equal(t, uint64(4096), m["MemoryHigh"])
+	assert.Equal(t, uint64(math.MaxUint64), m["MemoryMax"])
+	assert.Equal(t, uint64(0), m["MemorySwapMax"])
+	assert.Equal(t, uint64(200), m["CPUWeight"])
+	assert.Equal(t, uint64(500000), m["CPUQuotaPerSecUSec"])
+	assert.Equal(t, uint64(100000), m["CPUQuotaPeriodUSec"])
+	assert.Equal(t, []byte{0x07, 0x02}, m["AllowedCPUs"])
+	assert.Equal(t, []byte{0x01}, m["AllowedMemoryNodes"])
+	assert.Equal(t, true, m["TasksAccounting"])
+	assert.Equal(t, uint64(math.MaxUint64), m["TasksMax"])
+	assert.Equal(t, uint64(4500), m["IOWeight"])
+	assert.Equal(t, []ioDeviceLimit{{Path: "/dev/block/8:0", Limit: 1000}}, m["IOReadBandwidthMax"])
+	assert.Equal(t, []ioDeviceLimit{{Path: "/dev/block/8:16", Limit: 10}}, m["IOWriteIOPSMax"])
+	assert.NotContains(t, m, "IOWriteBandwidthMax")
+
+	assert.ElementsMatch(t, []string{"memory.oom.group", "hugetlb.2MB.max"}, unmappedNames(unmapped))
+}
+
+func TestSystemdPropertiesVersionGating(t *testing.T) {
+	props, unmapped, err := SystemdProperties(&Resources{
+		Memory: &Memory{
+			Max:  pointerInt64(1 << 30),
+			High: pointerInt64(1 << 29),
+			Low:  pointerInt64(1 << 28),
+		},
+		CPU: &CPU{
+			Max:  NewCPUMax(pointerInt64(50000), toPtr(uint64(100000))),
+			Cpus: "0",
+		},
+	}, 232)
+	require.NoError(t, err)
+
+	m := propertyMap(props)
+	assert.Contains(t, m, "MemoryMax")
+	assert.Contains(t, m, "MemoryHigh")
+	assert.Contains(t, m, "CPUQuotaPerSecUSec")
+	assert.NotContains(t, m, "MemoryLow")
+	assert.NotContains(t, m, "CPUQuotaPeriodUSec")
+	assert.NotContains(t, m, "AllowedCPUs")
+	assert.ElementsMatch(t, []string{"memory.low", "cpu.max", "cpuset.cpus"}, unmappedNames(unmapped))
+}
+
+func TestSystemdPropertiesCPUMaxUnlimited(t *testing.T) {
+	props, _, err := SystemdProperties(&Resources{
+		CPU: &CPU{Max: NewCPUMax(nil, toPtr(uint64(100000)))},
+	}, 250)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), propertyMap(props)["CPUQuotaPerSecUSec"])
+}
+
+func TestSystemdPropertiesDevices(t *testing.T) {
+	devices := filepath.Join(t.TempDir(), "devices")
+	require.NoError(t, os.WriteFile(devices, []byte("Character devices:\n  1 mem\n136 pts\n\nBlock devices:\n  8 sd\n"), 0o644))
+	defer func(old string) { procDevices = old }(procDevices)
+	procDevices = devices
+
+	props, unmapped, err := SystemdProperties(&Resources{
+		Devices: []specs.LinuxDeviceCgroup{
+			{Allow: false, Access: "rwm"},
+			{Allow: true, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(3), Access: "rwm"},
+			{Allow: true, Type: "c", Major: pointerInt64(136), Access: "rw"},
+			{Allow: true, Type: "b", Access: "m"},
+		},
+	}, 250)
+	require.NoError(t, err)
+	assert.Empty(t, unmapped)
+
+	m := propertyMap(props)
+	assert.Equal(t, "strict", m["DevicePolicy"])
+	assert.Equal(t, []deviceAllow{
+		{Path: "/dev/char/1:3", Access: "rwm"},
+		{Path: "char-pts", Access: "rw"},
+		{Path: "block-*", Access: "m"},
+	}, m["DeviceAllow"])
+
+	// Deny rules after allow rules cannot be expressed by systemd.
+	props, unmapped, err = SystemdProperties(&Resources{
+		Devices: []specs.LinuxDeviceCgroup{
+			{Allow: false, Access: "rwm"},
+			{Allow: true, Type: "c", Access: "rwm"},
+			{Allow: false, Type: "c", Major: pointerInt64(1), Minor: pointerInt64(3), Access: "rwm"},
+		},
+	}, 250)
+	require.NoError(t, err)
+	assert.Empty(t, props)
+	assert.Equal(t, []string{devicesResource}, unmappedNames(unmapped))
+}
+
+func TestCpusetToBits(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []byte
+	}{
+		{"0", []byte{0x01}},
+		{"0-7", []byte{0xff}},
+		{"1,3", []byte{0x0a}},
+		{"8", []byte{0x00, 0x01}},
+		{"0-1, 10-11", []byte{0x03, 0x0c}},
+	} {
+		got, err := cpusetToBits(tc.in)
+		require.NoError(t, err, tc.in)
+		assert.Equal(t, tc.want, got, tc.in)
+	}
+	for _, in := range []string{"", "a", "3-1", "1-"} {
+		_, err := cpusetToBits(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestSetUnmappedResources(t *testing.T) {
+	path := t.TempDir()
+	for _, name := range []string{"memory.oom.group", "memory.max", "hugetlb.2MB.max"} {
+		require.NoError(t, os.WriteFile(filepath.Join(path, name), nil, 0o644))
+	}
+	resources := &Resources{
+		Memory: &Memory{
+			Max:      pointerInt64(1 << 30),
+			OOMGroup: toPtr(true),
+		},
+		HugeTlb: &HugeTlb{{HugePageSize: "2MB", Limit: 1 << 21}},
+	}
+	_, unmapped, err := SystemdProperties(resources, 250)
+	require.NoError(t, err)
+	require.NoError(t, setUnmappedResources(path, resources, unmapped))
+
+	for name, want := range map[string]string{
+		"memory.oom.group": "1",
+		"hugetlb.2MB.max":  "2097152",
+		// memory.max is set through systemd and must not be written.
+		"memory.max": "",
+	} {
+		got, err := os.ReadFile(filepath.Join(path, name))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got), name)
+	}
+}
+
+func TestEscapeUnitName(t *testing.T) {
+	for in, want := range map[string]string{
+		"simple":           "simple",
+		"foo/bar-baz":      `foo-bar\x2dbaz`,
+		".hidden":          `\x2ehidden`,
+		"with space":       `with\x20space`,
+		"a.b:c_d":          "a.b:c_d",
+		"back\\slash":      `back\x5cslash`,
+		"container-abc123": `container\x2dabc123`,
+	} {
+		assert.Equal(t, want, EscapeUnitName(in), in)
+	}
+}
+
+func TestExpandSlice(t *testing.T) {
+	for in, want := range map[string]string{
+		"-.slice":                        "",
+		"/":                              "",
+		"system.slice":                   "system.slice",
+		"a-b-c.slice":                    "a.slice/a-b.slice/a-b-c.slice",
+		`kubepods-pod\x2d1.slice`:        `kubepods.slice/kubepods-pod\x2d1.slice`,
+		"my-container.scope":             "my-container.scope",
+		EscapeUnitName("a/b") + ".scope": "a-b.scope",
+	} {
+		got, err := expandSlice(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"", "-a.slice", "a-.slice", "a--b.slice", ".slice", "a/b.slice", "with space.scope"} {
+		_, err := expandSlice(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestSliceAncestors(t *testing.T) {
+	assert.Equal(t, []string{"a.slice", "a-b.slice"}, sliceAncestors("a-b-c.slice"))
+	assert.Empty(t, sliceAncestors("system.slice"))
+	assert.Empty(t, sliceAncestors("-.slice"))
+	assert.Empty(t, sliceAncestors("my-container.scope"))
+
+	assert.Equal(t, "a-b.slice", parentSlice("a-b-c.slice"))
+	assert.Equal(t, "-.slice", parentSlice("system.slice"))
+
+	assert.Equal(t, "system.slice", sliceUnitName("", false))
+	assert.Equal(t, "user.slice", sliceUnitName("", true))
+	assert.Equal(t, "-.slice", sliceUnitName("/", false))
+	assert.Equal(t, "my-app.slice", sliceUnitName("my-app.slice", false))
+}
+
+func TestJobError(t *testing.T) {
+	for result, target := range map[string]error{
+		"canceled":   ErrJobCanceled,
+		"timeout":    ErrJobTimeout,
+		"failed":     ErrJobFailed,
+		"dependency": ErrJobDependency,
+		"skipped":    ErrJobSkipped,
+	} {
+		var err error = &JobError{Unit: "test.scope", Op: "start", Result: result}
+		assert.ErrorIs(t, err, target, result)
+		if target != ErrJobFailed {
+			assert.NotErrorIs(t, err, ErrJobFailed, result)
+		}
+		var jobErr *JobError
+		require.True(t, errors.As(err, &jobErr))
+		assert.Equal(t, result, jobErr.Result)
+	}
+}
+
+func TestWaitJob(t *testing.T) {
+	ch := make(chan string, 1)
+	ch <- "done"
+	assert.NoError(t, waitJob(context.Background(), ch, "start", "test.scope"))
+
+	ch <- "dependency"
+	err := waitJob(context.Background(), ch, "start", "test.scope")
+	assert.ErrorIs(t, err, ErrJobDependency)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = waitJob(ctx, ch, "stop", "test.scope")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestUnitEvent(t *testing.T) {
+	unitType, props := unitTypeProperties("test.service")
+	assert.Equal(t, "Service", unitType)
+	assert.Equal(t, []string{"Result", "ExecMainStatus"}, props)
+	unitType, props = unitTypeProperties("test.scope")
+	assert.Equal(t, "Scope", unitType)
+	assert.Equal(t, []string{"Result"}, props)
+	_, props = unitTypeProperties("test.slice")
+	assert.Empty(t, props)
+
+	ev := newUnitEvent("test.service", map[string]interface{}{
+		"ActiveState":    "failed",
+		"SubState":       "failed",
+		"Result":         "oom-kill",
+		"ExecMainStatus": int32(137),
+	})
+	assert.Equal(t, UnitEvent{
+		Unit:           "test.service",
+		ActiveState:    "failed",
+		SubState:       "failed",
+		Result:         "oom-kill",
+		ExecMainStatus: 137,
+	}, ev)
+	assert.True(t, ev.OOMKilled())
+}
+
+func TestSubscribeUnitNotSystemd(t *testing.T) {
+	m := &Manager{path: "/sys/fs/cgroup/test"}
+	ec, errCh := m.SubscribeUnit(context.Background())
+	assert.Error(t, <-errCh)
+	_, ok := <-ec
+	assert.False(t, ok)
+}
+
+func newFakeSystemd(t *testing.T) (*systemdtest.Server, string) {
+	t.Helper()
+	root := t.TempDir()
+	srv, err := systemdtest.NewServer(t.TempDir(), root)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return srv, root
+}
+
+func TestNewSystemdFake(t *testing.T) {
+	srv, root := newFakeSystemd(t)
+	opts := []InitOpts{WithMountpoint(root), WithSystemdDialer(srv.Dial)}
+	max := int64(10)
+	m, err := NewSystemd("my-app.slice", "test.scope", os.Getpid(), &Resources{Pids: &Pids{Max: max}}, opts...)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "my.slice/my-app.slice/test.scope"), m.path)
+
+	for _, slice := range []string{"my.slice", "my-app.slice"} {
+		_, ok := srv.Unit(slice)
+		assert.True(t, ok, slice)
+	}
+	u, ok := srv.Unit("test.scope")
+	require.True(t, ok)
+	assert.Equal(t, "my-app.slice", u.Properties["Slice"].Value())
+	assert.Equal(t, uint64(max), u.Properties["TasksMax"].Value())
+	assert.Equal(t, []uint32{uint32(os.Getpid())}, u.Properties["PIDs"].Value())
+
+	loaded, err := LoadSystemd("my-app.slice", "test.scope", opts...)
+	require.NoError(t, err)
+	assert.Equal(t, m.path, loaded.path)
+
+	require.NoError(t, m.Delete())
+	_, ok = srv.Unit("test.scope")
+	assert.False(t, ok)
+	assert.NoDirExists(t, m.path)
+}
+
+func TestNewSystemdLoadedSlicesFake(t *testing.T) {
+	srv, root := newFakeSystemd(t)
+	opts := []InitOpts{WithMountpoint(root), WithSystemdDialer(srv.Dial)}
+	_, err := NewSystemd("my-app.slice", "a.scope", -1, nil, opts...)
+	require.NoError(t, err)
+	assert.Equal(t, 3, srv.Calls("StartTransientUnit"))
+
+	// The slices are loaded now, only the scope is started.
+	_, err = NewSystemd("my-app.slice", "b.scope", -1, nil, opts...)
+	require.NoError(t, err)
+	assert.Equal(t, 4, srv.Calls("StartTransientUnit"))
+}
+
+func TestLoadSystemdNoDBus(t *testing.T) {
+	root := t.TempDir()
+	dial := func(context.Context) (*systemdDbus.Conn, error) {
+		return nil, errors.New("no systemd")
+	}
+	m, err := LoadSystemd("my-app.slice", "test.scope", WithMountpoint(root), WithSystemdDialer(dial))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "my.slice/my-app.slice/test.scope"), m.path)
+}
+
+func TestSystemdUpdateFake(t *testing.T) {
+	srv, root := newFakeSystemd(t)
+	m, err := NewSystemd("", "test.scope", -1, nil, WithMountpoint(root), WithSystemdDialer(srv.Dial))
+	require.NoError(t, err)
+
+	require.NoError(t, m.Update(nil))
+	oomGroup := true
+	require.NoError(t, m.Update(&Resources{
+		Pids:   &Pids{Max: 20},
+		Memory: &Memory{OOMGroup: &oomGroup},
+	}))
+	u, _ := srv.Unit("test.scope")
+	assert.Equal(t, uint64(20), u.Properties["TasksMax"].Value())
+	// systemd has no property for memory.oom.group, it is written directly.
+	oom, err := os.ReadFile(filepath.Join(m.path, "memory.oom.group"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(oom))
+}
+
+func TestNewSystemdUnmappedFake(t *testing.T) {
+	srv, root := newFakeSystemd(t)
+	oomGroup := true
+	m, err := NewSystemd("", "test.scope", -1, &Resources{
+		Memory: &Memory{OOMGroup: &oomGroup},
+	}, WithMountpoint(root), WithSystemdDialer(srv.Dial))
+	require.NoError(t, err)
+	// memory.oom.group is written once the unit has started.
+	oom, err := os.ReadFile(filepath.Join(m.path, "memory.oom.group"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(oom))
+}
+
+func TestSystemdFreezeKillFake(t *testing.T) {
+	srv, root := newFakeSystemd(t)
+	m, err := NewSystemd("", "test.scope", -1, nil, WithMountpoint(root), WithSystemdDialer(srv.Dial))
+	require.NoError(t, err)
+
+	require.NoError(t, m.Freeze())
+	state, err := os.ReadFile(filepath.Join(m.path, "cgroup.freeze"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(state))
+	require.NoError(t, m.Thaw())
+	state, err = os.ReadFile(filepath.Join(m.path, "cgroup.freeze"))
+	require.NoError(t, err)
+	assert.Equal(t, "0", string(state))
+
+	require.NoError(t, m.Kill())
+}
+
+func TestNewSystemdJobFailedFake(t *testing.T) {
+	srv, root := newFakeSystemd(t)
+	srv.SetJobResult("test.scope", "failed")
+	_, err := NewSystemd("", "test.scope", -1, nil, WithMountpoint(root), WithSystemdDialer(srv.Dial))
+	assert.ErrorIs(t, err, ErrJobFailed)
+	var jobErr *JobError
+	require.ErrorAs(t, err, &jobErr)
+	assert.Equal(t, "test.scope", jobErr.Unit)
+	// The failed unit is reset so that it can be created again.
+	_, ok := srv.Unit("test.scope")
+	assert.False(t, ok)
+}
+
+func TestSubscribeUnitFake(t *testing.T) {
+	srv, root := newFakeSystemd(t)
+	m, err := NewSystemd("", "test.scope", -1, nil, WithMountpoint(root), WithSystemdDialer(srv.Dial))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ec, errCh := m.SubscribeUnit(ctx)
+	ev := <-ec
+	assert.Equal(t, UnitEvent{Unit: "test.scope", ActiveState: "active", SubState: "running", Result: "success"}, ev)
+
+	require.NoError(t, srv.SetUnitState("test.scope", "failed", "failed", "oom-kill"))
+	ev = <-ec
+	assert.Equal(t, "failed", ev.ActiveState)
+	assert.True(t, ev.OOMKilled())
+
+	cancel()
+	for range ec {
+	}
+	assert.NoError(t, <-errCh)
+}
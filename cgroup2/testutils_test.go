@@ -23,7 +23,7 @@ import (
 	"strings"
 	"testing"
 
-	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/containerd/cgroups/v3/internal/systemd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sys/unix"
@@ -41,11 +41,9 @@ func checkCgroupMode(tb testing.TB) {
 }
 
 func requireSystemdVersion(tb testing.TB, requiredMinVersion int) {
-	conn, err := systemdDbus.NewWithContext(context.TODO())
-	require.NoError(tb, err, "failed to connect to systemd")
-	defer conn.Close()
-
-	if sdVer := systemdVersion(conn); sdVer < requiredMinVersion {
+	sdVer := systemd.System().Version(context.TODO())
+	require.NotEqual(tb, -1, sdVer, "failed to get the systemd version")
+	if sdVer < requiredMinVersion {
 		tb.Skipf("Skipping test; systemd version %d < required version %d", sdVer, requiredMinVersion)
 	}
 }
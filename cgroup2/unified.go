@@ -0,0 +1,111 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// unifiedCgroupFiles are the cgroup.* files that can be set with
+// Resources.Unified. The others are either read-only or managed through
+// dedicated methods, e.g. cgroup.freeze with Freeze.
+var unifiedCgroupFiles = map[string]bool{
+	"cgroup.max.depth":       true,
+	"cgroup.max.descendants": true,
+}
+
+// unifiedAliases maps the files setting the same value as a file written by
+// a typed resource to the latter.
+var unifiedAliases = map[string]string{
+	"cpu.weight.nice": "cpu.weight",
+}
+
+// unifiedController returns the controller of a cgroup interface file, e.g.
+// "memory" for "memory.oom.group".
+func unifiedController(file string) string {
+	controller, _, _ := strings.Cut(file, ".")
+	return controller
+}
+
+// validateUnified checks the Unified file names and that they are not set by
+// another field of the resources.
+func (r *Resources) validateUnified() error {
+	if len(r.Unified) == 0 {
+		return nil
+	}
+	typed := *r
+	typed.Unified = nil
+	set := make(map[string]bool)
+	for _, v := range typed.Values() {
+		set[v.filename] = true
+	}
+
+	var errs []error
+	for _, file := range sortedKeys(r.Unified) {
+		controller, name, ok := strings.Cut(file, ".")
+		switch {
+		case !ok || controller == "" || name == "" || filepath.Base(file) != file:
+			errs = append(errs, fmt.Errorf("%w: %q is not a cgroup interface file", ErrInvalidUnified, file))
+		case controller == "cgroup" && !unifiedCgroupFiles[file]:
+			errs = append(errs, fmt.Errorf("%w: %q cannot be set", ErrInvalidUnified, file))
+		case set[file]:
+			errs = append(errs, fmt.Errorf("%w: %q is also set by a typed resource", ErrInvalidUnified, file))
+		case set[unifiedAliases[file]]:
+			errs = append(errs, fmt.Errorf("%w: %q conflicts with %q set by a typed resource", ErrInvalidUnified, file, unifiedAliases[file]))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkUnifiedControllers checks that the controllers of the unified files
+// are available in the cgroup at path.
+func checkUnifiedControllers(path string, unified map[string]string) error {
+	if len(unified) == 0 {
+		return nil
+	}
+	b, err := os.ReadFile(filepath.Join(path, controllersFile))
+	if err != nil {
+		return err
+	}
+	available := strings.Fields(string(b))
+	var errs []error
+	for _, file := range sortedKeys(unified) {
+		controller := unifiedController(file)
+		if controller == "cgroup" {
+			continue
+		}
+		if !slices.Contains(available, controller) {
+			errs = append(errs, fmt.Errorf("%w: %q: controller %q is not available in %s", ErrInvalidUnified, file, controller, path))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
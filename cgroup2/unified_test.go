@@ -0,0 +1,87 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateUnified(t *testing.T) {
+	for _, file := range []string{
+		"memory.oom.group",
+		"cgroup.max.depth",
+		"misc.max",
+	} {
+		r := &Resources{Unified: map[string]string{file: "1"}}
+		assert.NoError(t, r.validateUnified(), file)
+	}
+	for _, file := range []string{
+		"",
+		"memory",
+		".max",
+		"..",
+		"../memory.max",
+		"memory.max/..",
+		"cgroup.procs",
+		"cgroup.kill",
+		// Set by the typed resources below.
+		"memory.max",
+		"cpu.weight.nice",
+	} {
+		r := &Resources{
+			CPU:     &CPU{Weight: toPtr(uint64(100))},
+			Memory:  &Memory{Max: pointerInt64(1 << 30)},
+			Unified: map[string]string{file: "1"},
+		}
+		assert.ErrorIs(t, r.validateUnified(), ErrInvalidUnified, file)
+	}
+}
+
+func TestUnifiedValues(t *testing.T) {
+	r := &Resources{
+		Memory: &Memory{Max: pointerInt64(1 << 30)},
+		Unified: map[string]string{
+			"memory.oom.group": "1",
+			"cpu.idle":         "1",
+			"cgroup.max.depth": "2",
+		},
+	}
+	assert.Equal(t, []string{"memory", "cpu"}, r.EnabledControllers())
+
+	path := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(path, controllersFile), []byte("cpu memory\n"), 0o644))
+	require.NoError(t, setResources(path, r))
+	for name, want := range map[string]string{
+		"memory.max":       "1073741824",
+		"memory.oom.group": "1",
+		"cpu.idle":         "1",
+		"cgroup.max.depth": "2",
+	} {
+		got, err := os.ReadFile(filepath.Join(path, name))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got), name)
+	}
+
+	r.Unified = map[string]string{"pids.max": "10"}
+	assert.ErrorIs(t, setResources(path, r), ErrInvalidUnified)
+	assert.NoFileExists(t, filepath.Join(path, "pids.max"))
+}
@@ -180,6 +180,9 @@ func ConvertCPUSharesToCgroupV2Value(cpuShares uint64) uint64 {
 //
 // converting cgroups configuration from v1 to v2
 // ref: https://github.com/containers/crun/blob/master/crun.1.md#cgroup-v2
+//
+// Resources without a v2 equivalent are silently dropped, see
+// ConvertResources for a conversion reporting them.
 func ToResources(spec *specs.LinuxResources) *Resources {
 	var resources Resources
 	if cpu := spec.CPU; cpu != nil {
@@ -438,6 +441,16 @@ func isUnitExists(err error) bool {
 	return false
 }
 
+// isUnknownMethod returns true if the error is that the D-Bus method is not
+// implemented, e.g. by a systemd too old to support it.
+func isUnknownMethod(err error) bool {
+	var dbusError dbus.Error
+	if errors.As(err, &dbusError) {
+		return dbusError.Name == "org.freedesktop.DBus.Error.UnknownMethod"
+	}
+	return false
+}
+
 func systemdUnitFromPath(path string) string {
 	_, unit := filepath.Split(path)
 	return unit
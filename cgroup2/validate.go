@@ -0,0 +1,243 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// sysDevBlock lists the block devices by major:minor.
+var sysDevBlock = "/sys/dev/block"
+
+const (
+	cpuWeightMin = 1
+	cpuWeightMax = 10000
+	// Bounds of the cpu.max period and the minimum quota, in microseconds.
+	cpuPeriodMin = 1000
+	cpuPeriodMax = 1000000
+	cpuQuotaMin  = 1000
+	bfqWeightMin = 1
+	bfqWeightMax = 1000
+)
+
+// Validate checks the resources against the host and the cgroup managed by
+// m before they are applied, instead of leaving the kernel to reject them
+// with a bare EINVAL or ENOENT. All the problems found are returned, joined
+// with errors.Join.
+//
+// It checks that:
+//   - the controllers are available in the parent cgroup;
+//   - the huge page sizes are supported by the host;
+//   - the cpus and mems are within the effective cpuset of the parent;
+//   - the IO devices exist;
+//   - weights, periods and quotas are within the kernel ranges;
+//   - memory.min <= memory.low <= memory.high <= memory.max;
+//   - the Unified files are valid, see Resources.Unified.
+func (r *Resources) Validate(m *Manager) error {
+	parent := m.path
+	if filepath.Clean(m.path) != filepath.Clean(m.unifiedMountpoint) {
+		parent = filepath.Dir(m.path)
+	}
+	var errs []error
+	errs = append(errs, r.validateControllers(parent)...)
+	if r.CPU != nil {
+		errs = append(errs, r.CPU.validate(parent)...)
+	}
+	if r.Memory != nil {
+		errs = append(errs, r.Memory.validate()...)
+	}
+	if r.IO != nil {
+		errs = append(errs, r.IO.validate()...)
+	}
+	if r.HugeTlb != nil {
+		sizes := hugePageSizes()
+		for _, e := range *r.HugeTlb {
+			if !slices.Contains(sizes, e.HugePageSize) {
+				errs = append(errs, fmt.Errorf("hugetlb: page size %q is not supported by the host (supported: %s)", e.HugePageSize, strings.Join(sizes, ", ")))
+			}
+		}
+	}
+	if err := r.validateUnified(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Resources) validateControllers(parent string) []error {
+	controllers := r.EnabledControllers()
+	if len(controllers) == 0 {
+		return nil
+	}
+	b, err := os.ReadFile(filepath.Join(parent, controllersFile))
+	if err != nil {
+		return []error{err}
+	}
+	available := strings.Fields(string(b))
+	var errs []error
+	for _, c := range controllers {
+		if !slices.Contains(available, c) {
+			errs = append(errs, fmt.Errorf("controller %q is not available in %s", c, parent))
+		}
+	}
+	return errs
+}
+
+func (r *CPU) validate(parent string) (errs []error) {
+	if r.Weight != nil && (*r.Weight < cpuWeightMin || *r.Weight > cpuWeightMax) {
+		errs = append(errs, fmt.Errorf("cpu.weight: %d is not within [%d, %d]", *r.Weight, cpuWeightMin, cpuWeightMax))
+	}
+	quota := int64(math.MaxInt64)
+	if r.Max != "" {
+		var (
+			period uint64
+			err    error
+		)
+		quota, period, err = r.Max.extractQuotaAndPeriod()
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("cpu.max: %q: %w", r.Max, err))
+		case period < cpuPeriodMin || period > cpuPeriodMax:
+			errs = append(errs, fmt.Errorf("cpu.max: period %d is not within [%d, %d]", period, cpuPeriodMin, cpuPeriodMax))
+		case quota != math.MaxInt64 && quota < cpuQuotaMin:
+			errs = append(errs, fmt.Errorf("cpu.max: quota %d is lower than %d", quota, cpuQuotaMin))
+		}
+	}
+	if r.Burst != nil && quota != math.MaxInt64 && *r.Burst > uint64(quota) {
+		errs = append(errs, fmt.Errorf("cpu.max.burst: %d is greater than the quota %d", *r.Burst, quota))
+	}
+	if r.Idle != nil && *r.Idle != 0 && *r.Idle != 1 {
+		errs = append(errs, fmt.Errorf("cpu.idle: %d is neither 0 nor 1", *r.Idle))
+	}
+	for _, s := range []struct {
+		file, list string
+	}{
+		{"cpuset.cpus", r.Cpus},
+		{"cpuset.mems", r.Mems},
+	} {
+		if s.list == "" {
+			continue
+		}
+		if err := validateCpuset(parent, s.file, s.list); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// validateCpuset checks that list is within the effective cpuset file of
+// the parent cgroup.
+func validateCpuset(parent, file, list string) error {
+	bits, err := cpusetToBits(list)
+	if err != nil {
+		return fmt.Errorf("%s: %q: %w", file, list, err)
+	}
+	b, err := os.ReadFile(filepath.Join(parent, file+".effective"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The cpuset controller is not available, which is reported
+			// separately.
+			return nil
+		}
+		return err
+	}
+	effective := strings.TrimSpace(string(b))
+	var parentBits []byte
+	if effective != "" {
+		if parentBits, err = cpusetToBits(effective); err != nil {
+			return fmt.Errorf("%s.effective: %q: %w", file, effective, err)
+		}
+	}
+	for i, v := range bits {
+		var p byte
+		if i < len(parentBits) {
+			p = parentBits[i]
+		}
+		if v&^p != 0 {
+			return fmt.Errorf("%s: %q is not within the effective set %q of %s", file, list, effective, parent)
+		}
+	}
+	return nil
+}
+
+func (r *Memory) validate() (errs []error) {
+	// Negative values mean "max".
+	limit := func(v *int64) int64 {
+		if *v < 0 {
+			return math.MaxInt64
+		}
+		return *v
+	}
+	var (
+		prev     *int64
+		prevFile string
+	)
+	for _, l := range []struct {
+		file  string
+		value *int64
+	}{
+		{"memory.min", r.Min},
+		{"memory.low", r.Low},
+		{"memory.high", r.High},
+		{"memory.max", r.Max},
+	} {
+		if l.value == nil {
+			continue
+		}
+		if prev != nil && limit(prev) > limit(l.value) {
+			errs = append(errs, fmt.Errorf("%s: %d is lower than %s %d", l.file, *l.value, prevFile, *prev))
+		}
+		prev, prevFile = l.value, l.file
+	}
+	return errs
+}
+
+func (r *IO) validate() (errs []error) {
+	if w := r.BFQ.Weight; w != 0 && (w < bfqWeightMin || w > bfqWeightMax) {
+		errs = append(errs, fmt.Errorf("io.bfq.weight: %d is not within [%d, %d]", w, bfqWeightMin, bfqWeightMax))
+	}
+	devices := map[string]bool{}
+	for _, d := range r.BFQ.WeightDevices {
+		if d.Weight < bfqWeightMin || d.Weight > bfqWeightMax {
+			errs = append(errs, fmt.Errorf("io.bfq.weight: device %d:%d: %d is not within [%d, %d]", d.Major, d.Minor, d.Weight, bfqWeightMin, bfqWeightMax))
+		}
+		devices[fmt.Sprintf("%d:%d", d.Major, d.Minor)] = true
+	}
+	for _, e := range r.Max {
+		devices[fmt.Sprintf("%d:%d", e.Major, e.Minor)] = true
+	}
+	for _, dev := range sortedDevices(devices) {
+		if _, err := os.Stat(filepath.Join(sysDevBlock, dev)); err != nil {
+			errs = append(errs, fmt.Errorf("io: block device %s: %w", dev, err))
+		}
+	}
+	return errs
+}
+
+func sortedDevices(set map[string]bool) []string {
+	devices := make([]string, 0, len(set))
+	for d := range set {
+		devices = append(devices, d)
+	}
+	slices.Sort(devices)
+	return devices
+}
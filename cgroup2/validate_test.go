@@ -0,0 +1,121 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroup2
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newValidateManager(t *testing.T) *Manager {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		controllersFile:         "cpuset cpu io memory hugetlb pids",
+		"cpuset.cpus.effective": "0-3,8",
+		"cpuset.mems.effective": "0",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content+"\n"), 0o644))
+	}
+	path := filepath.Join(root, "child")
+	require.NoError(t, os.Mkdir(path, 0o755))
+
+	blockDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(blockDir, "8:0"), nil, 0o644))
+	old := sysDevBlock
+	sysDevBlock = blockDir
+	t.Cleanup(func() { sysDevBlock = old })
+
+	return &Manager{unifiedMountpoint: root, path: path}
+}
+
+func TestValidate(t *testing.T) {
+	m := newValidateManager(t)
+	r := &Resources{
+		CPU: &CPU{
+			Weight: toPtr(uint64(100)),
+			Max:    NewCPUMax(pointerInt64(50000), toPtr(uint64(100000))),
+			Burst:  toPtr(uint64(10000)),
+			Cpus:   "1-2,8",
+			Mems:   "0",
+		},
+		Memory: &Memory{
+			Min:  pointerInt64(1 << 20),
+			Low:  pointerInt64(1 << 20),
+			High: pointerInt64(1 << 30),
+			Max:  pointerInt64(-1),
+		},
+		Pids: &Pids{Max: 10},
+		IO: &IO{
+			BFQ: BFQ{Weight: 100},
+			Max: []Entry{{Type: ReadBPS, Major: 8, Minor: 0, Rate: 100}},
+		},
+		Unified: map[string]string{"memory.oom.group": "1"},
+	}
+	assert.NoError(t, r.Validate(m))
+	if sizes := hugePageSizes(); len(sizes) > 0 {
+		r.HugeTlb = &HugeTlb{{HugePageSize: sizes[0], Limit: 0}}
+		assert.NoError(t, r.Validate(m))
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	m := newValidateManager(t)
+	r := &Resources{
+		CPU: &CPU{
+			Weight: toPtr(uint64(0)),
+			Max:    NewCPUMax(pointerInt64(100), toPtr(uint64(100000))),
+			Idle:   pointerInt64(2),
+			Cpus:   "2-4",
+			Mems:   "1",
+		},
+		Memory: &Memory{
+			Low:  pointerInt64(1 << 30),
+			High: pointerInt64(1 << 20),
+		},
+		IO: &IO{
+			BFQ: BFQ{WeightDevices: []BFQWeightDevice{{Major: 8, Minor: 0, Weight: 2000}}},
+			Max: []Entry{{Type: ReadBPS, Major: 8, Minor: 16, Rate: 100}},
+		},
+		RDMA:    &RDMA{Limit: []RDMAEntry{{Device: "mlx5_0", HcaHandles: 1, HcaObjects: 1}}},
+		HugeTlb: &HugeTlb{{HugePageSize: "3MB", Limit: 0}},
+		Unified: map[string]string{"cgroup.procs": "1"},
+	}
+	err := r.Validate(m)
+	require.Error(t, err)
+	for _, want := range []string{
+		`controller "rdma" is not available`,
+		"cpu.weight: 0",
+		"cpu.max: quota 100",
+		"cpu.idle: 2",
+		`cpuset.cpus: "2-4"`,
+		`cpuset.mems: "1"`,
+		"memory.high: 1048576 is lower than memory.low",
+		"io.bfq.weight: device 8:0: 2000",
+		"io: block device 8:16",
+		`hugetlb: page size "3MB"`,
+		`"cgroup.procs" cannot be set`,
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+	// One problem per line.
+	assert.Len(t, strings.Split(err.Error(), "\n"), 11)
+}
@@ -0,0 +1,185 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/containerd/cgroups/v3/cgroupstest"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnifiedCgroup(t *testing.T) {
+	u, err := cgroupstest.NewUnified(t.TempDir())
+	require.NoError(t, err)
+	opts := []InitOpts{WithMode(Unified), WithMountpoint(u.Root())}
+
+	limit := int64(1 << 20)
+	cg, err := New("/test", &specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: &limit}}, opts...)
+	require.NoError(t, err)
+	require.NoError(t, u.Sync())
+	assert.Equal(t, Unified, cg.Mode())
+
+	require.NoError(t, cg.Add(42))
+	require.NoError(t, u.Sync())
+	procs, err := cg.Procs(true)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{42}, procs)
+
+	stats, err := cg.Stat()
+	require.NoError(t, err)
+	require.NotNil(t, stats.V2)
+	assert.Nil(t, stats.V1)
+	assert.Equal(t, uint64(limit), stats.V2.Memory.UsageLimit)
+
+	// The swappiness is ignored, realtime scheduling cannot be.
+	swappiness := uint64(10)
+	require.NoError(t, cg.Update(&specs.LinuxResources{Memory: &specs.LinuxMemory{Swappiness: &swappiness}}))
+	realtime := int64(1000)
+	err = cg.Update(&specs.LinuxResources{CPU: &specs.LinuxCPU{RealtimeRuntime: &realtime}})
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	loaded, err := Load("/test", opts...)
+	require.NoError(t, err)
+	require.NoError(t, loaded.Kill())
+	require.NoError(t, u.Sync())
+	procs, err = cg.Procs(true)
+	require.NoError(t, err)
+	assert.Empty(t, procs)
+
+	require.NoError(t, cg.Delete())
+}
+
+func TestUnifiedStrictResources(t *testing.T) {
+	u, err := cgroupstest.NewUnified(t.TempDir())
+	require.NoError(t, err)
+	opts := []InitOpts{WithMode(Unified), WithMountpoint(u.Root())}
+	leafWeight := uint16(100)
+	swappiness := uint64(60)
+	kernelTCP := int64(1 << 20)
+	resources := &specs.LinuxResources{
+		BlockIO: &specs.LinuxBlockIO{LeafWeight: &leafWeight},
+		Memory:  &specs.LinuxMemory{Swappiness: &swappiness, KernelTCP: &kernelTCP},
+	}
+
+	// The leaf weight is ignored by default.
+	cg, err := New("/test", resources, opts...)
+	require.NoError(t, err)
+	require.NoError(t, cg.Update(resources))
+
+	cg, err = Load("/test", append(opts, WithStrictResources())...)
+	require.NoError(t, err)
+	err = cg.Update(resources)
+	assert.ErrorIs(t, err, ErrNotSupported)
+	assert.ErrorContains(t, err, "blockIO.leafWeight")
+	assert.ErrorContains(t, err, "memory.swappiness")
+	assert.ErrorContains(t, err, "memory.kernelTCP")
+	_, err = New("/strict", resources, append(opts, WithStrictResources())...)
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestUnifiedEventChanError(t *testing.T) {
+	u, err := cgroupstest.NewUnified(t.TempDir())
+	require.NoError(t, err)
+	cg, err := New("/test", nil, WithMode(Unified), WithMountpoint(u.Root()))
+	require.NoError(t, err)
+	require.NoError(t, cg.Delete())
+
+	// memory.events cannot be watched, the stream ends with an error.
+	ec, errCh := cg.EventChan()
+	select {
+	case err := <-errCh:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error received")
+	}
+	_, ok := <-errCh
+	assert.False(t, ok)
+	_, ok = <-ec
+	assert.False(t, ok)
+}
+
+func TestLegacyCgroup(t *testing.T) {
+	v, err := cgroupstest.NewV1(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, v.Mkdir("/test"))
+	opts := []InitOpts{WithMode(Legacy), WithHierarchy(v.Hierarchy)}
+
+	shares := uint64(512)
+	cg, err := New("/test", &specs.LinuxResources{CPU: &specs.LinuxCPU{Shares: &shares}}, opts...)
+	require.NoError(t, err)
+	require.NoError(t, v.Sync())
+	assert.Equal(t, Legacy, cg.Mode())
+
+	require.NoError(t, cg.Add(42))
+	require.NoError(t, v.Sync())
+	procs, err := cg.Procs(false)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{42}, procs)
+
+	stats, err := cg.Stat()
+	require.NoError(t, err)
+	require.NotNil(t, stats.V1)
+	assert.Nil(t, stats.V2)
+
+	err = cg.Update(&specs.LinuxResources{Unified: map[string]string{"memory.high": "max"}})
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	require.NoError(t, cg.Freeze())
+	require.NoError(t, cg.Thaw())
+
+	require.NoError(t, v.Exit(42))
+	loaded, err := Load("/test", opts...)
+	require.NoError(t, err)
+	require.NoError(t, loaded.Delete())
+}
+
+func TestLegacyKill(t *testing.T) {
+	v, err := cgroupstest.NewV1(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, v.Mkdir("/test"))
+	cg, err := New("/test", nil, WithMode(Legacy), WithHierarchy(v.Hierarchy))
+	require.NoError(t, err)
+
+	cmd := exec.Command("sleep", "100")
+	require.NoError(t, cmd.Start())
+	require.NoError(t, cg.Add(uint64(cmd.Process.Pid)))
+	require.NoError(t, v.Sync())
+
+	require.NoError(t, cg.Kill())
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, cmd.Wait(), &exitErr)
+	assert.Equal(t, "signal: killed", exitErr.Error())
+}
+
+func TestUnsupportedError(t *testing.T) {
+	err := error(&UnsupportedError{Feature: "memory swappiness", Mode: Unified})
+	assert.EqualError(t, err, "cgroups: memory swappiness is not supported in unified mode")
+	assert.True(t, errors.Is(err, ErrNotSupported))
+
+	realtime := int64(1000)
+	assert.ErrorIs(t, checkUnifiedResources(&specs.LinuxResources{CPU: &specs.LinuxCPU{RealtimeRuntime: &realtime}}), ErrNotSupported)
+	// Resources that are harmless to ignore are left to the conversion.
+	assert.NoError(t, checkUnifiedResources(&specs.LinuxResources{Memory: &specs.LinuxMemory{Kernel: &realtime}}))
+	assert.NoError(t, checkUnifiedResources(&specs.LinuxResources{}))
+}
@@ -0,0 +1,63 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroupstest
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/containerd/cgroups/v3/cgroup1"
+)
+
+// Hybrid emulates the cgroup hierarchies of a host in the hybrid mode: the v1
+// hierarchies holding the controllers, and a v2 hierarchy without
+// controllers in the "unified" directory of root.
+type Hybrid struct {
+	V1      *V1
+	Unified *Unified
+}
+
+// NewHybrid creates the emulated hierarchies in root, with the given v1
+// subsystems, or DefaultSubsystems if none are given.
+func NewHybrid(root string, subsystems ...cgroup1.Name) (*Hybrid, error) {
+	v, err := NewV1(root, subsystems...)
+	if err != nil {
+		return nil, err
+	}
+	u, err := newUnifiedWith(filepath.Join(root, "unified"), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Hybrid{V1: v, Unified: u}, nil
+}
+
+// Mkdir creates the cgroup group and its missing parents in every
+// hierarchy, then calls Sync.
+func (h *Hybrid) Mkdir(group string) error {
+	return errors.Join(h.V1.Mkdir(group), h.Unified.Mkdir(group))
+}
+
+// Exit simulates the exit of a process, then calls Sync.
+func (h *Hybrid) Exit(pid uint64) error {
+	return errors.Join(h.V1.Exit(pid), h.Unified.Exit(pid))
+}
+
+// Sync applies the writes made to the hierarchies since the previous call,
+// see V1.Sync and Unified.Sync.
+func (h *Hybrid) Sync() error {
+	return errors.Join(h.V1.Sync(), h.Unified.Sync())
+}
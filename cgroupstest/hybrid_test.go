@@ -0,0 +1,49 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroupstest
+
+import (
+	"testing"
+
+	"github.com/containerd/cgroups/v3/cgroup1"
+	"github.com/containerd/cgroups/v3/cgroup2"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHybrid(t *testing.T) {
+	h, err := NewHybrid(t.TempDir())
+	require.NoError(t, err)
+	assert.Empty(t, readFile(t, h.Unified.Root(), "cgroup.controllers"))
+	require.NoError(t, h.Mkdir("/test"))
+
+	cg, err := cgroup1.New(cgroup1.StaticPath("/test"), &specs.LinuxResources{}, cgroup1.WithHierarchy(h.V1.Hierarchy))
+	require.NoError(t, err)
+	m, err := cgroup2.NewManager(h.Unified.Root(), "/test", &cgroup2.Resources{})
+	require.NoError(t, err)
+	require.NoError(t, cg.AddProc(42))
+	require.NoError(t, m.AddProc(42))
+	require.NoError(t, h.Sync())
+	assert.Equal(t, []uint64{42}, h.V1.Procs(cgroup1.Memory, "/test"))
+	assert.Equal(t, []uint64{42}, h.Unified.Procs("/test"))
+
+	require.NoError(t, h.Exit(42))
+	assert.Empty(t, h.V1.Procs(cgroup1.Memory, "/test"))
+	assert.Empty(t, h.Unified.Procs("/test"))
+}
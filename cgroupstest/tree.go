@@ -0,0 +1,292 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package cgroupstest emulates cgroup v1 and v2 hierarchies in a regular
+// directory tree, so that code using the cgroup1 and cgroup2 packages can be
+// tested without privileges, through cgroup1.WithHierarchy and
+// cgroup2.WithMountpoint.
+//
+// A regular filesystem cannot react to writes the way cgroupfs does, so the
+// emulation happens in Sync: it populates new cgroups with their interface
+// files, applies the writes made since its previous call with the semantics
+// of the kernel, reverts and reports the writes the kernel would have
+// rejected, and updates the files derived from the state of the hierarchy.
+// Call Sync after each operation whose outcome is checked. Writing the same
+// file twice between two calls only applies the last write.
+//
+// The cgroups created by the code under test only get their interface files
+// at the next Sync. The cgroup1 package creates the files it writes in the
+// meantime without permissions, so that they cannot be read back without
+// root: use Mkdir to create the cgroups beforehand, as the kernel would
+// populate them.
+package cgroupstest
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultDirPerm  = 0o755
+	defaultFilePerm = 0o644
+)
+
+// tree is a cgroup hierarchy rooted at a directory. Cgroups are identified by
+// their path relative to the root, "" being the root cgroup.
+type tree struct {
+	root string
+	// groups holds the cgroups known to the previous Sync.
+	groups map[string]bool
+	// seen holds the content of the files as left by the previous Sync, by
+	// path relative to the root, to tell which files were written since.
+	seen map[string]string
+	// pids maps processes to their cgroup.
+	pids map[uint64]string
+}
+
+func newTree(root string) (*tree, error) {
+	if err := os.MkdirAll(root, defaultDirPerm); err != nil {
+		return nil, err
+	}
+	return &tree{
+		root:   root,
+		groups: make(map[string]bool),
+		seen:   make(map[string]string),
+		pids:   make(map[uint64]string),
+	}, nil
+}
+
+// groupName returns the key of a cgroup given its path relative to the
+// root, e.g. "foo/bar" for "/foo/bar".
+func groupName(group string) string {
+	return strings.TrimPrefix(filepath.Clean("/"+group), "/")
+}
+
+// parent returns the parent of the cgroup rel, which must not be the root.
+func parent(rel string) string {
+	if p := filepath.Dir(rel); p != "." {
+		return p
+	}
+	return ""
+}
+
+// isAncestor reports whether the cgroup a is rel or one of its ancestors.
+func isAncestor(a, rel string) bool {
+	return a == "" || a == rel || strings.HasPrefix(rel, a+"/")
+}
+
+func (t *tree) path(rel, file string) string {
+	return filepath.Join(t.root, rel, file)
+}
+
+func (t *tree) pathError(op, rel, file string, err error) error {
+	return &fs.PathError{Op: op, Path: t.path(rel, file), Err: err}
+}
+
+// walk returns the cgroups of the tree, parents first. The cgroups removed
+// since the previous call are forgotten, reporting the ones that still had
+// processes, as the kernel refuses to remove them.
+func (t *tree) walk() ([]string, error) {
+	var (
+		dirs []string
+		errs []error
+	)
+	err := filepath.WalkDir(t.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(t.root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = ""
+		}
+		dirs = append(dirs, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]bool, len(dirs))
+	for _, rel := range dirs {
+		current[rel] = true
+	}
+	for rel := range t.groups {
+		if current[rel] {
+			continue
+		}
+		delete(t.groups, rel)
+		prefix := rel + "/"
+		for file := range t.seen {
+			if strings.HasPrefix(file, prefix) && !strings.Contains(file[len(prefix):], "/") {
+				delete(t.seen, file)
+			}
+		}
+		for pid, group := range t.pids {
+			if group == rel {
+				delete(t.pids, pid)
+				errs = append(errs, t.pathError("rmdir", rel, "", unix.EBUSY))
+			}
+		}
+	}
+	for _, rel := range dirs {
+		t.groups[rel] = true
+	}
+	return dirs, errors.Join(errs...)
+}
+
+func (t *tree) read(rel, file string) (string, bool) {
+	b, err := os.ReadFile(t.path(rel, file))
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// written returns the content of a file written since the previous Sync.
+func (t *tree) written(rel, file string) (string, bool) {
+	content, ok := t.read(rel, file)
+	if !ok {
+		return "", false
+	}
+	if seen, ok := t.seen[filepath.Join(rel, file)]; ok && seen == content {
+		return "", false
+	}
+	return content, true
+}
+
+// write sets the content of a file, leaving it untouched if it is already
+// up to date so that inotify watchers only see actual changes.
+func (t *tree) write(rel, file, content string) error {
+	t.seen[filepath.Join(rel, file)] = content
+	if current, ok := t.read(rel, file); ok && current == content {
+		return nil
+	}
+	return os.WriteFile(t.path(rel, file), []byte(content), defaultFilePerm)
+}
+
+// revert restores a file to its content as of the previous Sync.
+func (t *tree) revert(rel, file string) error {
+	return t.write(rel, file, t.seen[filepath.Join(rel, file)])
+}
+
+// populate creates the missing interface files of a cgroup with their default
+// content, and removes the files that are not interface files of the cgroup,
+// reporting those created since the previous Sync as the kernel would have
+// refused to create them.
+func (t *tree) populate(rel string, files map[string]string) []error {
+	entries, err := os.ReadDir(t.path(rel, ""))
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		key := filepath.Join(rel, e.Name())
+		if _, ok := files[e.Name()]; ok {
+			// Files created by the code under test may have no permissions.
+			if info, err := e.Info(); err == nil && info.Mode().Perm()&0o400 == 0 {
+				if err := os.Chmod(t.path(rel, e.Name()), defaultFilePerm); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			continue
+		}
+		if _, ok := t.seen[key]; !ok {
+			errs = append(errs, t.pathError("open", rel, e.Name(), unix.ENOENT))
+		}
+		delete(t.seen, key)
+		if err := os.Remove(t.path(rel, e.Name())); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for file, content := range files {
+		if _, err := os.Stat(t.path(rel, file)); err == nil {
+			continue
+		}
+		if err := t.write(rel, file, content); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// procs returns the processes of the cgroup rel, or of its subtree when
+// recursive is set.
+func (t *tree) procs(rel string, recursive bool) []uint64 {
+	var pids []uint64
+	for pid, group := range t.pids {
+		if group == rel || recursive && isAncestor(rel, group) {
+			pids = append(pids, pid)
+		}
+	}
+	slices.Sort(pids)
+	return pids
+}
+
+// syncProcs moves the processes written to the files of the cgroups dirs,
+// then lists the processes of each cgroup in these files. canAttach reports
+// the error the kernel returns when moving a process to a cgroup, if any.
+func (t *tree) syncProcs(dirs []string, files []string, canAttach func(rel string) error) []error {
+	var errs []error
+	for _, rel := range dirs {
+		for _, file := range files {
+			content, ok := t.written(rel, file)
+			if !ok {
+				continue
+			}
+			for _, field := range strings.Fields(content) {
+				pid, err := strconv.ParseUint(field, 10, 64)
+				if err == nil {
+					err = canAttach(rel)
+				} else {
+					err = unix.EINVAL
+				}
+				if err != nil {
+					errs = append(errs, t.pathError("write", rel, file, err))
+					continue
+				}
+				t.pids[pid] = rel
+			}
+		}
+	}
+	for _, rel := range dirs {
+		var b strings.Builder
+		for _, pid := range t.procs(rel, false) {
+			b.WriteString(strconv.FormatUint(pid, 10))
+			b.WriteByte('\n')
+		}
+		for _, file := range files {
+			if err := t.write(rel, file, b.String()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
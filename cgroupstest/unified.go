@@ -0,0 +1,480 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroupstest
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	subtreeControl  = "cgroup.subtree_control"
+	controllersFile = "cgroup.controllers"
+	cgroupProcs     = "cgroup.procs"
+	cgroupThreads   = "cgroup.threads"
+	cgroupEvents    = "cgroup.events"
+	cgroupFreeze    = "cgroup.freeze"
+	cgroupKill      = "cgroup.kill"
+	memoryEvents    = "memory.events"
+)
+
+// DefaultControllers are the controllers available in the root of a Unified
+// hierarchy when none are given to NewUnified.
+var DefaultControllers = []string{"cpuset", "cpu", "io", "memory", "hugetlb", "pids", "rdma", "misc"}
+
+// MemoryEvents are the counters of memory.events.
+var MemoryEvents = []string{"low", "high", "max", "oom", "oom_kill", "oom_group_kill"}
+
+// rootFiles are the interface files of the root cgroup.
+var rootFiles = map[string]string{
+	cgroupProcs:              "",
+	cgroupThreads:            "",
+	controllersFile:          "",
+	subtreeControl:           "",
+	"cgroup.max.depth":       "max\n",
+	"cgroup.max.descendants": "max\n",
+	"cgroup.stat":            "nr_descendants 0\nnr_dying_descendants 0\n",
+	"cpu.stat":               "usage_usec 0\nuser_usec 0\nsystem_usec 0\n",
+}
+
+// coreFiles are the interface files of every non-root cgroup.
+var coreFiles = map[string]string{
+	cgroupProcs:              "",
+	cgroupThreads:            "",
+	controllersFile:          "",
+	subtreeControl:           "",
+	cgroupEvents:             "populated 0\nfrozen 0\n",
+	cgroupFreeze:             "0\n",
+	cgroupKill:               "",
+	"cgroup.type":            "domain\n",
+	"cgroup.max.depth":       "max\n",
+	"cgroup.max.descendants": "max\n",
+	"cgroup.stat":            "nr_descendants 0\nnr_dying_descendants 0\n",
+	"cpu.stat":               "usage_usec 0\nuser_usec 0\nsystem_usec 0\n",
+	"cpu.pressure":           pressureContent,
+	"memory.pressure":        pressureContent,
+	"io.pressure":            pressureContent,
+}
+
+const pressureContent = "some avg10=0.00 avg60=0.00 avg300=0.00 total=0\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n"
+
+func memoryEventsContent(counts map[string]uint64) string {
+	var b strings.Builder
+	for _, e := range MemoryEvents {
+		fmt.Fprintf(&b, "%s %d\n", e, counts[e])
+	}
+	return b.String()
+}
+
+// controllerFiles are the interface files of each controller, created in the
+// cgroups where the controller is enabled by the parent.
+var controllerFiles = map[string]map[string]string{
+	"cpu": {
+		"cpu.weight":      "100\n",
+		"cpu.weight.nice": "0\n",
+		"cpu.max":         "max 100000\n",
+		"cpu.max.burst":   "0\n",
+		"cpu.idle":        "0\n",
+		"cpu.stat":        "usage_usec 0\nuser_usec 0\nsystem_usec 0\nnr_periods 0\nnr_throttled 0\nthrottled_usec 0\nnr_bursts 0\nburst_usec 0\n",
+	},
+	"cpuset": {
+		"cpuset.cpus":           "\n",
+		"cpuset.mems":           "\n",
+		"cpuset.cpus.effective": "\n",
+		"cpuset.mems.effective": "\n",
+		"cpuset.cpus.partition": "member\n",
+	},
+	"io": {
+		"io.max":        "",
+		"io.stat":       "",
+		"io.weight":     "default 100\n",
+		"io.bfq.weight": "default 100\n",
+	},
+	"memory": {
+		"memory.current":      "0\n",
+		"memory.peak":         "0\n",
+		"memory.min":          "0\n",
+		"memory.low":          "0\n",
+		"memory.high":         "max\n",
+		"memory.max":          "max\n",
+		"memory.oom.group":    "0\n",
+		"memory.swap.current": "0\n",
+		"memory.swap.peak":    "0\n",
+		"memory.swap.high":    "max\n",
+		"memory.swap.max":     "max\n",
+		"memory.swap.events":  "high 0\nmax 0\nfail 0\n",
+		memoryEvents:          memoryEventsContent(nil),
+		"memory.events.local": memoryEventsContent(nil),
+		"memory.stat":         "anon 0\nfile 0\nkernel_stack 0\nslab 0\nsock 0\nshmem 0\n",
+	},
+	"pids": {
+		"pids.current": "0\n",
+		"pids.peak":    "0\n",
+		"pids.max":     "max\n",
+		"pids.events":  "max 0\n",
+	},
+	"hugetlb": {
+		"hugetlb.2MB.current":      "0\n",
+		"hugetlb.2MB.max":          "max\n",
+		"hugetlb.2MB.events":       "max 0\n",
+		"hugetlb.2MB.rsvd.current": "0\n",
+		"hugetlb.2MB.rsvd.max":     "max\n",
+		"hugetlb.1GB.current":      "0\n",
+		"hugetlb.1GB.max":          "max\n",
+		"hugetlb.1GB.events":       "max 0\n",
+		"hugetlb.1GB.rsvd.current": "0\n",
+		"hugetlb.1GB.rsvd.max":     "max\n",
+	},
+	"rdma": {
+		"rdma.current": "",
+		"rdma.max":     "",
+	},
+	"misc": {
+		"misc.current": "",
+		"misc.max":     "",
+	},
+}
+
+// Unified emulates a cgroup v2 hierarchy, to be used with
+// cgroup2.WithMountpoint.
+//
+// Sync emulates the propagation of controllers through cgroup.subtree_control
+// and cgroup.controllers, including the rule preventing a non-root cgroup from
+// having both processes and enabled controllers, the moves of processes
+// written to cgroup.procs, cgroup.kill and the freezer state reported in
+// cgroup.events.
+type Unified struct {
+	mu          sync.Mutex
+	tree        *tree
+	controllers []string
+	// subtree holds the controllers enabled in cgroup.subtree_control.
+	subtree map[string][]string
+}
+
+// NewUnified creates an emulated cgroup v2 hierarchy in root, with the given
+// controllers available, or DefaultControllers if none are given.
+func NewUnified(root string, controllers ...string) (*Unified, error) {
+	if len(controllers) == 0 {
+		controllers = DefaultControllers
+	}
+	return newUnifiedWith(root, controllers)
+}
+
+func newUnifiedWith(root string, controllers []string) (*Unified, error) {
+	for _, c := range controllers {
+		if _, ok := controllerFiles[c]; !ok {
+			return nil, fmt.Errorf("unknown controller %q", c)
+		}
+	}
+	t, err := newTree(root)
+	if err != nil {
+		return nil, err
+	}
+	u := &Unified{
+		tree:        t,
+		controllers: slices.Clone(controllers),
+		subtree:     make(map[string][]string),
+	}
+	if err := u.Sync(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Root returns the mountpoint of the hierarchy.
+func (u *Unified) Root() string {
+	return u.tree.root
+}
+
+// Mkdir creates the cgroup group, given as a path relative to the
+// mountpoint, and its missing parents, then calls Sync.
+func (u *Unified) Mkdir(group string) error {
+	if err := os.MkdirAll(u.tree.path(groupName(group), ""), defaultDirPerm); err != nil {
+		return err
+	}
+	return u.Sync()
+}
+
+// Procs returns the processes of a cgroup, given as a path relative to the
+// mountpoint, as of the last Sync.
+func (u *Unified) Procs(group string) []uint64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.tree.procs(groupName(group), false)
+}
+
+// Exit simulates the exit of a process, then calls Sync.
+func (u *Unified) Exit(pid uint64) error {
+	u.mu.Lock()
+	delete(u.tree.pids, pid)
+	u.mu.Unlock()
+	return u.Sync()
+}
+
+// MemoryEvent increments the counter event, one of MemoryEvents, in the
+// memory.events.local file of group and in the memory.events files of group
+// and its ancestors, as the kernel does when the event occurs in group.
+// Watchers of memory.events, e.g. cgroup2.Manager.EventChan, are notified.
+func (u *Unified) MemoryEvent(group, event string) error {
+	if !slices.Contains(MemoryEvents, event) {
+		return fmt.Errorf("unknown memory event %q", event)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	rel := groupName(group)
+	if rel == "" || !slices.Contains(u.available(rel), "memory") {
+		return u.tree.pathError("open", rel, memoryEvents, unix.ENOENT)
+	}
+	if err := u.incrementEvent(rel, "memory.events.local", event); err != nil {
+		return err
+	}
+	for g := rel; g != ""; g = parent(g) {
+		if err := u.incrementEvent(g, memoryEvents, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *Unified) incrementEvent(rel, file, event string) error {
+	content, _ := u.tree.read(rel, file)
+	counts := make(map[string]uint64)
+	for _, line := range strings.Split(content, "\n") {
+		if k, v, ok := strings.Cut(line, " "); ok {
+			counts[k], _ = strconv.ParseUint(v, 10, 64)
+		}
+	}
+	counts[event]++
+	return u.tree.write(rel, file, memoryEventsContent(counts))
+}
+
+// available returns the controllers available in the cgroup rel, listed in
+// its cgroup.controllers.
+func (u *Unified) available(rel string) []string {
+	if rel == "" {
+		return u.controllers
+	}
+	return u.subtree[parent(rel)]
+}
+
+// files returns the interface files of the cgroup rel.
+func (u *Unified) files(rel string) map[string]string {
+	if rel == "" {
+		return rootFiles
+	}
+	files := maps.Clone(coreFiles)
+	for _, c := range u.available(rel) {
+		maps.Copy(files, controllerFiles[c])
+	}
+	return files
+}
+
+// Sync applies the writes made to the hierarchy since the previous call. It
+// returns the errors the kernel would have returned for the writes it
+// rejected, as *fs.PathError, after reverting them.
+func (u *Unified) Sync() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	t := u.tree
+	dirs, err := t.walk()
+	errs := []error{err}
+	for rel := range u.subtree {
+		if !t.groups[rel] {
+			delete(u.subtree, rel)
+		}
+	}
+	for _, rel := range dirs {
+		if err := u.syncSubtreeControl(rel, dirs); err != nil {
+			errs = append(errs, err)
+		}
+		errs = append(errs, t.populate(rel, u.files(rel))...)
+		errs = append(errs, t.write(rel, controllersFile, lineOf(u.available(rel))))
+	}
+	errs = append(errs, t.syncProcs(dirs, []string{cgroupProcs, cgroupThreads}, u.canAttach)...)
+	for _, rel := range dirs {
+		if rel == "" {
+			continue
+		}
+		errs = append(errs, u.syncKill(rel, dirs)...)
+		errs = append(errs, u.syncFreeze(rel))
+	}
+	// Update the derived files once the processes are in place.
+	for _, rel := range dirs {
+		if rel == "" {
+			continue
+		}
+		errs = append(errs, u.syncEvents(rel))
+		if slices.Contains(u.available(rel), "pids") {
+			errs = append(errs, t.write(rel, "pids.current", fmt.Sprintf("%d\n", len(t.procs(rel, true)))))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func lineOf(controllers []string) string {
+	if len(controllers) == 0 {
+		return ""
+	}
+	return strings.Join(controllers, " ") + "\n"
+}
+
+// syncSubtreeControl applies the controllers toggled in the
+// cgroup.subtree_control file of rel. The write is rejected when a controller
+// is not available, when enabling controllers in a non-root cgroup with
+// processes, or when disabling a controller enabled by a child.
+func (u *Unified) syncSubtreeControl(rel string, dirs []string) error {
+	t := u.tree
+	content, ok := t.written(rel, subtreeControl)
+	if !ok {
+		return t.write(rel, subtreeControl, lineOf(u.subtree[rel]))
+	}
+	enabled, err := u.toggle(rel, content, dirs)
+	if err != nil {
+		if rerr := t.revert(rel, subtreeControl); rerr != nil {
+			return rerr
+		}
+		return t.pathError("write", rel, subtreeControl, err)
+	}
+	u.subtree[rel] = enabled
+	return t.write(rel, subtreeControl, lineOf(enabled))
+}
+
+func (u *Unified) toggle(rel, content string, dirs []string) ([]string, error) {
+	available := u.available(rel)
+	current := u.subtree[rel]
+	enable := make(map[string]bool)
+	for _, token := range strings.Fields(content) {
+		if len(token) < 2 || token[0] != '+' && token[0] != '-' {
+			return nil, unix.EINVAL
+		}
+		name := token[1:]
+		if !slices.Contains(u.controllers, name) {
+			return nil, unix.EINVAL
+		}
+		if token[0] == '+' {
+			if !slices.Contains(available, name) {
+				return nil, unix.ENOENT
+			}
+			enable[name] = true
+		} else {
+			enable[name] = false
+		}
+	}
+	var enabled []string
+	adding := false
+	for _, c := range available {
+		on, toggled := enable[c]
+		if !toggled {
+			on = slices.Contains(current, c)
+		}
+		if !on {
+			// Controllers enabled by children cannot be disabled.
+			for _, child := range dirs {
+				if child != "" && parent(child) == rel && slices.Contains(u.subtree[child], c) {
+					return nil, unix.EBUSY
+				}
+			}
+			continue
+		}
+		if !slices.Contains(current, c) {
+			adding = true
+		}
+		enabled = append(enabled, c)
+	}
+	if adding && rel != "" && len(u.tree.procs(rel, false)) > 0 {
+		return nil, unix.EBUSY
+	}
+	return enabled, nil
+}
+
+// canAttach implements the no internal process rule: processes cannot be
+// moved to a non-root cgroup with controllers enabled for its children.
+func (u *Unified) canAttach(rel string) error {
+	if rel != "" && len(u.subtree[rel]) > 0 {
+		return unix.EBUSY
+	}
+	return nil
+}
+
+// syncKill kills the processes of the subtree of rel when 1 was written to its
+// cgroup.kill.
+func (u *Unified) syncKill(rel string, dirs []string) []error {
+	t := u.tree
+	content, ok := t.written(rel, cgroupKill)
+	if !ok {
+		return nil
+	}
+	var errs []error
+	if strings.TrimSpace(content) != "1" {
+		errs = append(errs, t.pathError("write", rel, cgroupKill, unix.ERANGE))
+	} else {
+		for _, pid := range t.procs(rel, true) {
+			delete(t.pids, pid)
+		}
+		// Refresh the process lists of the subtree.
+		var subtree []string
+		for _, d := range dirs {
+			if isAncestor(rel, d) {
+				subtree = append(subtree, d)
+			}
+		}
+		errs = append(errs, t.syncProcs(subtree, []string{cgroupProcs, cgroupThreads}, u.canAttach)...)
+	}
+	return append(errs, t.write(rel, cgroupKill, ""))
+}
+
+func (u *Unified) syncFreeze(rel string) error {
+	t := u.tree
+	content, ok := t.written(rel, cgroupFreeze)
+	if !ok {
+		return nil
+	}
+	switch strings.TrimSpace(content) {
+	case "0", "1":
+		return t.write(rel, cgroupFreeze, strings.TrimSpace(content)+"\n")
+	}
+	if err := t.revert(rel, cgroupFreeze); err != nil {
+		return err
+	}
+	return t.pathError("write", rel, cgroupFreeze, unix.ERANGE)
+}
+
+// syncEvents updates cgroup.events: a cgroup is populated when its subtree
+// has processes, and frozen when it or one of its ancestors is frozen.
+func (u *Unified) syncEvents(rel string) error {
+	t := u.tree
+	populated, frozen := 0, 0
+	if len(t.procs(rel, true)) > 0 {
+		populated = 1
+	}
+	for g := rel; g != ""; g = parent(g) {
+		if state, _ := t.read(g, cgroupFreeze); strings.TrimSpace(state) == "1" {
+			frozen = 1
+			break
+		}
+	}
+	return t.write(rel, cgroupEvents, fmt.Sprintf("populated %d\nfrozen %d\n", populated, frozen))
+}
@@ -0,0 +1,205 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroupstest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/cgroups/v3/cgroup2"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func newUnified(t *testing.T) *Unified {
+	t.Helper()
+	u, err := NewUnified(t.TempDir())
+	require.NoError(t, err)
+	return u
+}
+
+func readFile(t *testing.T, path ...string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(path...))
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestUnifiedNewManager(t *testing.T) {
+	u := newUnified(t)
+	max := int64(1 << 20)
+	m, err := cgroup2.NewManager(u.Root(), "/a", &cgroup2.Resources{Memory: &cgroup2.Memory{Max: &max}})
+	require.NoError(t, err)
+	require.NoError(t, u.Sync())
+
+	assert.Equal(t, "memory\n", readFile(t, u.Root(), "cgroup.subtree_control"))
+	controllers, err := m.Controllers()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"memory"}, controllers)
+	assert.Equal(t, "1048576", readFile(t, u.Root(), "a", "memory.max"))
+	assert.FileExists(t, filepath.Join(u.Root(), "a", "memory.current"))
+	assert.NoFileExists(t, filepath.Join(u.Root(), "a", "pids.max"))
+
+	// pids is not enabled for /a.
+	require.NoError(t, m.Update(&cgroup2.Resources{Pids: &cgroup2.Pids{Max: 10}}))
+	err = u.Sync()
+	assert.ErrorIs(t, err, unix.ENOENT)
+	assert.NoFileExists(t, filepath.Join(u.Root(), "a", "pids.max"))
+}
+
+func TestUnifiedProcs(t *testing.T) {
+	u := newUnified(t)
+	a, err := cgroup2.NewManager(u.Root(), "/a", &cgroup2.Resources{})
+	require.NoError(t, err)
+	b, err := cgroup2.NewManager(u.Root(), "/b", &cgroup2.Resources{})
+	require.NoError(t, err)
+
+	require.NoError(t, a.AddProc(42))
+	require.NoError(t, u.Sync())
+	assert.Equal(t, []uint64{42}, u.Procs("/a"))
+	assert.Equal(t, "populated 1\nfrozen 0\n", readFile(t, u.Root(), "a", "cgroup.events"))
+
+	require.NoError(t, a.MoveTo(b))
+	require.NoError(t, u.Sync())
+	procs, err := a.Procs(false)
+	require.NoError(t, err)
+	assert.Empty(t, procs)
+	procs, err = b.Procs(false)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{42}, procs)
+
+	require.NoError(t, u.Exit(42))
+	assert.Empty(t, u.Procs("/b"))
+	assert.Equal(t, "populated 0\nfrozen 0\n", readFile(t, u.Root(), "b", "cgroup.events"))
+}
+
+func TestUnifiedNoInternalProcess(t *testing.T) {
+	u := newUnified(t)
+	m, err := cgroup2.NewManager(u.Root(), "/a", &cgroup2.Resources{})
+	require.NoError(t, err)
+	require.NoError(t, m.AddProc(42))
+	require.NoError(t, u.Sync())
+
+	// Enabling controllers for the children of /a fails while it has processes.
+	_, err = m.NewChild("b", &cgroup2.Resources{Pids: &cgroup2.Pids{Max: 10}})
+	require.NoError(t, err)
+	err = u.Sync()
+	assert.ErrorIs(t, err, unix.EBUSY)
+	assert.Equal(t, "", readFile(t, u.Root(), "a", "cgroup.subtree_control"))
+
+	// Once enabled, processes cannot be moved to /a anymore.
+	require.NoError(t, u.Exit(42))
+	require.NoError(t, m.ToggleControllers([]string{"pids"}, cgroup2.Enable))
+	require.NoError(t, writeFile(filepath.Join(u.Root(), "a", "cgroup.subtree_control"), "+pids"))
+	require.NoError(t, u.Sync())
+	assert.Equal(t, "pids\n", readFile(t, u.Root(), "a", "b", "cgroup.controllers"))
+	require.NoError(t, m.AddProc(43))
+	assert.ErrorIs(t, u.Sync(), unix.EBUSY)
+	assert.Empty(t, u.Procs("/a"))
+
+	// Controllers enabled by children cannot be disabled.
+	require.NoError(t, writeFile(filepath.Join(u.Root(), "a", "b", "cgroup.subtree_control"), "+pids"))
+	require.NoError(t, u.Sync())
+	require.NoError(t, writeFile(filepath.Join(u.Root(), "a", "cgroup.subtree_control"), "-pids"))
+	assert.ErrorIs(t, u.Sync(), unix.EBUSY)
+	assert.Equal(t, "pids\n", readFile(t, u.Root(), "a", "cgroup.subtree_control"))
+}
+
+func TestUnifiedKill(t *testing.T) {
+	u := newUnified(t)
+	m, err := cgroup2.NewManager(u.Root(), "/a", &cgroup2.Resources{})
+	require.NoError(t, err)
+	child, err := m.NewChild("b", nil)
+	require.NoError(t, err)
+	require.NoError(t, child.AddProc(42))
+	require.NoError(t, u.Sync())
+
+	require.NoError(t, m.Kill())
+	require.NoError(t, u.Sync())
+	assert.Empty(t, u.Procs("/a/b"))
+	assert.True(t, strings.HasPrefix(readFile(t, u.Root(), "a", "cgroup.events"), "populated 0\n"))
+}
+
+func TestUnifiedFreeze(t *testing.T) {
+	u := newUnified(t)
+	m, err := cgroup2.NewManager(u.Root(), "/a", &cgroup2.Resources{})
+	require.NoError(t, err)
+	_, err = m.NewChild("b", nil)
+	require.NoError(t, err)
+	require.NoError(t, u.Sync())
+
+	require.NoError(t, m.Freeze())
+	require.NoError(t, u.Sync())
+	assert.Equal(t, "populated 0\nfrozen 1\n", readFile(t, u.Root(), "a", "cgroup.events"))
+	assert.Equal(t, "populated 0\nfrozen 1\n", readFile(t, u.Root(), "a", "b", "cgroup.events"))
+
+	require.NoError(t, m.Thaw())
+	require.NoError(t, u.Sync())
+	assert.Equal(t, "populated 0\nfrozen 0\n", readFile(t, u.Root(), "a", "b", "cgroup.events"))
+}
+
+func TestUnifiedMemoryEvent(t *testing.T) {
+	u := newUnified(t)
+	max := int64(1 << 20)
+	m, err := cgroup2.NewManager(u.Root(), "/a", &cgroup2.Resources{Memory: &cgroup2.Memory{Max: &max}})
+	require.NoError(t, err)
+	require.NoError(t, u.Sync())
+	child, err := m.NewChild("b", &cgroup2.Resources{Memory: &cgroup2.Memory{Max: &max}})
+	require.NoError(t, err)
+	require.NoError(t, u.Sync())
+
+	ec, errCh := m.EventChan()
+	require.NoError(t, u.MemoryEvent("/a/b", "oom_kill"))
+	select {
+	case ev := <-ec:
+		assert.Equal(t, uint64(1), ev.OOMKill)
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no memory event")
+	}
+
+	metrics, err := child.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), metrics.MemoryEvents.OomKill)
+	assert.Contains(t, readFile(t, u.Root(), "a", "b", "memory.events.local"), "oom_kill 1\n")
+	assert.Contains(t, readFile(t, u.Root(), "a", "memory.events.local"), "oom_kill 0\n")
+
+	assert.Error(t, u.MemoryEvent("/a/b", "unknown"))
+	assert.ErrorIs(t, u.MemoryEvent("/", "oom"), unix.ENOENT)
+}
+
+func TestUnifiedRemovePopulated(t *testing.T) {
+	u := newUnified(t)
+	m, err := cgroup2.NewManager(u.Root(), "/a", &cgroup2.Resources{})
+	require.NoError(t, err)
+	require.NoError(t, m.AddProc(42))
+	require.NoError(t, u.Sync())
+
+	require.NoError(t, os.RemoveAll(filepath.Join(u.Root(), "a")))
+	assert.ErrorIs(t, u.Sync(), unix.EBUSY)
+	assert.NoError(t, u.Sync())
+}
+
+func writeFile(path, content string) error {
+	return os.WriteFile(path, []byte(content), 0o644)
+}
@@ -0,0 +1,355 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroupstest
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/containerd/cgroups/v3/cgroup1"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	freezerState    = "freezer.state"
+	unlimitedMemory = "9223372036854771712\n"
+)
+
+// v1CoreFiles are the interface files of every cgroup of a v1 hierarchy.
+var v1CoreFiles = map[string]string{
+	"cgroup.procs":          "",
+	"tasks":                 "",
+	"notify_on_release":     "0\n",
+	"cgroup.clone_children": "0\n",
+}
+
+func v1MemoryFiles() map[string]string {
+	files := map[string]string{
+		"memory.stat":                     "cache 0\nrss 0\nrss_huge 0\nshmem 0\nmapped_file 0\ndirty 0\nwriteback 0\npgpgin 0\npgpgout 0\npgfault 0\npgmajfault 0\n",
+		"memory.oom_control":              "oom_kill_disable 0\nunder_oom 0\noom_kill 0\n",
+		"memory.swappiness":               "60\n",
+		"memory.use_hierarchy":            "1\n",
+		"memory.pressure_level":           "",
+		"memory.force_empty":              "",
+		"cgroup.event_control":            "",
+		"memory.move_charge_at_immigrate": "0\n",
+	}
+	for _, module := range []string{"memory", "memory.memsw", "memory.kmem", "memory.kmem.tcp"} {
+		files[module+".usage_in_bytes"] = "0\n"
+		files[module+".max_usage_in_bytes"] = "0\n"
+		files[module+".failcnt"] = "0\n"
+		files[module+".limit_in_bytes"] = unlimitedMemory
+	}
+	files["memory.soft_limit_in_bytes"] = unlimitedMemory
+	return files
+}
+
+func v1BlkioFiles() map[string]string {
+	files := map[string]string{
+		"blkio.weight":             "500\n",
+		"blkio.weight_device":      "",
+		"blkio.leaf_weight":        "500\n",
+		"blkio.leaf_weight_device": "",
+		"blkio.reset_stats":        "",
+	}
+	for _, f := range []string{
+		"throttle.read_bps_device", "throttle.write_bps_device",
+		"throttle.read_iops_device", "throttle.write_iops_device",
+		"throttle.io_serviced", "throttle.io_service_bytes",
+		"sectors_recursive", "io_service_bytes_recursive", "io_serviced_recursive",
+		"io_queued_recursive", "io_service_time_recursive", "io_wait_time_recursive",
+		"io_merged_recursive", "time_recursive",
+	} {
+		files["blkio."+f] = ""
+	}
+	return files
+}
+
+// v1Files are the interface files of each subsystem.
+var v1Files = map[cgroup1.Name]map[string]string{
+	cgroup1.SystemdDbus: {},
+	cgroup1.Freezer: {
+		freezerState:              "THAWED\n",
+		"freezer.self_freezing":   "0\n",
+		"freezer.parent_freezing": "0\n",
+	},
+	cgroup1.Pids: {
+		"pids.current": "0\n",
+		"pids.max":     "max\n",
+		"pids.events":  "max 0\n",
+	},
+	cgroup1.NetCLS: {
+		"net_cls.classid": "0\n",
+	},
+	cgroup1.NetPrio: {
+		"net_prio.ifpriomap": "",
+		"net_prio.prioidx":   "1\n",
+	},
+	cgroup1.PerfEvent: {},
+	cgroup1.Cpuset: {
+		"cpuset.cpus":          "\n",
+		"cpuset.mems":          "\n",
+		"cpuset.cpu_exclusive": "0\n",
+		"cpuset.mem_exclusive": "0\n",
+	},
+	cgroup1.Cpu: {
+		"cpu.shares":        "1024\n",
+		"cpu.cfs_period_us": "100000\n",
+		"cpu.cfs_quota_us":  "-1\n",
+		"cpu.rt_period_us":  "1000000\n",
+		"cpu.rt_runtime_us": "0\n",
+		"cpu.stat":          "nr_periods 0\nnr_throttled 0\nthrottled_time 0\n",
+	},
+	cgroup1.Cpuacct: {
+		"cpuacct.usage":        "0\n",
+		"cpuacct.usage_percpu": "0 \n",
+		"cpuacct.stat":         "user 0\nsystem 0\n",
+	},
+	cgroup1.Memory: v1MemoryFiles(),
+	cgroup1.Blkio:  v1BlkioFiles(),
+	cgroup1.Rdma: {
+		"rdma.current": "",
+		"rdma.max":     "",
+	},
+	cgroup1.Devices: {
+		"devices.allow": "",
+		"devices.deny":  "",
+		"devices.list":  "a *:* rwm\n",
+	},
+}
+
+// DefaultSubsystems are the subsystems of a V1 hierarchy when none are given
+// to NewV1.
+var DefaultSubsystems = []cgroup1.Name{
+	cgroup1.SystemdDbus, cgroup1.Freezer, cgroup1.Pids, cgroup1.NetCLS, cgroup1.NetPrio,
+	cgroup1.PerfEvent, cgroup1.Cpuset, cgroup1.Cpu, cgroup1.Cpuacct, cgroup1.Memory,
+	cgroup1.Blkio, cgroup1.Rdma, cgroup1.Devices,
+}
+
+// V1 emulates the hierarchies of cgroup v1 subsystems, mounted in
+// directories of a common root named after the subsystems, to be used with
+// cgroup1.WithHierarchy.
+//
+// Sync emulates the moves of the processes written to cgroup.procs and
+// tasks, separately in each hierarchy, and the freezer states.
+type V1 struct {
+	mu         sync.Mutex
+	root       string
+	subsystems []cgroup1.Name
+	trees      map[cgroup1.Name]*tree
+}
+
+// NewV1 creates emulated cgroup v1 hierarchies for the given subsystems, or
+// DefaultSubsystems if none are given, in root.
+func NewV1(root string, subsystems ...cgroup1.Name) (*V1, error) {
+	if len(subsystems) == 0 {
+		subsystems = DefaultSubsystems
+	}
+	v := &V1{
+		root:       root,
+		subsystems: subsystems,
+		trees:      make(map[cgroup1.Name]*tree, len(subsystems)),
+	}
+	for _, s := range subsystems {
+		if _, ok := v1Files[s]; !ok {
+			return nil, fmt.Errorf("unknown subsystem %q", s)
+		}
+		t, err := newTree(filepath.Join(root, string(s)))
+		if err != nil {
+			return nil, err
+		}
+		v.trees[s] = t
+	}
+	// The root cpuset has all the CPUs and memory nodes.
+	if t, ok := v.trees[cgroup1.Cpuset]; ok {
+		for file, content := range map[string]string{
+			"cpuset.cpus": fmt.Sprintf("0-%d\n", runtime.NumCPU()-1),
+			"cpuset.mems": "0\n",
+		} {
+			if err := os.WriteFile(t.path("", file), []byte(content), defaultFilePerm); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := v.Sync(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Root returns the directory holding the hierarchies.
+func (v *V1) Root() string {
+	return v.root
+}
+
+// Hierarchy returns the subsystems of the emulated hierarchies. It can be
+// used as a cgroup1.Hierarchy.
+func (v *V1) Hierarchy() ([]cgroup1.Subsystem, error) {
+	var out []cgroup1.Subsystem
+	for _, s := range v.subsystems {
+		switch s {
+		case cgroup1.SystemdDbus:
+			out = append(out, cgroup1.NewNamed(v.root, s))
+		case cgroup1.Freezer:
+			out = append(out, cgroup1.NewFreezer(v.root))
+		case cgroup1.Pids:
+			out = append(out, cgroup1.NewPids(v.root))
+		case cgroup1.NetCLS:
+			out = append(out, cgroup1.NewNetCls(v.root))
+		case cgroup1.NetPrio:
+			out = append(out, cgroup1.NewNetPrio(v.root))
+		case cgroup1.PerfEvent:
+			out = append(out, cgroup1.NewPerfEvent(v.root))
+		case cgroup1.Cpuset:
+			out = append(out, cgroup1.NewCpuset(v.root))
+		case cgroup1.Cpu:
+			out = append(out, cgroup1.NewCpu(v.root))
+		case cgroup1.Cpuacct:
+			out = append(out, cgroup1.NewCpuacct(v.root))
+		case cgroup1.Memory:
+			out = append(out, cgroup1.NewMemory(v.root))
+		case cgroup1.Blkio:
+			out = append(out, cgroup1.NewBlkio(v.root))
+		case cgroup1.Rdma:
+			out = append(out, cgroup1.NewRdma(v.root))
+		case cgroup1.Devices:
+			out = append(out, cgroup1.NewDevices(v.root))
+		}
+	}
+	return out, nil
+}
+
+// Mkdir creates the cgroup group and its missing parents in every
+// hierarchy, then calls Sync.
+func (v *V1) Mkdir(group string) error {
+	for _, t := range v.trees {
+		if err := os.MkdirAll(t.path(groupName(group), ""), defaultDirPerm); err != nil {
+			return err
+		}
+	}
+	return v.Sync()
+}
+
+// Procs returns the processes of a cgroup of the hierarchy of subsystem, as
+// of the last Sync.
+func (v *V1) Procs(subsystem cgroup1.Name, group string) []uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	t, ok := v.trees[subsystem]
+	if !ok {
+		return nil
+	}
+	return t.procs(groupName(group), false)
+}
+
+// Exit simulates the exit of a process, then calls Sync.
+func (v *V1) Exit(pid uint64) error {
+	v.mu.Lock()
+	for _, t := range v.trees {
+		delete(t.pids, pid)
+	}
+	v.mu.Unlock()
+	return v.Sync()
+}
+
+// Sync applies the writes made to the hierarchies since the previous call.
+// It returns the errors the kernel would have returned for the writes it
+// rejected, as *fs.PathError, after reverting them.
+func (v *V1) Sync() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var errs []error
+	for _, s := range v.subsystems {
+		t := v.trees[s]
+		dirs, err := t.walk()
+		errs = append(errs, err)
+		files := maps.Clone(v1CoreFiles)
+		maps.Copy(files, v1Files[s])
+		for _, rel := range dirs {
+			groupFiles := files
+			if rel == "" && s == cgroup1.Freezer {
+				// The root cgroup cannot be frozen.
+				groupFiles = v1CoreFiles
+			}
+			errs = append(errs, t.populate(rel, groupFiles)...)
+		}
+		errs = append(errs, t.syncProcs(dirs, []string{"cgroup.procs", "tasks"}, func(string) error { return nil })...)
+		switch s {
+		case cgroup1.Freezer:
+			for _, rel := range dirs {
+				if rel != "" {
+					errs = append(errs, syncFreezer(t, rel))
+				}
+			}
+		case cgroup1.Pids:
+			for _, rel := range dirs {
+				if rel != "" {
+					errs = append(errs, t.write(rel, "pids.current", fmt.Sprintf("%d\n", len(t.procs(rel, true)))))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncFreezer applies the state written to freezer.state, which the kernel
+// only accepts as FROZEN or THAWED. A cgroup reads as FROZEN when it or one of
+// its ancestors is frozen.
+func syncFreezer(t *tree, rel string) error {
+	var err error
+	current, _ := t.read(rel, "freezer.self_freezing")
+	self := strings.TrimSpace(current) == "1"
+	if content, ok := t.written(rel, freezerState); ok {
+		switch strings.TrimSpace(content) {
+		case "FROZEN":
+			self = true
+		case "THAWED":
+			self = false
+		default:
+			err = t.pathError("write", rel, freezerState, unix.EINVAL)
+		}
+	}
+	parentFreezing := false
+	if p := parent(rel); p != "" {
+		state, _ := t.read(p, freezerState)
+		parentFreezing = strings.TrimSpace(state) == "FROZEN"
+	}
+	state := "THAWED\n"
+	if self || parentFreezing {
+		state = "FROZEN\n"
+	}
+	return errors.Join(err,
+		t.write(rel, freezerState, state),
+		t.write(rel, "freezer.self_freezing", boolFile(self)),
+		t.write(rel, "freezer.parent_freezing", boolFile(parentFreezing)),
+	)
+}
+
+func boolFile(b bool) string {
+	if b {
+		return "1\n"
+	}
+	return "0\n"
+}
@@ -0,0 +1,110 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroupstest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/cgroups/v3/cgroup1"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func newV1(t *testing.T) *V1 {
+	t.Helper()
+	v, err := NewV1(t.TempDir())
+	require.NoError(t, err)
+	return v
+}
+
+func TestV1New(t *testing.T) {
+	v := newV1(t)
+	require.NoError(t, v.Mkdir("/test"))
+	limit := int64(1 << 20)
+	cg, err := cgroup1.New(cgroup1.StaticPath("/test"), &specs.LinuxResources{
+		Memory: &specs.LinuxMemory{Limit: &limit},
+	}, cgroup1.WithHierarchy(v.Hierarchy))
+	require.NoError(t, err)
+	require.NoError(t, v.Sync())
+	assert.Equal(t, "1048576", readFile(t, v.Root(), "memory", "test", "memory.limit_in_bytes"))
+	assert.Equal(t, readFile(t, v.Root(), "cpuset", "cpuset.cpus"), readFile(t, v.Root(), "cpuset", "test", "cpuset.cpus"))
+
+	_, err = cg.Stat(cgroup1.IgnoreNotExist)
+	require.NoError(t, err)
+	require.NoError(t, cg.Delete())
+	require.NoError(t, v.Sync())
+	assert.NoDirExists(t, filepath.Join(v.Root(), "memory", "test"))
+}
+
+func TestV1Procs(t *testing.T) {
+	v := newV1(t)
+	require.NoError(t, v.Mkdir("/a"))
+	require.NoError(t, v.Mkdir("/b"))
+	a, err := cgroup1.New(cgroup1.StaticPath("/a"), &specs.LinuxResources{}, cgroup1.WithHierarchy(v.Hierarchy))
+	require.NoError(t, err)
+	b, err := cgroup1.New(cgroup1.StaticPath("/b"), &specs.LinuxResources{}, cgroup1.WithHierarchy(v.Hierarchy))
+	require.NoError(t, err)
+	require.NoError(t, v.Sync())
+
+	require.NoError(t, a.Add(cgroup1.Process{Pid: 42}))
+	require.NoError(t, v.Sync())
+	assert.Equal(t, []uint64{42}, v.Procs(cgroup1.Memory, "/a"))
+
+	// Processes can be in different cgroups in each hierarchy.
+	require.NoError(t, b.Add(cgroup1.Process{Pid: 42}, cgroup1.Memory))
+	require.NoError(t, v.Sync())
+	assert.Empty(t, v.Procs(cgroup1.Memory, "/a"))
+	assert.Equal(t, []uint64{42}, v.Procs(cgroup1.Memory, "/b"))
+	assert.Equal(t, []uint64{42}, v.Procs(cgroup1.Pids, "/a"))
+	assert.Equal(t, "1\n", readFile(t, v.Root(), "pids", "a", "pids.current"))
+
+	procs, err := b.Processes(cgroup1.Memory, false)
+	require.NoError(t, err)
+	require.Len(t, procs, 1)
+	assert.Equal(t, 42, procs[0].Pid)
+
+	require.NoError(t, v.Exit(42))
+	assert.Empty(t, v.Procs(cgroup1.Pids, "/a"))
+}
+
+func TestV1Freezer(t *testing.T) {
+	v := newV1(t)
+	require.NoError(t, v.Mkdir("/a/b"))
+	cg, err := cgroup1.New(cgroup1.StaticPath("/a"), &specs.LinuxResources{}, cgroup1.WithHierarchy(v.Hierarchy))
+	require.NoError(t, err)
+	_, err = cg.New("b", &specs.LinuxResources{})
+	require.NoError(t, err)
+	require.NoError(t, v.Sync())
+
+	require.NoError(t, cg.Freeze())
+	require.NoError(t, v.Sync())
+	assert.Equal(t, cgroup1.Frozen, cg.State())
+	assert.Equal(t, "FROZEN\n", readFile(t, v.Root(), "freezer", "a", "b", "freezer.state"))
+	assert.Equal(t, "1\n", readFile(t, v.Root(), "freezer", "a", "b", "freezer.parent_freezing"))
+
+	require.NoError(t, cg.Thaw())
+	require.NoError(t, v.Sync())
+	assert.Equal(t, "THAWED\n", readFile(t, v.Root(), "freezer", "a", "b", "freezer.state"))
+
+	require.NoError(t, writeFile(filepath.Join(v.Root(), "freezer", "a", "freezer.state"), "FREEZING"))
+	assert.ErrorIs(t, v.Sync(), unix.EINVAL)
+	assert.Equal(t, "THAWED\n", readFile(t, v.Root(), "freezer", "a", "freezer.state"))
+}
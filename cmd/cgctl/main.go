@@ -51,6 +51,8 @@ func main() {
 		listCommand,
 		listControllersCommand,
 		statCommand,
+		featuresCommand,
+		devicesCommand,
 		newSystemdCommand,
 		deleteSystemdCommand,
 	}
@@ -164,9 +166,70 @@ var statCommand = cli.Command{
 	},
 }
 
+var featuresCommand = cli.Command{
+	Name:  "features",
+	Usage: "list the cgroup v2 features supported by the kernel",
+	Action: func(clix *cli.Context) error {
+		f, err := cgroup2.Features(clix.GlobalString("mountpoint"))
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(f)
+	},
+}
+
+var devicesCommand = cli.Command{
+	Name:  "devices",
+	Usage: "list the device rules enforced by the eBPF filters of a cgroup",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "effective",
+			Usage: "include the filters inherited from the ancestors",
+		},
+	},
+	Action: func(clix *cli.Context) error {
+		path := clix.Args().First()
+		c, err := cgroup2.Load(path, cgroup2.WithMountpoint(clix.GlobalString("mountpoint")))
+		if err != nil {
+			return err
+		}
+		filters, err := c.DeviceFilters(clix.Bool("effective"))
+		if err != nil {
+			return err
+		}
+		for _, f := range filters {
+			fmt.Printf("# program %d %s\n", f.ID, f.Name)
+			if f.Devices == nil {
+				fmt.Println("# not generated by this library, rules unknown")
+			}
+			for _, d := range f.Devices {
+				action := "deny"
+				if d.Allow {
+					action = "allow"
+				}
+				fmt.Printf("%s %s %s:%s %s\n", action, d.Type, deviceNumber(d.Major), deviceNumber(d.Minor), d.Access)
+			}
+		}
+		return nil
+	},
+}
+
+// deviceNumber formats a major or minor number like devices.list does.
+func deviceNumber(n *int64) string {
+	if n == nil || *n < 0 {
+		return "*"
+	}
+	return strconv.FormatInt(*n, 10)
+}
+
 var newSystemdCommand = cli.Command{
 	Name:  "systemd",
 	Usage: "create a new systemd managed cgroup",
+	Flags: []cli.Flag{
+		systemdUserFlag,
+	},
 	Action: func(clix *cli.Context) error {
 		path := clix.Args().First()
 		pidStr := clix.Args().Get(1)
@@ -175,7 +238,7 @@ var newSystemdCommand = cli.Command{
 			pid, _ = strconv.Atoi(pidStr)
 		}
 
-		_, err := cgroup2.NewSystemd("", path, pid, &cgroup2.Resources{})
+		_, err := cgroup2.NewSystemd("", path, pid, &cgroup2.Resources{}, systemdOpts(clix)...)
 		if err != nil {
 			return err
 		}
@@ -186,9 +249,12 @@ var newSystemdCommand = cli.Command{
 var deleteSystemdCommand = cli.Command{
 	Name:  "del-systemd",
 	Usage: "delete a systemd managed cgroup",
+	Flags: []cli.Flag{
+		systemdUserFlag,
+	},
 	Action: func(clix *cli.Context) error {
 		path := clix.Args().First()
-		m, err := cgroup2.LoadSystemd("", path)
+		m, err := cgroup2.LoadSystemd("", path, systemdOpts(clix)...)
 		if err != nil {
 			return err
 		}
@@ -200,6 +266,19 @@ var deleteSystemdCommand = cli.Command{
 	},
 }
 
+var systemdUserFlag = cli.BoolFlag{
+	Name:  "user",
+	Usage: "use the systemd user instance (rootless)",
+}
+
+func systemdOpts(clix *cli.Context) []cgroup2.InitOpts {
+	var opts []cgroup2.InitOpts
+	if clix.Bool("user") {
+		opts = append(opts, cgroup2.WithSystemdUser())
+	}
+	return opts
+}
+
 var modeCommand = cli.Command{
 	Name:  "mode",
 	Usage: "return the cgroup mode that is mounted on the system",
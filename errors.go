@@ -0,0 +1,48 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnavailable is returned when no cgroup filesystem is mounted.
+	ErrUnavailable = errors.New("cgroups: no cgroup filesystem is mounted")
+	// ErrNotSupported is matched by the errors returned when using a feature
+	// that is not supported in the cgroups mode of the host, see
+	// UnsupportedError.
+	ErrNotSupported = errors.New("cgroups: not supported")
+)
+
+// UnsupportedError is returned when using a feature that is not supported
+// in the cgroups mode of the host. It matches ErrNotSupported with errors.Is.
+type UnsupportedError struct {
+	// Feature is the unsupported feature, e.g. "memory swappiness".
+	Feature string
+	// Mode is the cgroups mode in use.
+	Mode CGMode
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("cgroups: %s is not supported in %s mode", e.Feature, e.Mode)
+}
+
+func (e *UnsupportedError) Is(target error) bool {
+	return target == ErrNotSupported
+}
@@ -0,0 +1,176 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/containerd/cgroups/v3/cgroup1"
+	"github.com/containerd/cgroups/v3/cgroup2"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// hybrid is a Cgroup managed with the cgroup1 package in the v1 hierarchies,
+// which hold the controllers, and with the cgroup2 package in the unified
+// hierarchy, which only provides the core cgroup v2 features.
+type hybrid struct {
+	legacy
+	m    *cgroup2.Manager
+	path string
+	// bpfDevices is set when the devices are not controlled by a v1
+	// hierarchy but by an eBPF program attached in the unified hierarchy.
+	bpfDevices bool
+}
+
+// hybridPath returns the path of the cgroup in the unified hierarchy, which
+// mirrors the v1 hierarchies, see legacyPath.
+func hybridPath(c *InitConfig, path string) string {
+	if c.driver == Systemd {
+		slice, unit := splitUnit(path)
+		return filepath.Join("/", slice, unit)
+	}
+	return filepath.Join("/", path)
+}
+
+func newHybrid(c *InitConfig, path string, resources *specs.LinuxResources) (Cgroup, error) {
+	if err := checkLegacyResources(resources, Hybrid); err != nil {
+		return nil, err
+	}
+	cg, err := cgroup1.New(legacyPath(c, path), resources, legacyOpts(c)...)
+	if err != nil {
+		return nil, err
+	}
+	h := &hybrid{
+		legacy:     legacy{cg: cg, mode: Hybrid},
+		path:       filepath.Join(c.mountpoint, hybridPath(c, path)),
+		bpfDevices: !hasSubsystem(cg, cgroup1.Devices),
+	}
+	h.m, err = cgroup2.NewManager(c.mountpoint, hybridPath(c, path), h.unifiedResources(resources))
+	if err != nil {
+		_ = cg.Delete()
+		return nil, err
+	}
+	return h, nil
+}
+
+func loadHybrid(c *InitConfig, path string) (Cgroup, error) {
+	cg, err := cgroup1.Load(legacyPath(c, path), legacyOpts(c)...)
+	if err != nil {
+		return nil, err
+	}
+	m, err := cgroup2.Load(hybridPath(c, path), cgroup2.WithMountpoint(c.mountpoint))
+	if err != nil {
+		return nil, err
+	}
+	return &hybrid{
+		legacy:     legacy{cg: cg, mode: Hybrid},
+		m:          m,
+		path:       filepath.Join(c.mountpoint, hybridPath(c, path)),
+		bpfDevices: !hasSubsystem(cg, cgroup1.Devices),
+	}, nil
+}
+
+func hasSubsystem(cg cgroup1.Cgroup, name cgroup1.Name) bool {
+	return slices.ContainsFunc(cg.Subsystems(), func(s cgroup1.Subsystem) bool {
+		return s.Name() == name
+	})
+}
+
+// unifiedResources returns the resources applied in the unified hierarchy.
+func (h *hybrid) unifiedResources(resources *specs.LinuxResources) *cgroup2.Resources {
+	if !h.bpfDevices {
+		return &cgroup2.Resources{}
+	}
+	return &cgroup2.Resources{Devices: resources.Devices}
+}
+
+func (h *hybrid) Update(resources *specs.LinuxResources) error {
+	if err := h.legacy.Update(resources); err != nil {
+		return err
+	}
+	if h.bpfDevices && resources.Devices != nil {
+		return h.m.Update(h.unifiedResources(resources))
+	}
+	return nil
+}
+
+func (h *hybrid) Add(pid uint64) error {
+	if err := h.legacy.Add(pid); err != nil {
+		return err
+	}
+	return h.m.AddProc(pid)
+}
+
+// Stat returns the stats of the v1 controllers, and the stats of the unified
+// hierarchy.
+func (h *hybrid) Stat() (*Stats, error) {
+	s, err := h.legacy.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if s.V2, err = h.m.Stat(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Procs lists the processes of the cgroup in the v1 hierarchies and in the
+// unified hierarchy, where they may have been moved independently.
+func (h *hybrid) Procs(recursive bool) ([]uint64, error) {
+	pids, err := h.legacy.Procs(recursive)
+	if err != nil {
+		return nil, err
+	}
+	procs, err := h.m.Procs(recursive)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, pid := range procs {
+		if !slices.Contains(pids, pid) {
+			pids = append(pids, pid)
+		}
+	}
+	slices.Sort(pids)
+	return pids, nil
+}
+
+// Kill uses cgroup.kill in the unified hierarchy when the kernel provides it,
+// then kills the processes of both hierarchies as the cgroup v1
+// implementation does, as they may not be in the same cgroup in each.
+func (h *hybrid) Kill() error {
+	if _, err := os.Stat(filepath.Join(h.path, "cgroup.kill")); err == nil {
+		if err := h.m.Kill(); err != nil {
+			return err
+		}
+	}
+	return h.legacy.kill(h.Procs)
+}
+
+func (h *hybrid) Delete() error {
+	if err := h.legacy.Delete(); err != nil {
+		return err
+	}
+	// With the Systemd driver, systemd already removed the cgroup from the
+	// unified hierarchy when stopping the unit.
+	if err := h.m.Delete(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
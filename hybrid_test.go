@@ -0,0 +1,119 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/cgroups/v3/cgroup1"
+	"github.com/containerd/cgroups/v3/cgroupstest"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hybridOpts(h *cgroupstest.Hybrid) []InitOpts {
+	return []InitOpts{WithMode(Hybrid), WithHierarchy(h.V1.Hierarchy), WithMountpoint(h.Unified.Root())}
+}
+
+func TestHybridCgroup(t *testing.T) {
+	h, err := cgroupstest.NewHybrid(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, h.Mkdir("/test"))
+
+	shares := uint64(512)
+	cg, err := New("/test", &specs.LinuxResources{CPU: &specs.LinuxCPU{Shares: &shares}}, hybridOpts(h)...)
+	require.NoError(t, err)
+	require.NoError(t, h.Sync())
+	assert.Equal(t, Hybrid, cg.Mode())
+	assert.False(t, cg.(*hybrid).bpfDevices)
+
+	require.NoError(t, cg.Add(42))
+	require.NoError(t, h.Sync())
+	assert.Equal(t, []uint64{42}, h.V1.Procs(cgroup1.Cpu, "/test"))
+	assert.Equal(t, []uint64{42}, h.Unified.Procs("/test"))
+
+	stats, err := cg.Stat()
+	require.NoError(t, err)
+	require.NotNil(t, stats.V1)
+	require.NotNil(t, stats.V2)
+	assert.NotNil(t, stats.V2.CPU.PSI)
+	assert.Equal(t, uint64(1), stats.Summary().Pids.Current)
+
+	err = cg.Update(&specs.LinuxResources{Unified: map[string]string{"memory.high": "max"}})
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	loaded, err := Load("/test", hybridOpts(h)...)
+	require.NoError(t, err)
+	procs, err := loaded.Procs(true)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{42}, procs)
+
+	require.NoError(t, h.Exit(42))
+	require.NoError(t, cg.Delete())
+	require.NoError(t, h.Sync())
+	assert.NoDirExists(t, filepath.Join(h.Unified.Root(), "test"))
+	assert.NoDirExists(t, filepath.Join(h.V1.Root(), "cpu", "test"))
+}
+
+func TestHybridKill(t *testing.T) {
+	h, err := cgroupstest.NewHybrid(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, h.Mkdir("/test"))
+	cg, err := New("/test", nil, hybridOpts(h)...)
+	require.NoError(t, err)
+
+	// Processes moved in one hierarchy only are killed too.
+	var cmds []*exec.Cmd
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command("sleep", "100")
+		require.NoError(t, cmd.Start())
+		cmds = append(cmds, cmd)
+	}
+	require.NoError(t, cg.(*hybrid).legacy.Add(uint64(cmds[0].Process.Pid)))
+	require.NoError(t, cg.(*hybrid).m.AddProc(uint64(cmds[1].Process.Pid)))
+	require.NoError(t, h.Sync())
+
+	require.NoError(t, cg.Kill())
+	for _, cmd := range cmds {
+		var exitErr *exec.ExitError
+		require.ErrorAs(t, cmd.Wait(), &exitErr)
+		assert.Equal(t, "signal: killed", exitErr.Error())
+	}
+}
+
+func TestHybridBPFDevices(t *testing.T) {
+	h, err := cgroupstest.NewHybrid(t.TempDir(), cgroup1.Freezer, cgroup1.Memory)
+	require.NoError(t, err)
+	require.NoError(t, h.Mkdir("/test"))
+
+	cg, err := New("/test", nil, hybridOpts(h)...)
+	require.NoError(t, err)
+	assert.True(t, cg.(*hybrid).bpfDevices)
+}
+
+func TestHybridMountpoint(t *testing.T) {
+	assert.Equal(t, "/sys/fs/cgroup/unified", defaultMountpoint(Hybrid, nil))
+	assert.Equal(t, "/sys/fs/cgroup", defaultMountpoint(Unified, nil))
+	assert.Equal(t, "/run/cgroup2", defaultMountpoint(Hybrid, []Mount{
+		{Mountpoint: "/sys/fs/cgroup/memory", Version: 1, Controllers: []string{"memory"}},
+		{Mountpoint: "/run/cgroup2", Version: 2},
+	}))
+}
@@ -0,0 +1,60 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package memcheck defines the memory check made before lowering a memory
+// limit and its error, shared by the cgroup1 and cgroup2 packages which alias
+// them.
+package memcheck
+
+import "fmt"
+
+// Mode is the check made before lowering the memory limit of a cgroup, which
+// makes the kernel reclaim memory and OOM-kill processes of the cgroup, or
+// fail on cgroup v1, when it cannot reclaim enough.
+type Mode int
+
+const (
+	// None writes the limit without checking the usage.
+	None Mode = iota
+	// Refuse fails when the usage is above the new limit.
+	Refuse
+	// Reclaim reclaims the memory above the new limit first, and fails if
+	// the usage is still above it.
+	Reclaim
+)
+
+// ReclaimAttempts bounds the attempts to reclaim memory, which may reclaim
+// less than requested while the workload keeps allocating.
+const ReclaimAttempts = 5
+
+// LimitError is returned when the memory usage of a cgroup is above the new
+// memory limit.
+type LimitError struct {
+	// Usage is the memory usage, in bytes, after the reclaim if any.
+	Usage uint64
+	// Limit is the requested memory limit.
+	Limit uint64
+	// Reclaimed is the memory reclaimed with Reclaim, in bytes.
+	Reclaimed uint64
+}
+
+func (e *LimitError) Error() string {
+	msg := fmt.Sprintf("cgroups: memory usage %d is above the requested limit %d", e.Usage, e.Limit)
+	if e.Reclaimed > 0 {
+		msg += fmt.Sprintf(" after reclaiming %d", e.Reclaimed)
+	}
+	return msg
+}
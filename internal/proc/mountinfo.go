@@ -0,0 +1,128 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proc
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Mount is a cgroup v1 or v2 mount.
+type Mount struct {
+	// Mountpoint is where the hierarchy is mounted, e.g. /sys/fs/cgroup
+	Mountpoint string
+	// Root is the cgroup of the hierarchy mounted at Mountpoint: "/" unless
+	// a subtree is mounted, e.g. in a container without a cgroup namespace
+	Root string
+	// Version is 1 or 2
+	Version int
+	// Options are the superblock options, e.g. nsdelegate,
+	// memory_recursiveprot, memory_localevents or favordynmods on v2
+	Options []string
+	// Controllers are the subsystems co-mounted in a v1 hierarchy, including
+	// named hierarchies as "name=<name>", as listed in /proc/<pid>/cgroup.
+	// It is empty on v2.
+	Controllers []string
+}
+
+// HasOption returns whether the hierarchy is mounted with the option.
+func (m *Mount) HasOption(option string) bool {
+	return slices.Contains(m.Options, option)
+}
+
+// v1Options are the superblock options of cgroup v1 that are not subsystems.
+var v1Options = []string{"rw", "ro", "xattr", "noprefix", "clone_children", "cpuset_v2_mode", "none", "all"}
+
+// ParseMountInfo returns the cgroup mounts of a /proc/<pid>/mountinfo file,
+// in mount order.
+func ParseMountInfo(path string) ([]Mount, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseMountInfoFromReader(f)
+}
+
+// ParseMountInfoFromReader is like ParseMountInfo, reading from r. Malformed
+// entries are ignored.
+func ParseMountInfoFromReader(r io.Reader) ([]Mount, error) {
+	var (
+		mounts []Mount
+		s      = bufio.NewScanner(r)
+	)
+	for s.Scan() {
+		// format: id parent major:minor root mountpoint options [optional...] - fstype source superoptions
+		text := s.Text()
+		fields := strings.Split(text, " ")
+		sep := slices.Index(fields, "-")
+		if sep < 6 || len(fields) < sep+4 {
+			// Skip malformed entries rather than losing the whole table,
+			// e.g. when a line was truncated.
+			continue
+		}
+		var m Mount
+		switch fields[sep+1] {
+		case "cgroup":
+			m.Version = 1
+		case "cgroup2":
+			m.Version = 2
+		default:
+			continue
+		}
+		m.Root = unescape(fields[3])
+		m.Mountpoint = unescape(fields[4])
+		m.Options = strings.Split(fields[sep+3], ",")
+		if m.Version == 1 {
+			for _, o := range m.Options {
+				if slices.Contains(v1Options, o) || strings.Contains(o, "=") && !strings.HasPrefix(o, "name=") {
+					continue
+				}
+				m.Controllers = append(m.Controllers, o)
+			}
+		}
+		mounts = append(mounts, m)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// unescape decodes the octal escapes of the space, tab, newline and
+// backslash characters in mountinfo paths.
+func unescape(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+4 <= len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mountinfo = `22 1 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+25 22 0:23 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:9 - tmpfs tmpfs ro,mode=755
+26 25 0:24 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime shared:10 - cgroup2 cgroup2 rw,nsdelegate
+27 25 0:25 / /sys/fs/cgroup/systemd rw,nosuid,nodev,noexec,relatime shared:11 - cgroup cgroup rw,xattr,name=systemd
+30 25 0:28 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:14 - cgroup cgroup rw,cpu,cpuacct
+31 25 0:29 /docker/abc /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime - cgroup cgroup rw,memory
+32 25 0:30 / /sys/fs/cgroup/cpuset rw,relatime - cgroup cgroup rw,cpuset,clone_children,release_agent=/bin/true
+40 1 0:31 / /mnt/my\040cgroup rw,relatime - cgroup2 none rw,nsdelegate,memory_recursiveprot,memory_localevents,favordynmods`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := ParseMountInfoFromReader(strings.NewReader(mountinfo))
+	require.NoError(t, err)
+	assert.Equal(t, []Mount{
+		{Mountpoint: "/sys/fs/cgroup/unified", Root: "/", Version: 2, Options: []string{"rw", "nsdelegate"}},
+		{Mountpoint: "/sys/fs/cgroup/systemd", Root: "/", Version: 1, Options: []string{"rw", "xattr", "name=systemd"}, Controllers: []string{"name=systemd"}},
+		{Mountpoint: "/sys/fs/cgroup/cpu,cpuacct", Root: "/", Version: 1, Options: []string{"rw", "cpu", "cpuacct"}, Controllers: []string{"cpu", "cpuacct"}},
+		{Mountpoint: "/sys/fs/cgroup/memory", Root: "/docker/abc", Version: 1, Options: []string{"rw", "memory"}, Controllers: []string{"memory"}},
+		{Mountpoint: "/sys/fs/cgroup/cpuset", Root: "/", Version: 1, Options: []string{"rw", "cpuset", "clone_children", "release_agent=/bin/true"}, Controllers: []string{"cpuset"}},
+		{Mountpoint: "/mnt/my cgroup", Root: "/", Version: 2, Options: []string{"rw", "nsdelegate", "memory_recursiveprot", "memory_localevents", "favordynmods"}},
+	}, mounts)
+	assert.True(t, mounts[5].HasOption("memory_recursiveprot"))
+	assert.False(t, mounts[0].HasOption("memory_recursiveprot"))
+}
+
+func TestParseMountInfoBadEntry(t *testing.T) {
+	mounts, err := ParseMountInfoFromReader(strings.NewReader("26 25 0:24 / /sys/fs/cgroup rw\n" +
+		"27 25 0:25 / /sys/fs/cgroup/unified rw - cgroup2 cgroup2 rw"))
+	require.NoError(t, err)
+	assert.Equal(t, []Mount{{Mountpoint: "/sys/fs/cgroup/unified", Root: "/", Version: 2, Options: []string{"rw"}}}, mounts)
+}
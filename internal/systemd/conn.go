@@ -0,0 +1,237 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package systemd manages the D-Bus connections to systemd shared by the
+// cgroup1 and cgroup2 packages.
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/containerd/log"
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+)
+
+// Dialer opens a new connection to systemd.
+type Dialer func(ctx context.Context) (*systemdDbus.Conn, error)
+
+// Conn is a connection to systemd shared between callers. It is opened on
+// first use, and transparently reopened when systemd closes it, e.g. after a
+// re-exec.
+type Conn struct {
+	dial Dialer
+
+	mu   sync.Mutex
+	conn *systemdDbus.Conn
+	// version is the systemd version, 0 until it has been fetched.
+	version int
+}
+
+// New returns a Conn using dial to connect to systemd.
+func New(dial Dialer) *Conn {
+	return &Conn{dial: dial}
+}
+
+var (
+	systemOnce sync.Once
+	system     *Conn
+	userOnce   sync.Once
+	user       *Conn
+)
+
+// System returns the connection to the systemd system instance shared by the
+// whole process.
+func System() *Conn {
+	systemOnce.Do(func() {
+		system = New(DialSystem)
+	})
+	return system
+}
+
+// User returns the connection to the systemd user instance of the current
+// user shared by the whole process.
+func User() *Conn {
+	userOnce.Do(func() {
+		user = New(systemdDbus.NewUserConnectionContext)
+	})
+	return user
+}
+
+// Default returns System, or User if user is true.
+func Default(user bool) *Conn {
+	if user {
+		return User()
+	}
+	return System()
+}
+
+// DialSystem connects to the systemd system instance through the system bus,
+// falling back to the private systemd socket, /run/systemd/private, when the
+// bus is not available.
+func DialSystem(ctx context.Context) (*systemdDbus.Conn, error) {
+	conn, err := systemdDbus.NewWithContext(ctx)
+	if err == nil {
+		return conn, nil
+	}
+	conn, privErr := systemdDbus.NewSystemdConnectionContext(ctx)
+	if privErr != nil {
+		return nil, fmt.Errorf("connecting to systemd: %w (private socket: %v)", err, privErr)
+	}
+	log.G(ctx).WithError(err).Debug("Connected to systemd through its private socket")
+	return conn, nil
+}
+
+// Dial opens a dedicated connection, for uses that need to own it, such as
+// signal subscriptions. The caller must close it.
+func (c *Conn) Dial(ctx context.Context) (*systemdDbus.Conn, error) {
+	return c.dial(ctx)
+}
+
+func (c *Conn) get(ctx context.Context) (*systemdDbus.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil && c.conn.Connected() {
+		return c.conn, nil
+	}
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	// The connection outlives the caller, do not let go-systemd close it
+	// when ctx is canceled.
+	conn, err := c.dial(context.WithoutCancel(ctx))
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	c.version = 0
+	return conn, nil
+}
+
+// reset drops conn if it is still the current connection.
+func (c *Conn) reset(conn *systemdDbus.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == conn {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Do calls fn with the shared connection. If the connection turns out to be
+// closed, fn is retried once with a new connection.
+func (c *Conn) Do(ctx context.Context, fn func(*systemdDbus.Conn) error) error {
+	for retried := false; ; retried = true {
+		conn, err := c.get(ctx)
+		if err != nil {
+			return err
+		}
+		err = fn(conn)
+		if retried || !isDisconnected(err) {
+			return err
+		}
+		log.G(ctx).WithError(err).Debug("Reconnecting to systemd")
+		c.reset(conn)
+	}
+}
+
+// Version returns the version of systemd, or -1 if it cannot be determined.
+// It is cached until the connection is reopened, as systemd may have been
+// upgraded in the meantime.
+func (c *Conn) Version(ctx context.Context) int {
+	c.mu.Lock()
+	version := c.version
+	c.mu.Unlock()
+	if version != 0 {
+		return version
+	}
+
+	version = -1
+	err := c.Do(ctx, func(conn *systemdDbus.Conn) error {
+		verStr, err := conn.GetManagerProperty("Version")
+		if err != nil {
+			return err
+		}
+		version, err = VersionAtoi(verStr)
+		return err
+	})
+	if err != nil {
+		log.G(ctx).WithError(err).Error("Unable to get systemd version")
+		return -1
+	}
+	c.mu.Lock()
+	c.version = version
+	c.mu.Unlock()
+	return version
+}
+
+// Close closes the current connection, if any. The Conn can still be used
+// afterwards and will reconnect.
+func (c *Conn) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// isDisconnected returns true if err means that the connection to systemd
+// has been closed.
+func isDisconnected(err error) bool {
+	if err == nil {
+		return false
+	}
+	// The write to a socket closed by systemd may fail before godbus notices
+	// the connection is gone.
+	if errors.Is(err, dbus.ErrClosed) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var dbusError dbus.Error
+	if errors.As(err, &dbusError) {
+		return dbusError.Name == "org.freedesktop.DBus.Error.Disconnected"
+	}
+	return false
+}
+
+// VersionAtoi parses the systemd version reported by the Version property
+// of its manager, e.g. "v245.4-1ubuntu3" or "252".
+//
+// Adapted from https://github.com/opencontainers/cgroups/blob/9657f5a18b8d60a0f39fbb34d0cb7771e28e6278/systemd/common.go#L245-L281
+func VersionAtoi(str string) (int, error) {
+	// Unconditionally remove the leading prefix ("v).
+	str = strings.TrimLeft(str, `"v`)
+	// Match on the first integer we can grab.
+	for i := range len(str) {
+		if str[i] < '0' || str[i] > '9' {
+			// First non-digit: cut the tail.
+			str = str[:i]
+			break
+		}
+	}
+	ver, err := strconv.Atoi(str)
+	if err != nil {
+		return -1, fmt.Errorf("can't parse version: %w", err)
+	}
+	return ver, nil
+}
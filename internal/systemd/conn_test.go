@@ -0,0 +1,84 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionAtoi(t *testing.T) {
+	for in, want := range map[string]int{
+		`"v245.4-1ubuntu3"`:  245,
+		"252":                252,
+		`"239 (239-41.el8)"`: 239,
+	} {
+		got, err := VersionAtoi(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := VersionAtoi("unknown")
+	assert.Error(t, err)
+}
+
+func TestIsDisconnected(t *testing.T) {
+	assert.False(t, isDisconnected(nil))
+	assert.True(t, isDisconnected(dbus.ErrClosed))
+	assert.True(t, isDisconnected(fmt.Errorf("wrapped: %w", dbus.ErrClosed)))
+	assert.True(t, isDisconnected(dbus.Error{Name: "org.freedesktop.DBus.Error.Disconnected"}))
+	assert.True(t, isDisconnected(&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}))
+	assert.False(t, isDisconnected(dbus.Error{Name: "org.freedesktop.systemd1.UnitExists"}))
+	assert.False(t, isDisconnected(errors.New("other")))
+}
+
+func TestConnDialError(t *testing.T) {
+	dialErr := errors.New("no systemd")
+	dials := 0
+	c := New(func(context.Context) (*systemdDbus.Conn, error) {
+		dials++
+		return nil, dialErr
+	})
+
+	called := false
+	err := c.Do(context.Background(), func(*systemdDbus.Conn) error {
+		called = true
+		return nil
+	})
+	assert.ErrorIs(t, err, dialErr)
+	assert.False(t, called)
+
+	assert.Equal(t, -1, c.Version(context.Background()))
+	// Failing connections are retried on the next use.
+	assert.Equal(t, 2, dials)
+	c.Close()
+}
+
+func TestDefault(t *testing.T) {
+	assert.Same(t, System(), Default(false))
+	assert.Same(t, User(), Default(true))
+	assert.NotSame(t, System(), User())
+}
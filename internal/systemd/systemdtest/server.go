@@ -0,0 +1,809 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package systemdtest provides an in-process fake of the systemd D-Bus API,
+// implementing the subset of org.freedesktop.systemd1.Manager used by the
+// cgroup1 and cgroup2 packages. Units are backed by directories in a
+// temporary cgroup tree instead of real cgroups.
+package systemdtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	managerPath      = dbus.ObjectPath("/org/freedesktop/systemd1")
+	unitPathPrefix   = "/org/freedesktop/systemd1/unit/"
+	managerInterface = "org.freedesktop.systemd1.Manager"
+	unitInterface    = "org.freedesktop.systemd1.Unit"
+	propsInterface   = "org.freedesktop.DBus.Properties"
+	busInterface     = "org.freedesktop.DBus"
+
+	// DefaultVersion is the systemd version reported by default.
+	DefaultVersion = 252
+)
+
+// Unit is the state of a unit known to the fake systemd.
+type Unit struct {
+	Name string
+	// ActiveState and SubState are the state of the unit, e.g. "active" and
+	// "running".
+	ActiveState string
+	SubState    string
+	// Result is the result reported for services and scopes, e.g. "success".
+	Result string
+	// ControlGroup is the cgroup of the unit, relative to the cgroup root.
+	ControlGroup string
+	// Properties holds the properties the unit was created or updated with.
+	Properties map[string]dbus.Variant
+}
+
+// Server is a fake systemd listening on a private D-Bus socket, as systemd
+// does on /run/systemd/private.
+type Server struct {
+	cgroupRoot string
+	socket     string
+	listener   net.Listener
+	wg         sync.WaitGroup
+
+	mu         sync.Mutex
+	version    int
+	units      map[string]*Unit
+	jobResults map[string]string
+	calls      map[string]int
+	conns      map[*peer]struct{}
+	jobs       uint32
+	closed     bool
+}
+
+// NewServer starts a fake systemd managing units in cgroupRoot. The socket
+// is created in dir.
+func NewServer(dir, cgroupRoot string) (*Server, error) {
+	socket := filepath.Join(dir, "systemd-private")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		cgroupRoot: cgroupRoot,
+		socket:     socket,
+		listener:   l,
+		version:    DefaultVersion,
+		units:      make(map[string]*Unit),
+		jobResults: make(map[string]string),
+		calls:      make(map[string]int),
+		conns:      make(map[*peer]struct{}),
+	}
+	for _, slice := range []string{"-.slice", "system.slice"} {
+		if _, err := s.addUnit(slice, nil); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Dial connects to the fake systemd. It can be used as a systemd dialer, e.g.
+// with cgroup2.WithSystemdDialer.
+func (s *Server) Dial(ctx context.Context) (*systemdDbus.Conn, error) {
+	return systemdDbus.NewConnection(func() (*dbus.Conn, error) {
+		conn, err := dbus.Dial("unix:path="+s.socket, dbus.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.Auth([]dbus.Auth{dbus.AuthExternal(strconv.Itoa(os.Getuid()))}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	})
+}
+
+// Close stops the server and closes all the connections to it, as a
+// restarting systemd would.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	err := s.listener.Close()
+	for p := range s.conns {
+		p.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// DisconnectAll closes all the current connections, as systemd does when it
+// re-executes itself.
+func (s *Server) DisconnectAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.conns {
+		p.conn.Close()
+	}
+}
+
+// SetVersion sets the systemd version reported by the server.
+func (s *Server) SetVersion(version int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+}
+
+// SetJobResult makes the next job for unit complete with result, e.g.
+// "failed", "timeout" or "dependency", instead of "done".
+func (s *Server) SetJobResult(unit, result string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobResults[unit] = result
+}
+
+// SetUnitState changes the state of unit and notifies subscribers, e.g. to
+// simulate a unit killed by the OOM killer.
+func (s *Server) SetUnitState(name, activeState, subState, result string) error {
+	s.mu.Lock()
+	u, ok := s.units[name]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("no such unit %s", name)
+	}
+	u.ActiveState, u.SubState, u.Result = activeState, subState, result
+	s.mu.Unlock()
+	s.emitStateChanged(name, activeState, subState)
+	return nil
+}
+
+// Calls returns the number of calls to the manager method, e.g.
+// "StartTransientUnit".
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// Unit returns a copy of the state of the unit name.
+func (s *Server) Unit(name string) (Unit, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.units[name]
+	if !ok {
+		return Unit{}, false
+	}
+	c := *u
+	c.Properties = make(map[string]dbus.Variant, len(u.Properties))
+	for k, v := range u.Properties {
+		c.Properties[k] = v
+	}
+	return c, true
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		p := &peer{conn: c}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[p] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, p)
+				s.mu.Unlock()
+				c.Close()
+			}()
+			s.handleConn(p)
+		}()
+	}
+}
+
+// peer is a client connection.
+type peer struct {
+	conn   net.Conn
+	mu     sync.Mutex
+	serial atomic.Uint32
+
+	matchMu sync.Mutex
+	matches []map[string]string
+}
+
+// addMatch records a match rule, e.g.
+// "type='signal',interface='org.freedesktop.systemd1.Manager',member='JobRemoved'".
+func (p *peer) addMatch(rule string) {
+	m := make(map[string]string)
+	for _, kv := range strings.Split(rule, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		m[strings.TrimSpace(k)] = strings.Trim(v, "'")
+	}
+	p.matchMu.Lock()
+	defer p.matchMu.Unlock()
+	p.matches = append(p.matches, m)
+}
+
+// wants reports whether the peer added a match rule for the signal. Only the
+// interface, member and path keys are supported.
+func (p *peer) wants(path dbus.ObjectPath, iface, member string) bool {
+	p.matchMu.Lock()
+	defer p.matchMu.Unlock()
+	for _, m := range p.matches {
+		if v, ok := m["interface"]; ok && v != iface {
+			continue
+		}
+		if v, ok := m["member"]; ok && v != member {
+			continue
+		}
+		if v, ok := m["path"]; ok && v != string(path) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// send writes msg to the peer. godbus does not allow setting the serial of a
+// message, so it is patched into the encoded header.
+func (p *peer) send(msg *dbus.Message) error {
+	var buf bytes.Buffer
+	if err := msg.EncodeTo(&buf, binary.LittleEndian); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	binary.LittleEndian.PutUint32(b[8:12], p.serial.Add(1))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.conn.Write(b)
+	return err
+}
+
+// handshake performs the server side of the D-Bus SASL authentication,
+// accepting any client.
+func handshake(r *bufio.Reader, w net.Conn) error {
+	if b, err := r.ReadByte(); err != nil {
+		return err
+	} else if b != 0 {
+		return errors.New("expected nul byte")
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var reply string
+		switch fields[0] {
+		case "AUTH":
+			if len(fields) == 1 {
+				reply = "REJECTED EXTERNAL"
+			} else {
+				reply = "OK 0123456789abcdef0123456789abcdef"
+			}
+		case "DATA":
+			reply = "OK 0123456789abcdef0123456789abcdef"
+		case "NEGOTIATE_UNIX_FD":
+			reply = "ERROR"
+		case "BEGIN":
+			return nil
+		default:
+			reply = "ERROR"
+		}
+		if _, err := w.Write([]byte(reply + "\r\n")); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handleConn(p *peer) {
+	r := bufio.NewReader(p.conn)
+	if err := handshake(r, p.conn); err != nil {
+		return
+	}
+	for {
+		msg, err := dbus.DecodeMessage(r)
+		if err != nil {
+			return
+		}
+		if msg.Type != dbus.TypeMethodCall {
+			continue
+		}
+		body, after, err := s.handleCall(p, msg)
+		if msg.Flags&dbus.FlagNoReplyExpected == 0 {
+			var reply *dbus.Message
+			if err != nil {
+				reply = errorReply(msg, err)
+			} else {
+				reply = methodReply(msg, body...)
+			}
+			if p.send(reply) != nil {
+				return
+			}
+		}
+		if after != nil {
+			after()
+		}
+	}
+}
+
+func methodReply(call *dbus.Message, body ...interface{}) *dbus.Message {
+	msg := &dbus.Message{
+		Type: dbus.TypeMethodReply,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldReplySerial: dbus.MakeVariant(call.Serial()),
+		},
+		Body: body,
+	}
+	if len(body) > 0 {
+		msg.Headers[dbus.FieldSignature] = dbus.MakeVariant(dbus.SignatureOf(body...))
+	}
+	return msg
+}
+
+func errorReply(call *dbus.Message, err error) *dbus.Message {
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) {
+		dbusErr = dbus.Error{Name: "org.freedesktop.DBus.Error.Failed", Body: []interface{}{err.Error()}}
+	}
+	return &dbus.Message{
+		Type: dbus.TypeError,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldReplySerial: dbus.MakeVariant(call.Serial()),
+			dbus.FieldErrorName:   dbus.MakeVariant(dbusErr.Name),
+			dbus.FieldSignature:   dbus.MakeVariant(dbus.SignatureOf(dbusErr.Body...)),
+		},
+		Body: dbusErr.Body,
+	}
+}
+
+func newError(name, format string, args ...interface{}) error {
+	return dbus.Error{Name: name, Body: []interface{}{fmt.Sprintf(format, args...)}}
+}
+
+func errNoSuchUnit(name string) error {
+	return newError("org.freedesktop.systemd1.NoSuchUnit", "Unit %s not loaded.", name)
+}
+
+// emit sends a signal to the connected peers with a matching rule.
+func (s *Server) emit(path dbus.ObjectPath, iface, member string, body ...interface{}) {
+	msg := &dbus.Message{
+		Type: dbus.TypeSignal,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldPath:      dbus.MakeVariant(path),
+			dbus.FieldInterface: dbus.MakeVariant(iface),
+			dbus.FieldMember:    dbus.MakeVariant(member),
+			dbus.FieldSignature: dbus.MakeVariant(dbus.SignatureOf(body...)),
+		},
+		Body: body,
+	}
+	s.mu.Lock()
+	peers := make([]*peer, 0, len(s.conns))
+	for p := range s.conns {
+		if p.wants(path, iface, member) {
+			peers = append(peers, p)
+		}
+	}
+	s.mu.Unlock()
+	for _, p := range peers {
+		_ = p.send(msg)
+	}
+}
+
+func (s *Server) emitStateChanged(name, activeState, subState string) {
+	s.emit(unitPath(name), propsInterface, "PropertiesChanged", unitInterface, map[string]dbus.Variant{
+		"ActiveState": dbus.MakeVariant(activeState),
+		"SubState":    dbus.MakeVariant(subState),
+	}, []string{})
+}
+
+// handleCall handles a method call. The returned function, if any, is called
+// once the reply has been sent, to emit signals.
+func (s *Server) handleCall(p *peer, msg *dbus.Message) ([]interface{}, func(), error) {
+	path, _ := msg.Headers[dbus.FieldPath].Value().(dbus.ObjectPath)
+	iface, _ := msg.Headers[dbus.FieldInterface].Value().(string)
+	member, _ := msg.Headers[dbus.FieldMember].Value().(string)
+
+	switch iface {
+	case busInterface:
+		if member == "AddMatch" {
+			var rule string
+			if err := dbus.Store(msg.Body, &rule); err != nil {
+				return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "%v", err)
+			}
+			p.addMatch(rule)
+		}
+		return nil, nil, nil
+	case propsInterface:
+		return s.handleProperties(path, member, msg.Body)
+	case managerInterface:
+		if path != managerPath {
+			break
+		}
+		return s.handleManager(member, msg.Body)
+	}
+	return nil, nil, newError("org.freedesktop.DBus.Error.UnknownMethod", "Unknown method %s.%s", iface, member)
+}
+
+type property struct {
+	Name  string
+	Value dbus.Variant
+}
+
+type auxUnit struct {
+	Name       string
+	Properties []property
+}
+
+var validJobModes = map[string]bool{
+	"replace": true, "fail": true, "isolate": true, "ignore-dependencies": true, "ignore-requirements": true,
+}
+
+func (s *Server) handleManager(member string, body []interface{}) ([]interface{}, func(), error) {
+	s.mu.Lock()
+	s.calls[member]++
+	s.mu.Unlock()
+	switch member {
+	case "StartTransientUnit":
+		var (
+			name, mode string
+			props      []property
+			aux        []auxUnit
+		)
+		if err := dbus.Store(body, &name, &mode, &props, &aux); err != nil {
+			return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "%v", err)
+		}
+		if !validJobModes[mode] {
+			return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "Job mode %s invalid", mode)
+		}
+		s.mu.Lock()
+		_, exists := s.units[name]
+		s.mu.Unlock()
+		if exists {
+			return nil, nil, newError("org.freedesktop.systemd1.UnitExists", "Unit %s already exists.", name)
+		}
+		u, err := s.addUnit(name, props)
+		if err != nil {
+			return nil, nil, err
+		}
+		job, done := s.newJob(name, func(result string) {
+			if result != "done" {
+				s.mu.Lock()
+				u.ActiveState, u.SubState = "failed", "failed"
+				s.mu.Unlock()
+			}
+			s.emitStateChanged(name, u.ActiveState, u.SubState)
+		})
+		return []interface{}{job}, done, nil
+	case "StopUnit":
+		var name, mode string
+		if err := dbus.Store(body, &name, &mode); err != nil {
+			return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "%v", err)
+		}
+		if !validJobModes[mode] {
+			return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "Job mode %s invalid", mode)
+		}
+		s.mu.Lock()
+		u, ok := s.units[name]
+		s.mu.Unlock()
+		if !ok {
+			return nil, nil, errNoSuchUnit(name)
+		}
+		job, done := s.newJob(name, func(string) {
+			s.mu.Lock()
+			delete(s.units, name)
+			s.mu.Unlock()
+			_ = os.RemoveAll(filepath.Join(s.cgroupRoot, u.ControlGroup))
+			s.emitStateChanged(name, "inactive", "dead")
+		})
+		return []interface{}{job}, done, nil
+	case "ResetFailedUnit":
+		var name string
+		if err := dbus.Store(body, &name); err != nil {
+			return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "%v", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		u, ok := s.units[name]
+		if !ok {
+			return nil, nil, errNoSuchUnit(name)
+		}
+		if u.ActiveState == "failed" {
+			delete(s.units, name)
+			_ = os.RemoveAll(filepath.Join(s.cgroupRoot, u.ControlGroup))
+		}
+		return nil, nil, nil
+	case "SetUnitProperties":
+		var (
+			name    string
+			runtime bool
+			props   []property
+		)
+		if err := dbus.Store(body, &name, &runtime, &props); err != nil {
+			return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "%v", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		u, ok := s.units[name]
+		if !ok {
+			return nil, nil, errNoSuchUnit(name)
+		}
+		for _, p := range props {
+			u.Properties[p.Name] = p.Value
+		}
+		return nil, nil, nil
+	case "KillUnit":
+		var (
+			name, who string
+			signal    int32
+		)
+		if err := dbus.Store(body, &name, &who, &signal); err != nil {
+			return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "%v", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.units[name]; !ok {
+			return nil, nil, errNoSuchUnit(name)
+		}
+		return nil, nil, nil
+	case "FreezeUnit", "ThawUnit":
+		var name string
+		if err := dbus.Store(body, &name); err != nil {
+			return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "%v", err)
+		}
+		s.mu.Lock()
+		u, ok := s.units[name]
+		s.mu.Unlock()
+		if !ok {
+			return nil, nil, errNoSuchUnit(name)
+		}
+		state := "0"
+		if member == "FreezeUnit" {
+			state = "1"
+		}
+		if err := os.WriteFile(filepath.Join(s.cgroupRoot, u.ControlGroup, "cgroup.freeze"), []byte(state), 0o644); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, nil
+	case "GetUnit":
+		var name string
+		if err := dbus.Store(body, &name); err != nil {
+			return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "%v", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.units[name]; !ok {
+			return nil, nil, errNoSuchUnit(name)
+		}
+		return []interface{}{unitPath(name)}, nil, nil
+	case "Subscribe", "Unsubscribe":
+		return nil, nil, nil
+	}
+	return nil, nil, newError("org.freedesktop.DBus.Error.UnknownMethod", "Unknown method %s", member)
+}
+
+// newJob allocates a job for unit. The returned function completes the job
+// with the configured result, calls complete and emits JobRemoved.
+func (s *Server) newJob(unit string, complete func(result string)) (dbus.ObjectPath, func()) {
+	s.mu.Lock()
+	s.jobs++
+	id := s.jobs
+	result, ok := s.jobResults[unit]
+	if ok {
+		delete(s.jobResults, unit)
+	} else {
+		result = "done"
+	}
+	s.mu.Unlock()
+	job := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/systemd1/job/%d", id))
+	return job, func() {
+		complete(result)
+		s.emit(managerPath, managerInterface, "JobRemoved", id, job, unit, result)
+	}
+}
+
+// addUnit registers a unit and creates its cgroup.
+func (s *Server) addUnit(name string, props []property) (*Unit, error) {
+	u := &Unit{
+		Name:        name,
+		ActiveState: "active",
+		SubState:    "running",
+		Result:      "success",
+		Properties:  make(map[string]dbus.Variant, len(props)),
+	}
+	for _, p := range props {
+		u.Properties[p.Name] = p.Value
+	}
+	if strings.HasSuffix(name, ".slice") {
+		u.SubState = "active"
+		u.ControlGroup = "/" + expandSlice(name)
+	} else {
+		slice := "system.slice"
+		if v, ok := u.Properties["Slice"]; ok {
+			slice, _ = v.Value().(string)
+		}
+		u.ControlGroup = "/" + filepath.Join(expandSlice(slice), name)
+	}
+	dir := filepath.Join(s.cgroupRoot, u.ControlGroup)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if v, ok := u.Properties["PIDs"]; ok {
+		pids, _ := v.Value().([]uint32)
+		var procs []byte
+		for _, pid := range pids {
+			procs = strconv.AppendUint(procs, uint64(pid), 10)
+			procs = append(procs, '\n')
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), procs, 0o644); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	s.units[name] = u
+	s.mu.Unlock()
+	return u, nil
+}
+
+// expandSlice returns the cgroup path of a slice, e.g. "a.slice/a-b.slice"
+// for "a-b.slice".
+func expandSlice(slice string) string {
+	name := strings.TrimSuffix(slice, ".slice")
+	if name == "-" || name == "" {
+		return ""
+	}
+	var path, prefix string
+	for _, component := range strings.Split(name, "-") {
+		path = filepath.Join(path, prefix+component+".slice")
+		prefix += component + "-"
+	}
+	return path
+}
+
+// unitPath returns the D-Bus object path of a unit.
+func unitPath(name string) dbus.ObjectPath {
+	return dbus.ObjectPath(unitPathPrefix + systemdDbus.PathBusEscape(name))
+}
+
+// unitName returns the unit name of a D-Bus object path, reverting
+// systemdDbus.PathBusEscape.
+func unitName(path dbus.ObjectPath) (string, bool) {
+	escaped, ok := strings.CutPrefix(string(path), unitPathPrefix)
+	if !ok {
+		return "", false
+	}
+	if escaped == "_" {
+		return "", true
+	}
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '_' && i+2 < len(escaped) {
+			if c, err := strconv.ParseUint(escaped[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(escaped[i])
+	}
+	return b.String(), true
+}
+
+func (s *Server) handleProperties(path dbus.ObjectPath, member string, body []interface{}) ([]interface{}, func(), error) {
+	var iface, name string
+	switch member {
+	case "Get":
+		if err := dbus.Store(body, &iface, &name); err != nil {
+			return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "%v", err)
+		}
+	case "GetAll":
+		if err := dbus.Store(body, &iface); err != nil {
+			return nil, nil, newError("org.freedesktop.DBus.Error.InvalidArgs", "%v", err)
+		}
+	default:
+		return nil, nil, newError("org.freedesktop.DBus.Error.UnknownMethod", "Unknown method %s", member)
+	}
+
+	props, err := s.properties(path, iface)
+	if err != nil {
+		return nil, nil, err
+	}
+	if member == "GetAll" {
+		return []interface{}{props}, nil, nil
+	}
+	v, ok := props[name]
+	if !ok {
+		return nil, nil, newError("org.freedesktop.DBus.Error.UnknownProperty", "Unknown property %s", name)
+	}
+	return []interface{}{v}, nil, nil
+}
+
+// properties returns the properties of the interface iface of the object path.
+func (s *Server) properties(path dbus.ObjectPath, iface string) (map[string]dbus.Variant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if path == managerPath {
+		if iface != managerInterface {
+			return nil, newError("org.freedesktop.DBus.Error.UnknownInterface", "Unknown interface %s", iface)
+		}
+		return map[string]dbus.Variant{
+			"Version":      dbus.MakeVariant(fmt.Sprintf("v%d", s.version)),
+			"ControlGroup": dbus.MakeVariant(""),
+		}, nil
+	}
+	name, ok := unitName(path)
+	if !ok {
+		return nil, newError("org.freedesktop.DBus.Error.UnknownObject", "Unknown object %s", path)
+	}
+	// Like systemd, report unknown units as not loaded rather than failing.
+	u, ok := s.units[name]
+	if !ok {
+		u = &Unit{Name: name, ActiveState: "inactive", SubState: "dead"}
+	}
+	props := make(map[string]dbus.Variant)
+	switch iface {
+	case unitInterface:
+		loadState := "loaded"
+		if !ok {
+			loadState = "not-found"
+		}
+		props["Id"] = dbus.MakeVariant(u.Name)
+		props["LoadState"] = dbus.MakeVariant(loadState)
+		props["ActiveState"] = dbus.MakeVariant(u.ActiveState)
+		props["SubState"] = dbus.MakeVariant(u.SubState)
+	case "org.freedesktop.systemd1.Service", "org.freedesktop.systemd1.Scope", "org.freedesktop.systemd1.Slice":
+		for k, v := range u.Properties {
+			props[k] = v
+		}
+		props["ControlGroup"] = dbus.MakeVariant(u.ControlGroup)
+		if iface != "org.freedesktop.systemd1.Slice" {
+			props["Result"] = dbus.MakeVariant(u.Result)
+		}
+		if iface == "org.freedesktop.systemd1.Service" {
+			props["ExecMainStatus"] = dbus.MakeVariant(int32(0))
+		}
+	default:
+		return nil, newError("org.freedesktop.DBus.Error.UnknownInterface", "Unknown interface %s", iface)
+	}
+	return props, nil
+}
@@ -0,0 +1,160 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package systemdtest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/cgroups/v3/internal/systemd"
+
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	root := t.TempDir()
+	srv, err := NewServer(t.TempDir(), root)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return srv, root
+}
+
+func TestServerVersion(t *testing.T) {
+	srv, _ := newServer(t)
+	srv.SetVersion(245)
+	sd := systemd.New(srv.Dial)
+	defer sd.Close()
+	assert.Equal(t, 245, sd.Version(context.Background()))
+}
+
+func TestServerStartStopUnit(t *testing.T) {
+	srv, root := newServer(t)
+	ctx := context.Background()
+	conn, err := srv.Dial(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	props := []systemdDbus.Property{
+		systemdDbus.PropSlice("system.slice"),
+		systemdDbus.PropPids(uint32(os.Getpid())),
+	}
+	ch := make(chan string, 1)
+	_, err = conn.StartTransientUnitContext(ctx, "test.scope", "replace", props, ch)
+	require.NoError(t, err)
+	assert.Equal(t, "done", <-ch)
+
+	u, ok := srv.Unit("test.scope")
+	require.True(t, ok)
+	assert.Equal(t, "/system.slice/test.scope", u.ControlGroup)
+	assert.Equal(t, "active", u.ActiveState)
+	procs, err := os.ReadFile(filepath.Join(root, "system.slice/test.scope/cgroup.procs"))
+	require.NoError(t, err)
+	assert.Contains(t, string(procs), "\n")
+
+	cg, err := conn.GetUnitTypePropertyContext(ctx, "test.scope", "Scope", "ControlGroup")
+	require.NoError(t, err)
+	assert.Equal(t, "/system.slice/test.scope", cg.Value.Value())
+
+	_, err = conn.StartTransientUnitContext(ctx, "test.scope", "replace", props, nil)
+	assert.ErrorContains(t, err, "already exists")
+	_, err = conn.StartTransientUnitContext(ctx, "other.scope", "testdelegate", nil, nil)
+	assert.Error(t, err)
+
+	_, err = conn.StopUnitContext(ctx, "test.scope", "replace", ch)
+	require.NoError(t, err)
+	assert.Equal(t, "done", <-ch)
+	_, ok = srv.Unit("test.scope")
+	assert.False(t, ok)
+	assert.NoDirExists(t, filepath.Join(root, "system.slice/test.scope"))
+
+	_, err = conn.StopUnitContext(ctx, "test.scope", "replace", ch)
+	assert.ErrorContains(t, err, "not loaded")
+}
+
+func TestServerNestedSlice(t *testing.T) {
+	srv, root := newServer(t)
+	ctx := context.Background()
+	conn, err := srv.Dial(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ch := make(chan string, 1)
+	_, err = conn.StartTransientUnitContext(ctx, "a-b.slice", "replace", nil, ch)
+	require.NoError(t, err)
+	<-ch
+	assert.DirExists(t, filepath.Join(root, "a.slice/a-b.slice"))
+}
+
+func TestServerJobResult(t *testing.T) {
+	srv, _ := newServer(t)
+	ctx := context.Background()
+	conn, err := srv.Dial(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	srv.SetJobResult("test.scope", "failed")
+	ch := make(chan string, 1)
+	_, err = conn.StartTransientUnitContext(ctx, "test.scope", "replace", nil, ch)
+	require.NoError(t, err)
+	assert.Equal(t, "failed", <-ch)
+	u, ok := srv.Unit("test.scope")
+	require.True(t, ok)
+	assert.Equal(t, "failed", u.ActiveState)
+
+	require.NoError(t, conn.ResetFailedUnitContext(ctx, "test.scope"))
+	_, ok = srv.Unit("test.scope")
+	assert.False(t, ok)
+}
+
+func TestServerSetUnitProperties(t *testing.T) {
+	srv, _ := newServer(t)
+	ctx := context.Background()
+	conn, err := srv.Dial(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	err = conn.SetUnitPropertiesContext(ctx, "system.slice", true, systemdDbus.Property{
+		Name:  "TasksMax",
+		Value: systemdDbus.PropPids(1).Value,
+	})
+	require.NoError(t, err)
+	u, _ := srv.Unit("system.slice")
+	assert.Contains(t, u.Properties, "TasksMax")
+
+	err = conn.SetUnitPropertiesContext(ctx, "missing.slice", true)
+	assert.Error(t, err)
+}
+
+func TestServerReconnect(t *testing.T) {
+	srv, _ := newServer(t)
+	sd := systemd.New(srv.Dial)
+	defer sd.Close()
+	ctx := context.Background()
+
+	require.Equal(t, DefaultVersion, sd.Version(ctx))
+	srv.DisconnectAll()
+	err := sd.Do(ctx, func(conn *systemdDbus.Conn) error {
+		_, err := conn.GetManagerProperty("Version")
+		return err
+	})
+	assert.NoError(t, err)
+}
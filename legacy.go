@@ -0,0 +1,204 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"encoding/binary"
+	"os"
+	"slices"
+
+	"github.com/containerd/cgroups/v3/cgroup1"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// legacy is a Cgroup managed with the cgroup1 package.
+type legacy struct {
+	cg   cgroup1.Cgroup
+	mode CGMode
+}
+
+func legacyOpts(c *InitConfig) []cgroup1.InitOpts {
+	h := c.hierarchy
+	if h == nil {
+		h = cgroup1.Default
+		if c.driver == Systemd {
+			h = cgroup1.Systemd
+		}
+	}
+	return []cgroup1.InitOpts{cgroup1.WithHierarchy(h)}
+}
+
+func legacyPath(c *InitConfig, path string) cgroup1.Path {
+	if c.driver == Systemd {
+		return cgroup1.Slice(splitUnit(path))
+	}
+	return cgroup1.StaticPath(path)
+}
+
+func newLegacy(c *InitConfig, path string, resources *specs.LinuxResources) (Cgroup, error) {
+	if err := checkLegacyResources(resources, c.mode); err != nil {
+		return nil, err
+	}
+	cg, err := cgroup1.New(legacyPath(c, path), resources, legacyOpts(c)...)
+	if err != nil {
+		return nil, err
+	}
+	return &legacy{cg: cg, mode: c.mode}, nil
+}
+
+func loadLegacy(c *InitConfig, path string) (Cgroup, error) {
+	cg, err := cgroup1.Load(legacyPath(c, path), legacyOpts(c)...)
+	if err != nil {
+		return nil, err
+	}
+	return &legacy{cg: cg, mode: c.mode}, nil
+}
+
+// checkLegacyResources reports the resources that only exist in cgroup v2.
+func checkLegacyResources(resources *specs.LinuxResources, mode CGMode) error {
+	if len(resources.Unified) > 0 {
+		return &UnsupportedError{Feature: "unified resources", Mode: mode}
+	}
+	return nil
+}
+
+func (l *legacy) Mode() CGMode {
+	return l.mode
+}
+
+func (l *legacy) Update(resources *specs.LinuxResources) error {
+	if err := checkLegacyResources(resources, l.mode); err != nil {
+		return err
+	}
+	return l.cg.Update(resources)
+}
+
+func (l *legacy) Add(pid uint64) error {
+	return l.cg.AddProc(pid)
+}
+
+func (l *legacy) Procs(recursive bool) ([]uint64, error) {
+	var pids []uint64
+	// Processes may be in different cgroups in each hierarchy, list them all.
+	for _, s := range l.cg.Subsystems() {
+		procs, err := l.cg.Processes(s.Name(), recursive)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, p := range procs {
+			if !slices.Contains(pids, uint64(p.Pid)) {
+				pids = append(pids, uint64(p.Pid))
+			}
+		}
+	}
+	slices.Sort(pids)
+	return pids, nil
+}
+
+func (l *legacy) Stat() (*Stats, error) {
+	m, err := l.cg.Stat(cgroup1.IgnoreNotExist)
+	if err != nil {
+		return nil, err
+	}
+	return &Stats{V1: m}, nil
+}
+
+func (l *legacy) Freeze() error {
+	return l.cg.Freeze()
+}
+
+func (l *legacy) Thaw() error {
+	return l.cg.Thaw()
+}
+
+// Kill freezes the cgroup so that its processes cannot fork, sends them
+// SIGKILL and thaws it, as cgroup v1 has no equivalent of cgroup.kill.
+func (l *legacy) Kill() error {
+	return l.kill(l.Procs)
+}
+
+// kill sends SIGKILL to the processes listed by procs while the cgroup is
+// frozen.
+func (l *legacy) kill(procs func(recursive bool) ([]uint64, error)) error {
+	if err := l.cg.Freeze(); err != nil {
+		return err
+	}
+	pids, err := procs(true)
+	if err == nil {
+		for _, pid := range pids {
+			if kerr := unix.Kill(int(pid), unix.SIGKILL); kerr != nil && kerr != unix.ESRCH {
+				err = kerr
+			}
+		}
+	}
+	if terr := l.cg.Thaw(); err == nil {
+		err = terr
+	}
+	return err
+}
+
+func (l *legacy) Delete() error {
+	return l.cg.Delete()
+}
+
+// EventChan reports OOM events through the eventfd of the memory subsystem.
+// The kernel also signals it when the cgroup is removed, which ends the
+// stream.
+func (l *legacy) EventChan() (<-chan Event, <-chan error) {
+	ec := make(chan Event, 1)
+	errCh := make(chan error, 1)
+
+	fd, err := l.cg.OOMEventFD()
+	if err != nil {
+		errCh <- err
+		close(ec)
+		close(errCh)
+		return ec, errCh
+	}
+	go func() {
+		defer func() {
+			close(ec)
+			close(errCh)
+		}()
+		f := os.NewFile(fd, "oom-eventfd")
+		defer f.Close()
+
+		var oom uint64
+		buf := make([]byte, 8)
+		for {
+			if _, err := f.Read(buf); err != nil {
+				errCh <- err
+				return
+			}
+			if l.cg.State() == cgroup1.Deleted {
+				return
+			}
+			oom += binary.NativeEndian.Uint64(buf)
+			ev := Event{OOM: oom}
+			if m, err := l.cg.Stat(cgroup1.IgnoreNotExist); err == nil && m.MemoryOomControl != nil {
+				ev.OOMKill = m.MemoryOomControl.OomKill
+			}
+			ec <- ev
+		}
+	}()
+	return ec, errCh
+}
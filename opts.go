@@ -0,0 +1,125 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"path/filepath"
+
+	"github.com/containerd/cgroups/v3/cgroup1"
+)
+
+// Driver selects how cgroups are created and removed.
+type Driver int
+
+const (
+	// FS manages cgroups directly through the cgroup filesystem.
+	FS Driver = iota
+	// Systemd manages cgroups as transient systemd units. The cgroup path is
+	// then the slice and the name of the unit, e.g. "system.slice/foo.scope".
+	Systemd
+)
+
+// InitOpts allows configuration for the creation or loading of a cgroup
+type InitOpts func(*InitConfig) error
+
+// InitConfig provides configuration options for the creation or loading of
+// a cgroup
+type InitConfig struct {
+	driver     Driver
+	mode       CGMode
+	hierarchy  cgroup1.Hierarchy
+	mountpoint string
+	strict     bool
+}
+
+func newInitConfig(opts []InitOpts) (*InitConfig, error) {
+	c := &InitConfig{}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.mode == Unavailable {
+		c.mode = Mode()
+	}
+	if c.mountpoint == "" {
+		mounts, _ := Mounts()
+		c.mountpoint = defaultMountpoint(c.mode, mounts)
+	}
+	return c, nil
+}
+
+// defaultMountpoint returns the mountpoint of the cgroup v2 hierarchy among
+// the mounts, or its standard location in the given mode.
+func defaultMountpoint(mode CGMode, mounts []Mount) string {
+	for _, m := range mounts {
+		if m.Version == 2 {
+			return m.Mountpoint
+		}
+	}
+	if mode == Hybrid {
+		return filepath.Join(unifiedMountpoint, "unified")
+	}
+	return unifiedMountpoint
+}
+
+// WithDriver sets the driver managing the cgroup, FS by default.
+func WithDriver(d Driver) InitOpts {
+	return func(c *InitConfig) error {
+		c.driver = d
+		return nil
+	}
+}
+
+// WithMode overrides the cgroups mode detected on the host.
+func WithMode(m CGMode) InitOpts {
+	return func(c *InitConfig) error {
+		c.mode = m
+		return nil
+	}
+}
+
+// WithHierarchy sets the cgroup v1 subsystems, used in the Legacy and Hybrid
+// modes. The default is cgroup1.Default, or cgroup1.Systemd with the Systemd
+// driver.
+func WithHierarchy(h cgroup1.Hierarchy) InitOpts {
+	return func(c *InitConfig) error {
+		c.hierarchy = h
+		return nil
+	}
+}
+
+// WithStrictResources makes New and Update fail with an error matching
+// ErrNotSupported, instead of logging a warning, when resources that have no
+// cgroup v2 equivalent would be ignored in the Unified mode, e.g. the leaf
+// weights of the block IO.
+func WithStrictResources() InitOpts {
+	return func(c *InitConfig) error {
+		c.strict = true
+		return nil
+	}
+}
+
+// WithMountpoint sets the cgroup v2 mountpoint, used in the Unified and
+// Hybrid modes. The default is the cgroup2 mount of the mount namespace, or
+// /sys/fs/cgroup (/sys/fs/cgroup/unified in the Hybrid mode) without one.
+func WithMountpoint(path string) InitOpts {
+	return func(c *InitConfig) error {
+		c.mountpoint = path
+		return nil
+	}
+}
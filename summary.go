@@ -0,0 +1,350 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"cmp"
+	"math"
+	"os"
+	"slices"
+
+	v1 "github.com/containerd/cgroups/v3/cgroup1/stats"
+	v2 "github.com/containerd/cgroups/v3/cgroup2/stats"
+)
+
+// Summary holds the stats shared by cgroup v1 and v2, in the same units
+// whichever version they were read from. Fields that cannot be derived from
+// the source stats are left zero.
+type Summary struct {
+	CPU     CPUSummary
+	Memory  MemorySummary
+	IO      []IOSummary
+	Pids    PidsSummary
+	Hugetlb []HugetlbSummary
+	// Pressure is the pressure stall information, only provided by the
+	// unified hierarchy
+	Pressure PressureSummary
+}
+
+// CPUSummary holds the CPU time used and throttled, in nanoseconds.
+type CPUSummary struct {
+	// Usage is cpuacct.usage on v1 and usage_usec * 1000 on v2
+	Usage uint64
+	// User is the user time of cpuacct.stat on v1 and user_usec * 1000 on v2
+	User uint64
+	// System is the system time of cpuacct.stat on v1 and system_usec * 1000
+	// on v2
+	System uint64
+	// Periods is nr_periods of cpu.stat
+	Periods uint64
+	// ThrottledPeriods is nr_throttled of cpu.stat
+	ThrottledPeriods uint64
+	// ThrottledTime is throttled_time of cpu.stat on v1 and
+	// throttled_usec * 1000 on v2
+	ThrottledTime uint64
+}
+
+// MemorySummary holds the memory usage, in bytes, and the page faults.
+type MemorySummary struct {
+	// Usage is memory.usage_in_bytes on v1 and memory.current on v2
+	Usage uint64
+	// Limit is memory.limit_in_bytes on v1 and memory.max on v2, 0 when
+	// unlimited.
+	Limit uint64
+	// WorkingSet is Usage - inactive_file, or 0 when inactive_file exceeds
+	// Usage. total_inactive_file is used on v1.
+	WorkingSet uint64
+	// RSS is total_rss on v1 and anon on v2
+	RSS uint64
+	// Cache is total_cache on v1 and file on v2
+	Cache uint64
+	// Swap is memory.memsw.usage_in_bytes - Usage on v1, 0 when swap is not
+	// accounted, and memory.swap.current on v2
+	Swap uint64
+	// PageFaults is total_pgfault on v1 and pgfault on v2
+	PageFaults uint64
+	// MajorPageFaults is total_pgmajfault on v1 and pgmajfault on v2
+	MajorPageFaults uint64
+}
+
+// IOSummary holds the IO done on a device.
+type IOSummary struct {
+	Major uint64
+	Minor uint64
+	// ReadBytes and WriteBytes are the Read and Write entries of
+	// blkio.io_service_bytes_recursive on v1 and rbytes and wbytes on v2
+	ReadBytes  uint64
+	WriteBytes uint64
+	// ReadOps and WriteOps are the Read and Write entries of
+	// blkio.io_serviced_recursive on v1 and rios and wios on v2
+	ReadOps  uint64
+	WriteOps uint64
+}
+
+// PidsSummary holds pids.current and pids.max, 0 when unlimited.
+type PidsSummary struct {
+	Current uint64
+	Limit   uint64
+}
+
+// HugetlbSummary holds the usage of a huge page size, in bytes.
+type HugetlbSummary struct {
+	PageSize string
+	// Usage is hugetlb.<size>.usage_in_bytes on v1 and hugetlb.<size>.current
+	// on v2
+	Usage uint64
+	// Peak is hugetlb.<size>.max_usage_in_bytes on v1. v2 does not track it,
+	// it is 0.
+	Peak uint64
+	// Limit is hugetlb.<size>.max on v2, 0 when unlimited. It is not part of
+	// the v1 stats, it is 0.
+	Limit uint64
+	// Failcnt is hugetlb.<size>.failcnt on v1 and the max entry of
+	// hugetlb.<size>.events on v2
+	Failcnt uint64
+}
+
+// PressureSummary holds the pressure stall information of each resource, nil
+// when it is not available.
+type PressureSummary struct {
+	CPU    *PSISummary
+	Memory *PSISummary
+	IO     *PSISummary
+}
+
+// PSISummary holds the some and full lines of a <resource>.pressure file.
+type PSISummary struct {
+	Some PSIData
+	Full PSIData
+}
+
+// PSIData holds the share of time stalled over the last 10, 60 and 300
+// seconds, in percent, and the total time stalled, in microseconds.
+type PSIData struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	Total  uint64
+}
+
+// Summary returns the version neutral summary of the stats. In the Hybrid
+// mode, where both V1 and V2 are set, the stats of the v1 hierarchies are
+// completed with those only found in V2: the pressure stall information and
+// the controllers that are only enabled in the unified hierarchy.
+func (s *Stats) Summary() *Summary {
+	if s.V1 == nil {
+		return SummaryFromV2(s.V2)
+	}
+	sum := SummaryFromV1(s.V1)
+	if s.V2 == nil {
+		return sum
+	}
+	v2 := SummaryFromV2(s.V2)
+	if s.V1.GetCPU() == nil {
+		sum.CPU = v2.CPU
+	}
+	if s.V1.GetMemory() == nil {
+		sum.Memory = v2.Memory
+	}
+	if s.V1.GetBlkio() == nil {
+		sum.IO = v2.IO
+	}
+	if s.V1.GetPids() == nil {
+		sum.Pids = v2.Pids
+	}
+	if len(s.V1.GetHugetlb()) == 0 {
+		sum.Hugetlb = v2.Hugetlb
+	}
+	sum.Pressure = v2.Pressure
+	return sum
+}
+
+// SummaryFromV1 summarizes cgroup v1 metrics.
+func SummaryFromV1(m *v1.Metrics) *Summary {
+	s := &Summary{}
+	if m == nil {
+		return s
+	}
+	if cpu := m.GetCPU(); cpu != nil {
+		s.CPU = CPUSummary{
+			Usage:            cpu.GetUsage().GetTotal(),
+			User:             cpu.GetUsage().GetUser(),
+			System:           cpu.GetUsage().GetKernel(),
+			Periods:          cpu.GetThrottling().GetPeriods(),
+			ThrottledPeriods: cpu.GetThrottling().GetThrottledPeriods(),
+			ThrottledTime:    cpu.GetThrottling().GetThrottledTime(),
+		}
+	}
+	if mem := m.GetMemory(); mem != nil {
+		usage := mem.GetUsage().GetUsage()
+		s.Memory = MemorySummary{
+			Usage:           usage,
+			Limit:           v1MemoryLimit(mem.GetUsage().GetLimit()),
+			WorkingSet:      sub(usage, mem.GetTotalInactiveFile()),
+			RSS:             mem.GetTotalRSS(),
+			Cache:           mem.GetTotalCache(),
+			Swap:            sub(mem.GetSwap().GetUsage(), usage),
+			PageFaults:      mem.GetTotalPgFault(),
+			MajorPageFaults: mem.GetTotalPgMajFault(),
+		}
+	}
+	if blkio := m.GetBlkio(); blkio != nil {
+		devices := make(map[[2]uint64]*IOSummary)
+		device := func(e *v1.BlkIOEntry) *IOSummary {
+			key := [2]uint64{e.GetMajor(), e.GetMinor()}
+			d, ok := devices[key]
+			if !ok {
+				d = &IOSummary{Major: e.GetMajor(), Minor: e.GetMinor()}
+				devices[key] = d
+			}
+			return d
+		}
+		for _, e := range blkio.GetIoServiceBytesRecursive() {
+			switch e.GetOp() {
+			case "Read":
+				device(e).ReadBytes += e.GetValue()
+			case "Write":
+				device(e).WriteBytes += e.GetValue()
+			}
+		}
+		for _, e := range blkio.GetIoServicedRecursive() {
+			switch e.GetOp() {
+			case "Read":
+				device(e).ReadOps += e.GetValue()
+			case "Write":
+				device(e).WriteOps += e.GetValue()
+			}
+		}
+		for _, d := range devices {
+			s.IO = append(s.IO, *d)
+		}
+		sortIO(s.IO)
+	}
+	s.Pids = PidsSummary{
+		Current: m.GetPids().GetCurrent(),
+		Limit:   m.GetPids().GetLimit(),
+	}
+	for _, h := range m.GetHugetlb() {
+		s.Hugetlb = append(s.Hugetlb, HugetlbSummary{
+			PageSize: h.GetPagesize(),
+			Usage:    h.GetUsage(),
+			Peak:     h.GetMax(),
+			Failcnt:  h.GetFailcnt(),
+		})
+	}
+	return s
+}
+
+// SummaryFromV2 summarizes cgroup v2 metrics.
+func SummaryFromV2(m *v2.Metrics) *Summary {
+	s := &Summary{}
+	if m == nil {
+		return s
+	}
+	s.Pressure = PressureSummary{
+		CPU:    psiSummary(m.GetCPU().GetPSI()),
+		Memory: psiSummary(m.GetMemory().GetPSI()),
+		IO:     psiSummary(m.GetIo().GetPSI()),
+	}
+	if cpu := m.GetCPU(); cpu != nil {
+		s.CPU = CPUSummary{
+			Usage:            cpu.GetUsageUsec() * 1000,
+			User:             cpu.GetUserUsec() * 1000,
+			System:           cpu.GetSystemUsec() * 1000,
+			Periods:          cpu.GetNrPeriods(),
+			ThrottledPeriods: cpu.GetNrThrottled(),
+			ThrottledTime:    cpu.GetThrottledUsec() * 1000,
+		}
+	}
+	if mem := m.GetMemory(); mem != nil {
+		s.Memory = MemorySummary{
+			Usage:           mem.GetUsage(),
+			Limit:           v2Limit(mem.GetUsageLimit()),
+			WorkingSet:      sub(mem.GetUsage(), mem.GetInactiveFile()),
+			RSS:             mem.GetAnon(),
+			Cache:           mem.GetFile(),
+			Swap:            mem.GetSwapUsage(),
+			PageFaults:      mem.GetPgfault(),
+			MajorPageFaults: mem.GetPgmajfault(),
+		}
+	}
+	for _, e := range m.GetIo().GetUsage() {
+		s.IO = append(s.IO, IOSummary{
+			Major:      e.GetMajor(),
+			Minor:      e.GetMinor(),
+			ReadBytes:  e.GetRbytes(),
+			WriteBytes: e.GetWbytes(),
+			ReadOps:    e.GetRios(),
+			WriteOps:   e.GetWios(),
+		})
+	}
+	sortIO(s.IO)
+	s.Pids = PidsSummary{
+		Current: m.GetPids().GetCurrent(),
+		Limit:   v2Limit(m.GetPids().GetLimit()),
+	}
+	for _, h := range m.GetHugetlb() {
+		s.Hugetlb = append(s.Hugetlb, HugetlbSummary{
+			PageSize: h.GetPagesize(),
+			Usage:    h.GetCurrent(),
+			Limit:    v2Limit(h.GetMax()),
+			Failcnt:  h.GetFailcnt(),
+		})
+	}
+	return s
+}
+
+func psiSummary(psi *v2.PSIStats) *PSISummary {
+	if psi == nil {
+		return nil
+	}
+	data := func(d *v2.PSIData) PSIData {
+		return PSIData{Avg10: d.GetAvg10(), Avg60: d.GetAvg60(), Avg300: d.GetAvg300(), Total: d.GetTotal()}
+	}
+	return &PSISummary{Some: data(psi.GetSome()), Full: data(psi.GetFull())}
+}
+
+// v1MemoryLimit returns limit, or 0 when it is the v1 value for unlimited,
+// math.MaxInt64 rounded down to the page size.
+func v1MemoryLimit(limit uint64) uint64 {
+	if limit >= math.MaxInt64&^uint64(os.Getpagesize()-1) {
+		return 0
+	}
+	return limit
+}
+
+// v2Limit returns limit, or 0 when it is "max", read as math.MaxUint64.
+func v2Limit(limit uint64) uint64 {
+	if limit == math.MaxUint64 {
+		return 0
+	}
+	return limit
+}
+
+// sub returns a - b, or 0 when b is greater than a.
+func sub(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
+
+func sortIO(io []IOSummary) {
+	slices.SortFunc(io, func(a, b IOSummary) int {
+		return cmp.Or(cmp.Compare(a.Major, b.Major), cmp.Compare(a.Minor, b.Minor))
+	})
+}
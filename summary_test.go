@@ -0,0 +1,188 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	v1 "github.com/containerd/cgroups/v3/cgroup1/stats"
+	v2 "github.com/containerd/cgroups/v3/cgroup2/stats"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummaryFromV1(t *testing.T) {
+	s := SummaryFromV1(&v1.Metrics{
+		CPU: &v1.CPUStat{
+			Usage:      &v1.CPUUsage{Total: 3000, User: 2000, Kernel: 1000},
+			Throttling: &v1.Throttle{Periods: 10, ThrottledPeriods: 2, ThrottledTime: 500},
+		},
+		Memory: &v1.MemoryStat{
+			Usage:             &v1.MemoryEntry{Usage: 100, Limit: 1000},
+			Swap:              &v1.MemoryEntry{Usage: 130},
+			TotalInactiveFile: 40,
+			TotalRSS:          50,
+			TotalCache:        45,
+			TotalPgFault:      7,
+			TotalPgMajFault:   1,
+		},
+		Blkio: &v1.BlkIOStat{
+			IoServiceBytesRecursive: []*v1.BlkIOEntry{
+				{Major: 8, Minor: 0, Op: "Read", Value: 4096},
+				{Major: 8, Minor: 0, Op: "Write", Value: 8192},
+				{Major: 8, Minor: 0, Op: "Total", Value: 12288},
+				{Major: 7, Minor: 0, Op: "Read", Value: 512},
+			},
+			IoServicedRecursive: []*v1.BlkIOEntry{
+				{Major: 8, Minor: 0, Op: "Read", Value: 1},
+				{Major: 8, Minor: 0, Op: "Write", Value: 2},
+			},
+		},
+		Pids:    &v1.PidsStat{Current: 3, Limit: 0},
+		Hugetlb: []*v1.HugetlbStat{{Pagesize: "2MB", Usage: 2, Max: 4, Failcnt: 1}},
+	})
+	assert.Equal(t, &Summary{
+		CPU: CPUSummary{Usage: 3000, User: 2000, System: 1000, Periods: 10, ThrottledPeriods: 2, ThrottledTime: 500},
+		Memory: MemorySummary{
+			Usage:           100,
+			Limit:           1000,
+			WorkingSet:      60,
+			RSS:             50,
+			Cache:           45,
+			Swap:            30,
+			PageFaults:      7,
+			MajorPageFaults: 1,
+		},
+		IO: []IOSummary{
+			{Major: 7, Minor: 0, ReadBytes: 512},
+			{Major: 8, Minor: 0, ReadBytes: 4096, WriteBytes: 8192, ReadOps: 1, WriteOps: 2},
+		},
+		Pids:    PidsSummary{Current: 3},
+		Hugetlb: []HugetlbSummary{{PageSize: "2MB", Usage: 2, Peak: 4, Failcnt: 1}},
+	}, s)
+}
+
+func TestSummaryFromV1NoSwap(t *testing.T) {
+	s := SummaryFromV1(&v1.Metrics{
+		Memory: &v1.MemoryStat{
+			Usage:             &v1.MemoryEntry{Usage: 100},
+			Swap:              &v1.MemoryEntry{},
+			TotalInactiveFile: 150,
+		},
+	})
+	assert.Equal(t, uint64(0), s.Memory.Swap)
+	assert.Equal(t, uint64(0), s.Memory.WorkingSet)
+}
+
+func TestSummaryFromV2(t *testing.T) {
+	s := SummaryFromV2(&v2.Metrics{
+		CPU: &v2.CPUStat{
+			UsageUsec:     3,
+			UserUsec:      2,
+			SystemUsec:    1,
+			NrPeriods:     10,
+			NrThrottled:   2,
+			ThrottledUsec: 5,
+		},
+		Memory: &v2.MemoryStat{
+			Usage:        100,
+			UsageLimit:   math.MaxUint64,
+			InactiveFile: 40,
+			Anon:         50,
+			File:         45,
+			SwapUsage:    30,
+			Pgfault:      7,
+			Pgmajfault:   1,
+		},
+		Io: &v2.IOStat{Usage: []*v2.IOEntry{
+			{Major: 8, Minor: 0, Rbytes: 4096, Wbytes: 8192, Rios: 1, Wios: 2},
+			{Major: 7, Minor: 0, Rbytes: 512},
+		}},
+		Pids:    &v2.PidsStat{Current: 3, Limit: math.MaxUint64},
+		Hugetlb: []*v2.HugeTlbStat{{Pagesize: "2MB", Current: 2, Max: 4, Failcnt: 1}},
+	})
+	assert.Equal(t, &Summary{
+		CPU: CPUSummary{Usage: 3000, User: 2000, System: 1000, Periods: 10, ThrottledPeriods: 2, ThrottledTime: 5000},
+		Memory: MemorySummary{
+			Usage:           100,
+			Limit:           0,
+			WorkingSet:      60,
+			RSS:             50,
+			Cache:           45,
+			Swap:            30,
+			PageFaults:      7,
+			MajorPageFaults: 1,
+		},
+		IO: []IOSummary{
+			{Major: 7, Minor: 0, ReadBytes: 512},
+			{Major: 8, Minor: 0, ReadBytes: 4096, WriteBytes: 8192, ReadOps: 1, WriteOps: 2},
+		},
+		Pids:    PidsSummary{Current: 3},
+		Hugetlb: []HugetlbSummary{{PageSize: "2MB", Usage: 2, Limit: 4, Failcnt: 1}},
+	}, s)
+}
+
+func TestSummaryUnlimited(t *testing.T) {
+	// v1 reports an unlimited memory limit as math.MaxInt64 rounded down to
+	// the page size.
+	unlimited := uint64(math.MaxInt64) &^ uint64(os.Getpagesize()-1)
+	s := SummaryFromV1(&v1.Metrics{
+		Memory: &v1.MemoryStat{Usage: &v1.MemoryEntry{Limit: unlimited}},
+	})
+	assert.Equal(t, uint64(0), s.Memory.Limit)
+
+	s = SummaryFromV2(&v2.Metrics{
+		Memory:  &v2.MemoryStat{UsageLimit: math.MaxUint64},
+		Pids:    &v2.PidsStat{Limit: math.MaxUint64},
+		Hugetlb: []*v2.HugeTlbStat{{Pagesize: "2MB", Max: math.MaxUint64}},
+	})
+	assert.Equal(t, uint64(0), s.Memory.Limit)
+	assert.Equal(t, uint64(0), s.Pids.Limit)
+	assert.Equal(t, uint64(0), s.Hugetlb[0].Limit)
+}
+
+func TestStatsSummary(t *testing.T) {
+	s := &Stats{V2: &v2.Metrics{Pids: &v2.PidsStat{Current: 1}}}
+	assert.Equal(t, uint64(1), s.Summary().Pids.Current)
+	s = &Stats{V1: &v1.Metrics{Pids: &v1.PidsStat{Current: 2}}}
+	assert.Equal(t, uint64(2), s.Summary().Pids.Current)
+	assert.Equal(t, &Summary{}, (&Stats{}).Summary())
+}
+
+func TestStatsSummaryHybrid(t *testing.T) {
+	psi := &v2.PSIStats{Some: &v2.PSIData{Avg10: 1.5, Total: 10}, Full: &v2.PSIData{Total: 5}}
+	s := (&Stats{
+		V1: &v1.Metrics{
+			CPU:  &v1.CPUStat{Usage: &v1.CPUUsage{Total: 3000}},
+			Pids: &v1.PidsStat{Current: 2},
+		},
+		V2: &v2.Metrics{
+			CPU:     &v2.CPUStat{UsageUsec: 1, PSI: psi},
+			Pids:    &v2.PidsStat{Current: 1},
+			Hugetlb: []*v2.HugeTlbStat{{Pagesize: "2MB", Current: 2, Max: 4}},
+		},
+	}).Summary()
+	// The controllers of the v1 hierarchies take precedence.
+	assert.Equal(t, uint64(3000), s.CPU.Usage)
+	assert.Equal(t, uint64(2), s.Pids.Current)
+	// hugetlb is only enabled in the unified hierarchy.
+	assert.Equal(t, []HugetlbSummary{{PageSize: "2MB", Usage: 2, Limit: 4}}, s.Hugetlb)
+	assert.Equal(t, &PSISummary{Some: PSIData{Avg10: 1.5, Total: 10}, Full: PSIData{Total: 5}}, s.Pressure.CPU)
+	assert.Nil(t, s.Pressure.Memory)
+}
@@ -0,0 +1,193 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"errors"
+
+	"github.com/containerd/cgroups/v3/cgroup2"
+	"github.com/containerd/log"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// unified is a Cgroup managed with the cgroup2 package.
+type unified struct {
+	m *cgroup2.Manager
+	// strict is set by WithStrictResources.
+	strict bool
+}
+
+func newUnified(c *InitConfig, path string, resources *specs.LinuxResources) (Cgroup, error) {
+	if err := checkUnifiedResources(resources); err != nil {
+		return nil, err
+	}
+	u := &unified{strict: c.strict}
+	res, err := u.convert(resources)
+	if err != nil {
+		return nil, err
+	}
+	var m *cgroup2.Manager
+	if c.driver == Systemd {
+		slice, unit := splitUnit(path)
+		m, err = cgroup2.NewSystemd(slice, unit, -1, res, cgroup2.WithMountpoint(c.mountpoint))
+	} else {
+		m, err = cgroup2.NewManager(c.mountpoint, path, res)
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.m = m
+	return u, nil
+}
+
+func loadUnified(c *InitConfig, path string) (Cgroup, error) {
+	var (
+		m   *cgroup2.Manager
+		err error
+	)
+	if c.driver == Systemd {
+		slice, unit := splitUnit(path)
+		m, err = cgroup2.LoadSystemd(slice, unit, cgroup2.WithMountpoint(c.mountpoint))
+	} else {
+		m, err = cgroup2.Load(path, cgroup2.WithMountpoint(c.mountpoint))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &unified{m: m, strict: c.strict}, nil
+}
+
+// convert converts resources to cgroup v2. The resources that have no cgroup
+// v2 equivalent but are harmless to ignore are logged, or rejected with an
+// UnsupportedError by WithStrictResources.
+func (u *unified) convert(resources *specs.LinuxResources) (*cgroup2.Resources, error) {
+	res, warnings, err := cgroup2.ConvertResources(resources)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, w := range warnings {
+		if u.strict {
+			errs = append(errs, &UnsupportedError{Feature: w.Field, Mode: Unified})
+			continue
+		}
+		log.L.WithField("resource", w.Field).Warnf("Ignoring resource: %s", w.Reason)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// checkUnifiedResources reports the resources that cannot be applied on
+// cgroup v2. The ones that are harmless to ignore are left to convert.
+func checkUnifiedResources(resources *specs.LinuxResources) error {
+	if resources == nil {
+		return nil
+	}
+	if cpu := resources.CPU; cpu != nil {
+		if cpu.RealtimeRuntime != nil && *cpu.RealtimeRuntime != 0 || cpu.RealtimePeriod != nil && *cpu.RealtimePeriod != 0 {
+			return &UnsupportedError{Feature: "realtime CPU scheduling", Mode: Unified}
+		}
+	}
+	return nil
+}
+
+func (u *unified) Mode() CGMode {
+	return Unified
+}
+
+func (u *unified) Update(resources *specs.LinuxResources) error {
+	if err := checkUnifiedResources(resources); err != nil {
+		return err
+	}
+	res, err := u.convert(resources)
+	if err != nil {
+		return err
+	}
+	return u.m.Update(res)
+}
+
+func (u *unified) Add(pid uint64) error {
+	return u.m.AddProc(pid)
+}
+
+func (u *unified) Procs(recursive bool) ([]uint64, error) {
+	return u.m.Procs(recursive)
+}
+
+func (u *unified) Stat() (*Stats, error) {
+	m, err := u.m.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &Stats{V2: m}, nil
+}
+
+func (u *unified) Freeze() error {
+	return u.m.Freeze()
+}
+
+func (u *unified) Thaw() error {
+	return u.m.Thaw()
+}
+
+func (u *unified) Kill() error {
+	return u.m.Kill()
+}
+
+func (u *unified) Delete() error {
+	return u.m.Delete()
+}
+
+// EventChan forwards the events of cgroup2.Manager.EventChan. It stops on the
+// first error, which cgroup2 reports when the stream cannot go on, or when the
+// error channel of the manager is closed, and then closes both channels.
+func (u *unified) EventChan() (<-chan Event, <-chan error) {
+	in, inErr := u.m.EventChan()
+	ec := make(chan Event, 1)
+	errCh := make(chan error, 1)
+	forward := func(e cgroup2.Event) {
+		ec <- Event{Low: e.Low, High: e.High, Max: e.Max, OOM: e.OOM, OOMKill: e.OOMKill}
+	}
+	go func() {
+		defer func() {
+			close(ec)
+			close(errCh)
+		}()
+		for {
+			select {
+			case e := <-in:
+				forward(e)
+			case err, ok := <-inErr:
+				if ok {
+					errCh <- err
+					return
+				}
+				// The last event is sent before the error channel is closed.
+				select {
+				case e := <-in:
+					forward(e)
+				default:
+				}
+				return
+			}
+		}
+	}()
+	return ec, errCh
+}
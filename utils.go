@@ -17,14 +17,13 @@
 package cgroups
 
 import (
-	"bufio"
-	"fmt"
 	"io"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 
+	"github.com/containerd/cgroups/v3/internal/proc"
+
 	"github.com/moby/sys/userns"
 	"golang.org/x/sys/unix"
 )
@@ -50,30 +49,88 @@ const (
 	Unified
 )
 
-// Mode returns the cgroups mode running on the host
+func (m CGMode) String() string {
+	switch m {
+	case Legacy:
+		return "legacy"
+	case Hybrid:
+		return "hybrid"
+	case Unified:
+		return "unified"
+	}
+	return "unavailable"
+}
+
+// Mode returns the cgroups mode running on the host, from the filesystems
+// mounted at /sys/fs/cgroup. The cgroup mounts below it can only downgrade
+// the mode, so that Unified always means cgroup v2 is mounted there.
 func Mode() CGMode {
 	checkMode.Do(func() {
-		var st unix.Statfs_t
-		if err := unix.Statfs(unifiedMountpoint, &st); err != nil {
-			cgMode = Unavailable
-			return
-		}
-		switch st.Type {
-		case unix.CGROUP2_SUPER_MAGIC:
-			cgMode = Unified
-		default:
-			cgMode = Legacy
-			if err := unix.Statfs(filepath.Join(unifiedMountpoint, "unified"), &st); err != nil {
-				return
-			}
-			if st.Type == unix.CGROUP2_SUPER_MAGIC {
-				cgMode = Hybrid
-			}
+		cgMode = statfsMode()
+		if mounts, err := Mounts(); err == nil {
+			cgMode = mountsMode(mounts, cgMode)
 		}
 	})
 	return cgMode
 }
 
+// mountsMode refines mode, found by statfsMode, with the cgroup mounts below
+// /sys/fs/cgroup: a Hybrid host without any cgroup2 mount there is Legacy.
+// Mounts elsewhere, e.g. a cgroup2 hierarchy mounted by a container runtime,
+// are ignored.
+func mountsMode(mounts []Mount, mode CGMode) CGMode {
+	if mode != Hybrid {
+		return mode
+	}
+	var v1, v2 bool
+	for _, m := range mounts {
+		if !strings.HasPrefix(m.Mountpoint, unifiedMountpoint+"/") {
+			continue
+		}
+		switch m.Version {
+		case 1:
+			v1 = true
+		case 2:
+			v2 = true
+		}
+	}
+	if v1 && !v2 {
+		return Legacy
+	}
+	return mode
+}
+
+// statfsMode returns the cgroups mode from the filesystems mounted at the
+// standard mountpoints.
+func statfsMode() CGMode {
+	var st unix.Statfs_t
+	if err := unix.Statfs(unifiedMountpoint, &st); err != nil {
+		return Unavailable
+	}
+	if st.Type == unix.CGROUP2_SUPER_MAGIC {
+		return Unified
+	}
+	if err := unix.Statfs(filepath.Join(unifiedMountpoint, "unified"), &st); err == nil && st.Type == unix.CGROUP2_SUPER_MAGIC {
+		return Hybrid
+	}
+	return Legacy
+}
+
+// Mount is a cgroup v1 or v2 mount.
+type Mount = proc.Mount
+
+// Mounts returns the cgroup v1 and v2 mounts of the mount namespace of the
+// process, from /proc/self/mountinfo.
+func Mounts() ([]Mount, error) {
+	return proc.ParseMountInfo("/proc/self/mountinfo")
+}
+
+// MountsFromReader returns the cgroup mounts of a /proc/<pid>/mountinfo file
+// read from r.
+func MountsFromReader(r io.Reader) ([]Mount, error) {
+	return proc.ParseMountInfoFromReader(r)
+}
+
 // RunningInUserNS detects whether we are currently running in a user namespace.
 // Copied from github.com/lxc/lxd/shared/util.go
 //
@@ -85,40 +142,11 @@ func RunningInUserNS() bool {
 // ParseCgroupFileUnified returns legacy subsystem paths as the first value,
 // and returns the unified path as the second value.
 func ParseCgroupFileUnified(path string) (map[string]string, string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, "", err
-	}
-	defer f.Close()
-	return ParseCgroupFromReaderUnified(f)
+	return proc.ParseCgroupFile(path)
 }
 
 // ParseCgroupFromReaderUnified returns legacy subsystem paths as the first value,
 // and returns the unified path as the second value.
 func ParseCgroupFromReaderUnified(r io.Reader) (map[string]string, string, error) {
-	var (
-		cgroups = make(map[string]string)
-		unified = ""
-		s       = bufio.NewScanner(r)
-	)
-	for s.Scan() {
-		var (
-			text  = s.Text()
-			parts = strings.SplitN(text, ":", 3)
-		)
-		if len(parts) < 3 {
-			return nil, unified, fmt.Errorf("invalid cgroup entry: %q", text)
-		}
-		for _, subs := range strings.Split(parts[1], ",") {
-			if subs == "" {
-				unified = parts[2]
-			} else {
-				cgroups[subs] = parts[2]
-			}
-		}
-	}
-	if err := s.Err(); err != nil {
-		return nil, unified, err
-	}
-	return cgroups, unified, nil
+	return proc.ParseCgroupFromReader(r)
 }
@@ -48,3 +48,37 @@ func TestParseCgroupFromReaderUnified(t *testing.T) {
 		t.Fatalf("expected %q, got %q", unifiedExpected, unified)
 	}
 }
+
+func TestMountsMode(t *testing.T) {
+	var (
+		memory  = Mount{Mountpoint: "/sys/fs/cgroup/memory", Version: 1, Controllers: []string{"memory"}}
+		systemd = Mount{Mountpoint: "/sys/fs/cgroup/systemd", Version: 1, Controllers: []string{"name=systemd"}}
+		unified = Mount{Mountpoint: "/sys/fs/cgroup/unified", Version: 2}
+		other   = Mount{Mountpoint: "/run/cgroup2", Version: 2}
+		root    = Mount{Mountpoint: "/sys/fs/cgroup", Version: 2}
+	)
+	for _, tc := range []struct {
+		mounts []Mount
+		statfs CGMode
+		mode   CGMode
+	}{
+		{nil, Unavailable, Unavailable},
+		{nil, Legacy, Legacy},
+		{[]Mount{systemd, memory}, Legacy, Legacy},
+		{[]Mount{unified, systemd, memory}, Hybrid, Hybrid},
+		{[]Mount{root}, Unified, Unified},
+		// a named hierarchy, e.g. mounted for containers expecting one
+		{[]Mount{unified, systemd}, Hybrid, Hybrid},
+		{[]Mount{systemd, memory}, Hybrid, Legacy},
+		// a cgroup2 hierarchy mounted outside of /sys/fs/cgroup
+		{[]Mount{systemd, memory, other}, Legacy, Legacy},
+		{[]Mount{systemd, memory, other}, Hybrid, Legacy},
+		// the mode is never upgraded
+		{[]Mount{unified}, Legacy, Legacy},
+		{[]Mount{root, memory}, Unified, Unified},
+	} {
+		if mode := mountsMode(tc.mounts, tc.statfs); mode != tc.mode {
+			t.Fatalf("expected %s for %v, got %s", tc.mode, tc.mounts, mode)
+		}
+	}
+}